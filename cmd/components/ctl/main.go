@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/config"
+	config_ "stream_hub/pkg/model/config"
+
+	"github.com/urfave/cli/v2"
+)
+
+// streamhubctl 调度器的运维命令行 直接读写 redis 和 mysql 不经过 admin 接口
+// 和其他组件一样从当前目录的 config/common.yaml config/scheduler.yaml 读取配置
+func main() {
+	env := new(ctlEnv)
+
+	app := &cli.App{
+		Name:  "streamhubctl",
+		Usage: "stream_hub scheduler operations",
+		Commands: []*cli.Command{
+			{
+				Name:  "tasks",
+				Usage: "inspect and manage scheduler tasks",
+				Subcommands: []*cli.Command{
+					queuesCommand(env),
+					dlqCommand(env),
+					inspectCommand(env),
+					requeueCommand(env),
+					enqueueCommand(env),
+					nodesCommand(env),
+					blacklistCommand(env),
+				},
+			},
+		},
+	}
+
+	withEnv(app.Commands, env)
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, "err:", err)
+		os.Exit(1)
+	}
+}
+
+// withEnv 真正执行的命令才连接 redis 和 mysql 只看帮助时不需要
+func withEnv(commands []*cli.Command, env *ctlEnv) {
+	for _, command := range commands {
+		if command.Action != nil {
+			command.Before = env.init
+		}
+		withEnv(command.Subcommands, env)
+	}
+}
+
+// ctlEnv 子命令共用的配置和连接
+type ctlEnv struct {
+	commonConf    *config_.CommonConfig
+	schedulerConf *config_.SchedulerConfig
+	inspector     *core.Inspector
+}
+
+func (e *ctlEnv) init(*cli.Context) error {
+	commonConf, err := config.NewCommonConfig()
+	if err != nil {
+		return err
+	}
+
+	schedulerConf, err := config.NewSchedulerConfig()
+	if err != nil {
+		return err
+	}
+
+	// 内存模式的任务只在 scheduler 进程里 命令行看不到
+	if schedulerConf.Broker == "memory" {
+		return fmt.Errorf("broker is memory, nothing to inspect")
+	}
+
+	db, err := infra.NewMysql(commonConf)
+	if err != nil {
+		return err
+	}
+
+	e.commonConf = commonConf
+	e.schedulerConf = schedulerConf
+	e.inspector = core.NewInspector(db, infra.NewRedis(commonConf), schedulerConf)
+
+	return nil
+}
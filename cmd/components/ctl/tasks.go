@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/model/api"
+	infra_ "stream_hub/pkg/model/infra"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func queuesCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:  "queues",
+		Usage: "show queue depths and wait times",
+		Action: func(c *cli.Context) error {
+			stats, err := env.inspector.QueueStats(c.Context)
+			if err != nil {
+				return err
+			}
+
+			w := newTable()
+			fmt.Fprintln(w, "QUEUE\tDEPTH\tDEQUEUED\tAVG WAIT\tOLDEST")
+			for _, priority := range sortedKeys(stats.Queues) {
+				wait := stats.Wait[priority]
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", priority, stats.Queues[priority], wait.Count,
+					time.Duration(wait.AvgMs)*time.Millisecond, time.Duration(wait.OldestMs)*time.Millisecond)
+			}
+			fmt.Fprintf(w, "delay\t%d\t\t\t\n", stats.Delay)
+			fmt.Fprintf(w, "running\t%d\t\t\t\n", stats.Running)
+			fmt.Fprintf(w, "dlq\t%d\t\t\t\n", stats.DeadLetter)
+
+			return w.Flush()
+		},
+	}
+}
+
+func dlqCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:  "dlq",
+		Usage: "show the latest dead letters",
+		Flags: []cli.Flag{
+			&cli.IntFlag{Name: "n", Value: 20, Usage: "number of failed tasks to show"},
+			&cli.BoolFlag{Name: "follow", Aliases: []string{"f"}, Usage: "keep printing new dead letters"},
+			&cli.DurationFlag{Name: "interval", Value: 2 * time.Second, Usage: "poll interval with --follow"},
+		},
+		Action: func(c *cli.Context) error {
+			seen := make(map[string]struct{})
+			var since time.Time
+
+			for {
+				resp, err := env.inspector.ListDeadLetters(c.Context, 1, c.Int("n"))
+				if err != nil {
+					return err
+				}
+
+				w := newTable()
+				for _, taskID := range resp.Pending {
+					if _, ok := seen[taskID]; ok {
+						continue
+					}
+					seen[taskID] = struct{}{}
+
+					detail, err := env.inspector.GetTask(c.Context, taskID)
+					if err != nil {
+						fmt.Fprintf(w, "pending\t%s\t\t\t\t%v\n", taskID, err)
+						continue
+					}
+					fmt.Fprintf(w, "pending\t%s\t%s\t%s\t%s\t%s\n", taskID, detail.Meta["type"], detail.Meta["biz_id"],
+						detail.Meta["retry_count"], detail.Meta["error_msg"])
+				}
+
+				// 按时间正序打印 新的在下面
+				latest := since
+				for i := len(resp.Tasks) - 1; i >= 0; i-- {
+					task := resp.Tasks[i]
+					if !task.UpdatedAt.After(since) {
+						continue
+					}
+					if task.UpdatedAt.After(latest) {
+						latest = task.UpdatedAt
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", task.UpdatedAt.Format(time.DateTime), task.ID, task.Type,
+						task.BizID, task.RetryCount, task.ErrorMsg)
+				}
+				since = latest
+
+				if err := w.Flush(); err != nil {
+					return err
+				}
+
+				if !c.Bool("follow") {
+					return nil
+				}
+
+				select {
+				case <-c.Context.Done():
+					return nil
+				case <-time.After(c.Duration("interval")):
+				}
+			}
+		},
+	}
+}
+
+func inspectCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:      "inspect",
+		Usage:     "show task:meta, task:payload and the task record",
+		ArgsUsage: "<task_id>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return cli.ShowSubcommandHelp(c)
+			}
+
+			detail, err := env.inspector.GetTask(c.Context, c.Args().First())
+			if err != nil {
+				return err
+			}
+
+			// payload 本身就是 JSON 原样输出 不转义
+			out := struct {
+				Meta    map[string]string `json:"meta"`
+				Payload json.RawMessage   `json:"payload,omitempty"`
+				Record  interface{}       `json:"record"`
+			}{
+				Meta:   detail.Meta,
+				Record: detail.Record,
+			}
+			if json.Valid([]byte(detail.Payload)) {
+				out.Payload = json.RawMessage(detail.Payload)
+			}
+
+			return printJSON(out)
+		},
+	}
+}
+
+func requeueCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:      "requeue",
+		Usage:     "requeue dead letters by id or by filter",
+		ArgsUsage: "[task_id...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "type", Usage: "task type"},
+			&cli.StringFlag{Name: "error", Usage: "substring of the error message"},
+			&cli.IntFlag{Name: "limit", Value: 100, Usage: "max tasks to requeue"},
+			&cli.BoolFlag{Name: "all", Usage: "requeue without filter"},
+			&cli.BoolFlag{Name: "dry-run", Usage: "only print the matched tasks"},
+		},
+		Action: func(c *cli.Context) error {
+			ids := c.Args().Slice()
+			filter := api.DeadLetterFilter{
+				Type:     c.String("type"),
+				ErrorMsg: c.String("error"),
+				Limit:    c.Int("limit"),
+			}
+
+			if len(ids) == 0 {
+				// 防止手滑把整个死信队列重投
+				if filter.Type == "" && filter.ErrorMsg == "" && !c.Bool("all") {
+					return fmt.Errorf("need task ids, --type, --error or --all")
+				}
+
+				found, err := env.inspector.FindDeadLetters(c.Context, filter)
+				if err != nil {
+					return err
+				}
+				ids = found
+			}
+
+			if c.Bool("dry-run") {
+				for _, taskID := range ids {
+					fmt.Println(taskID)
+				}
+				fmt.Printf("%d tasks matched\n", len(ids))
+				return nil
+			}
+
+			count := 0
+			for _, taskID := range ids {
+				if err := env.inspector.RequeueDeadLetter(c.Context, taskID); err != nil {
+					fmt.Fprintf(os.Stderr, "requeue %s err: %v\n", taskID, err)
+					continue
+				}
+				fmt.Println("requeued", taskID)
+				count++
+			}
+			fmt.Printf("%d/%d tasks requeued\n", count, len(ids))
+
+			return nil
+		},
+	}
+}
+
+func enqueueCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:      "enqueue",
+		Usage:     "send a task from JSON, e.g. '{\"type\":\"send_email_code\",\"biz_id\":\"1\",\"payload\":{}}'",
+		ArgsUsage: "[json]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Usage: "read the task from a file, - for stdin"},
+			&cli.DurationFlag{Name: "unique", Usage: "only one task of the same type and biz_id within this duration"},
+		},
+		Action: func(c *cli.Context) error {
+			data, err := readTask(c)
+			if err != nil {
+				return err
+			}
+
+			var message infra_.TaskMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				return err
+			}
+			if message.Type == "" {
+				return fmt.Errorf("task type is required")
+			}
+			if message.Priority == "" {
+				message.Priority = "default"
+			}
+			if _, ok := env.schedulerConf.Queue[message.Priority]; !ok {
+				return fmt.Errorf("unknown priority %s", message.Priority)
+			}
+
+			sender, err := infra.NewTaskSender(env.commonConf, env.schedulerConf)
+			if err != nil {
+				return err
+			}
+
+			opts := make([]infra.SendOption, 0)
+			if ttl := c.Duration("unique"); ttl > 0 {
+				opts = append(opts, infra.WithUnique(ttl))
+			}
+
+			if err := sender.SendTask(message, opts...); err != nil {
+				return err
+			}
+			fmt.Println("task sent")
+
+			return nil
+		},
+	}
+}
+
+func nodesCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:  "nodes",
+		Usage: "show registered nodes and their workers",
+		Action: func(c *cli.Context) error {
+			nodes, err := env.inspector.ListNodes(c.Context)
+			if err != nil {
+				return err
+			}
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+
+			w := newTable()
+			fmt.Fprintln(w, "NODE\tALIVE\tWORKERS\tACTIVE")
+			for _, node := range nodes {
+				active := int64(0)
+				for _, n := range node.Workers {
+					active += n
+				}
+				fmt.Fprintf(w, "%s\t%t\t%d\t%d\n", node.NodeID, node.Alive, len(node.Workers), active)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func blacklistCommand(env *ctlEnv) *cli.Command {
+	return &cli.Command{
+		Name:  "blacklist",
+		Usage: "show or purge circuit breakers of task types",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "show breakers that are not closed",
+				Action: func(c *cli.Context) error {
+					breakers, err := env.inspector.ListBlacklist(c.Context)
+					if err != nil {
+						return err
+					}
+
+					w := newTable()
+					fmt.Fprintln(w, "TYPE\tSTATE")
+					for _, taskType := range sortedKeys(breakers) {
+						fmt.Fprintf(w, "%s\t%s\n", taskType, breakers[taskType])
+					}
+
+					return w.Flush()
+				},
+			},
+			{
+				Name:      "purge",
+				Usage:     "close the breakers of the given types",
+				ArgsUsage: "[type...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "all", Usage: "purge all breakers"},
+				},
+				Action: func(c *cli.Context) error {
+					types := c.Args().Slice()
+					if c.Bool("all") {
+						breakers, err := env.inspector.ListBlacklist(c.Context)
+						if err != nil {
+							return err
+						}
+						types = sortedKeys(breakers)
+					}
+					if len(types) == 0 {
+						return fmt.Errorf("need task types or --all")
+					}
+
+					for _, taskType := range types {
+						if err := env.inspector.ClearBlacklist(c.Context, taskType); err != nil {
+							return err
+						}
+						fmt.Println("purged", taskType)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func readTask(c *cli.Context) ([]byte, error) {
+	switch file := c.String("file"); {
+	case file == "-":
+		return io.ReadAll(os.Stdin)
+	case file != "":
+		return os.ReadFile(file)
+	case c.NArg() == 1:
+		return []byte(c.Args().First()), nil
+	default:
+		return nil, fmt.Errorf("need the task json or --file")
+	}
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
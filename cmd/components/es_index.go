@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"stream_hub/internal/components/scheduler/task_handler"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/config"
+	"stream_hub/pkg/model/storage"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// es_index video 索引的运维命令
+// 不带参数: 建版本索引和别名 新建时从 mysql 全量同步
+// -reindex: 按最新 mapping 建新版本 拷贝数据 切别名 再补同步拷贝期间变化的视频 读写不停
+func main() {
+	reindex := flag.Bool("reindex", false, "rebuild the index with the current mapping and switch the alias")
+	deleteOld := flag.Bool("delete-old", false, "delete the old index after reindex")
+	flag.Parse()
+
+	commonConf, err := config.NewCommonConfig()
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+
+	schedulerConf, err := config.NewSchedulerConfig()
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+
+	base, err := infra.NewBase(commonConf, schedulerConf)
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+
+	handler := task_handler.NewCommonTaskHandler(commonConf, schedulerConf, base)
+
+	if !*reindex {
+		created, err := base.ES.Bootstrap(storage.VideoIndexMapping)
+		if err != nil {
+			fmt.Println("err:", err)
+			return
+		}
+		if !created {
+			fmt.Println("index already exists")
+			return
+		}
+
+		n, err := syncVideos(handler, base.DB.Model(&storage.VideoModel{}))
+		if err != nil {
+			fmt.Println("err:", err)
+			return
+		}
+		fmt.Printf("index created, %d videos synced\n", n)
+		return
+	}
+
+	// 往前留一点余量 防止和 mysql 的时间有偏差
+	since := time.Now().Add(-time.Minute)
+	index, old, err := base.ES.Reindex(context.Background(), storage.VideoIndexMapping)
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+	fmt.Printf("alias switched from %s to %s\n", old, index)
+
+	// 拷贝期间的写入只进了旧索引 包括软删除的视频
+	n, err := syncVideos(handler, base.DB.Unscoped().Model(&storage.VideoModel{}).
+		Where("updated_at >= ? or deleted_at >= ?", since, since))
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+	fmt.Printf("%d videos changed during reindex synced\n", n)
+
+	// 以别名命名的老索引在切换别名时已经删掉了
+	if old == base.ES.Alias() {
+		fmt.Printf("legacy index %s removed while switching the alias\n", old)
+	} else if *deleteOld && old != index {
+		if err := base.ES.DeleteIndex(old); err != nil {
+			fmt.Println("err:", err)
+			return
+		}
+		fmt.Printf("old index %s deleted\n", old)
+	}
+}
+
+func syncVideos(handler *task_handler.CommonTaskHandler, query *gorm.DB) (int, error) {
+	count := 0
+	var videos []storage.VideoModel
+	err := query.Select("id").FindInBatches(&videos, 500, func(tx *gorm.DB, batch int) error {
+		for _, video := range videos {
+			if err := handler.SyncVideo(context.Background(), video.ID); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}).Error
+
+	return count, err
+}
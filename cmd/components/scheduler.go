@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/gin-gonic/gin"
+	"os/signal"
+	"stream_hub/internal/components/scheduler/admin"
 	"stream_hub/internal/components/scheduler/core"
 	"stream_hub/internal/components/scheduler/task_handler"
 	"stream_hub/internal/infra"
+	"stream_hub/internal/security"
 	"stream_hub/pkg/config"
 	"stream_hub/pkg/constant"
+	"stream_hub/pkg/model/storage"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -22,31 +30,101 @@ func main() {
 		return
 	}
 
-	base, err := infra.NewBase(commonConf)
+	base, err := infra.NewBase(commonConf, schedulerConf)
 	if err != nil {
 		fmt.Println("err:", err)
 		return
 	}
 
-	handler := task_handler.NewCommonTaskHandler(commonConf, base)
+	// 没有别名时 handler 的第一次写入会自动建一个动态 mapping 的索引 先把版本索引和别名建好
+	if _, err := base.ES.Bootstrap(storage.VideoIndexMapping); err != nil {
+		fmt.Println("es bootstrap err:", err)
+	}
+
+	handler := task_handler.NewCommonTaskHandler(commonConf, schedulerConf, base)
+
+	// 内存模式调度器本身不访问 redis(TaskSender 只写任务记录) base.Redis 只剩验证码 handler 在用
+	rdb := base.Redis
+	if schedulerConf.Broker == "memory" {
+		rdb = nil
+	}
+	broker := core.NewBroker(rdb, schedulerConf)
 
-	server := core.NewServer(base.DB, base.Redis, schedulerConf)
+	server := core.NewServer(base.DB, rdb, broker, base.Logger, schedulerConf)
 
 	serveMux := core.NewServeMux()
+	serveMux.Use(core.Logging(base.Logger), core.Recovery())
 	serveMux.HandleFunc(constant.TaskSendEmailCode, handler.EmailHandler)
+	serveMux.HandleFunc(constant.TaskVideoProbe, handler.ProbeHandler)
 	serveMux.HandleFunc(constant.TaskVideoTranscode, handler.TranscodeHandler)
+	serveMux.HandleFunc(constant.TaskVideoThumbnail, handler.ThumbnailHandler)
+	serveMux.HandleFunc(constant.TaskVideoToES, handler.VideoToESHandler)
+	serveMux.HandleFunc(constant.TaskVideoAudit, handler.AuditHandler)
+	serveMux.HandleFunc(constant.TaskReconcileCounts, handler.ReconcileCountsHandler)
+	serveMux.HandleFunc(constant.TaskESReindex, handler.ESReindexHandler)
+	serveMux.HandleFunc(constant.TaskMultipartCleanup, handler.MultipartCleanupHandler)
 
 	server.RegisterServeMux(serveMux)
 
-	deadletter := core.NewDeadLetter(base.DB, base.Redis, schedulerConf)
-	dispatcher := core.NewDispatcher(base.Redis, schedulerConf)
-	janitor := core.NewJanitor(base.Redis, schedulerConf)
+	deadletter := core.NewDeadLetter(base.DB, broker, schedulerConf)
+	dispatcher := core.NewDispatcher(rdb, broker, schedulerConf)
+	janitor := core.NewJanitor(rdb, broker, base.Logger, schedulerConf)
+	relay := core.NewRelay(base.DB, rdb, broker, schedulerConf)
 
 	go deadletter.Start()
 	go dispatcher.Start()
 	go janitor.Run()
+	go relay.Start()
 
-	if err := server.Start(); err != nil {
-		fmt.Println("err:", err)
+	if schedulerConf.Cron.Enabled && rdb != nil {
+		cron, err := core.NewCron(base.TaskSender, base.Redis, schedulerConf)
+		if err != nil {
+			fmt.Println("err:", err)
+			return
+		}
+		go cron.Start()
+	}
+
+	if schedulerConf.Admin.Enabled && rdb != nil {
+		auth := security.NewAuth(commonConf)
+		adminRouter := admin.NewAdminRouter(base, schedulerConf, auth, handler.Auditor())
+		go func() {
+			if err := adminRouter.Run(); err != nil {
+				fmt.Println("admin err:", err)
+			}
+		}()
+	}
+
+	if schedulerConf.Metrics.Enabled {
+		router := gin.New()
+		core.Metrics.Mount(router, "/metrics")
+		go func() {
+			if err := router.Run(fmt.Sprintf(":%d", schedulerConf.Metrics.Port)); err != nil {
+				fmt.Println("metrics err:", err)
+			}
+		}()
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Start()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			fmt.Println("err:", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Duration(schedulerConf.DrainTimeout)*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		fmt.Println("shutdown err:", err)
 	}
 }
@@ -21,7 +21,13 @@ func main() {
 		return
 	}
 
-	base, err := infra.NewBase(commonConf)
+	schedulerConf, err := config.NewSchedulerConfig()
+	if err != nil {
+		fmt.Println("err:", err)
+		return
+	}
+
+	base, err := infra.NewBase(commonConf, schedulerConf)
 	if err != nil {
 		fmt.Println("err:", err)
 		return
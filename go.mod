@@ -16,6 +16,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/minio/minio-go/v7 v7.0.98
 	github.com/spf13/viper v1.21.0
+	github.com/urfave/cli/v2 v2.27.7
 	go-micro.dev/v4 v4.11.0
 	go.mongodb.org/mongo-driver v1.17.8
 	go.uber.org/zap v1.27.1
@@ -149,7 +150,6 @@ require (
 	github.com/tinylib/msgp v1.6.1 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.3.0 // indirect
-	github.com/urfave/cli/v2 v2.27.7 // indirect
 	github.com/xanzy/ssh-agent v0.3.0 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
@@ -0,0 +1,241 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 审核结论对应的视频状态 review 不改变状态
+var decisionStatus = map[string]int{
+	constant.AuditApprove: constant.VideoApproved,
+	constant.AuditReject:  constant.VideoRejected,
+	constant.AuditBan:     constant.VideoBanned,
+}
+
+// Auditor 视频审核 自动审核由 video_audit 任务调用 Audit 人工审核调用 Decide
+// 每次结论都写一条 VideoAuditModel
+type Auditor struct {
+	*infra.Base
+	pipeline *Pipeline
+}
+
+// NewAuditor 默认只有敏感词检查 画面审核等其它检查器通过 Register 注册
+func NewAuditor(base *infra.Base, conf *config.SchedulerConfig) *Auditor {
+	return &Auditor{
+		Base:     base,
+		pipeline: NewPipeline(NewWordChecker(conf.Audit.BlockWords, conf.Audit.ReviewWords)),
+	}
+}
+
+func (a *Auditor) Register(checker Checker) {
+	a.pipeline.Register(checker)
+}
+
+// Audit 自动审核 只处理待审核的视频 已经转人工的不重复审核
+func (a *Auditor) Audit(ctx context.Context, videoID string) error {
+	var video storage.VideoModel
+	if err := a.DB.WithContext(ctx).Where("id = ?", videoID).First(&video).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if video.Status != constant.VideoChecking {
+		return nil
+	}
+
+	var reviews int64
+	if err := a.DB.WithContext(ctx).Model(&storage.VideoAuditModel{}).
+		Where("video_id = ? and decision = ?", videoID, constant.AuditReview).Count(&reviews).Error; err != nil {
+		return err
+	}
+	if reviews > 0 {
+		return nil
+	}
+
+	var media storage.FileModel
+	if err := a.DB.WithContext(ctx).Where("file_path = ?", video.SourceObjectKey).First(&media).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return a.Decide(ctx, videoID, constant.AuditReview, constant.AuditSystem, "source file not found", nil)
+		}
+		return err
+	}
+
+	switch media.Status {
+	case constant.FileStatusFailed:
+		return a.Decide(ctx, videoID, constant.AuditReject, constant.AuditSystem, "invalid media: "+media.Reason, nil)
+	case constant.FileStatusTranscodeFinished:
+	default:
+		// 等转码完成 worker 延后重试 不计入熔断和重试次数
+		return fmt.Errorf("%w: file %s is not transcoded yet", errors_.TaskNotReady, media.ID)
+	}
+
+	var meta storage.VideoMeta
+	if len(media.Meta) > 0 {
+		if err := json.Unmarshal(media.Meta, &meta); err != nil {
+			return err
+		}
+	}
+
+	presignedURL, err := a.Minio.Client.PresignedGetObject(ctx, constant.VideoBucket, media.FilePath, time.Hour, nil)
+	if err != nil {
+		return fmt.Errorf("failed to generate presigned url: %w", err)
+	}
+
+	decision, reason, results, err := a.pipeline.Run(ctx, &Target{
+		VideoID:     video.ID,
+		Title:       video.Title,
+		Description: video.Description,
+		Input:       presignedURL.String(),
+		Duration:    meta.Duration,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.Decide(ctx, videoID, decision, constant.AuditSystem, reason, results)
+}
+
+// AuditMedia 审核引用文件的所有待审核视频 之后创建的视频由 CreateVideo 投递审核
+func (a *Auditor) AuditMedia(ctx context.Context, fileID string) error {
+	var media storage.FileModel
+	if err := a.DB.WithContext(ctx).Where("id = ?", fileID).First(&media).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var videos []string
+	if err := a.DB.WithContext(ctx).Model(&storage.VideoModel{}).
+		Where("source_object_key = ? and status = ?", media.FilePath, constant.VideoChecking).
+		Pluck("id", &videos).Error; err != nil {
+		return err
+	}
+
+	for _, id := range videos {
+		if err := a.Audit(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decide 记录审核结论并更新视频状态 状态变化时同步 es
+// 自动审核只作用于待审核的视频 人工结论可以覆盖之前的结论(比如封禁已经通过的视频)
+func (a *Auditor) Decide(ctx context.Context, videoID, decision, reviewerID, reason string, results []Result) error {
+	record := &storage.VideoAuditModel{
+		VideoID:    videoID,
+		Decision:   decision,
+		ReviewerID: reviewerID,
+		Reason:     truncate(reason, 255),
+	}
+	if len(results) > 0 {
+		details, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		record.Details = details
+	}
+
+	return a.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var video storage.VideoModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", videoID).First(&video).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors_.RecordNotFound
+			}
+			return err
+		}
+
+		// 自动审核执行期间人工已经处理过了
+		if reviewerID == constant.AuditSystem && video.Status != constant.VideoChecking {
+			return nil
+		}
+
+		record.FromStatus = video.Status
+		record.ToStatus = video.Status
+		if status, ok := decisionStatus[decision]; ok {
+			record.ToStatus = status
+		}
+
+		if record.ToStatus != record.FromStatus {
+			if err := tx.Model(&storage.VideoModel{}).Where("id = ?", videoID).Update("status", record.ToStatus).Error; err != nil {
+				return err
+			}
+
+			if err := a.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+				Type:     constant.TaskVideoToES,
+				BizID:    videoID,
+				Priority: "critical",
+				Payload: infra_.TaskPayload{
+					Operator: reviewerID,
+					Action:   constant.ActionUpdate,
+					Source:   constant.Video,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(record).Error
+	})
+}
+
+// ListReviews 等待人工审核的视频 按上传时间先进先出
+func (a *Auditor) ListReviews(ctx context.Context, page, size int) (int64, []storage.VideoModel, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 || size > 100 {
+		size = 20
+	}
+
+	db := a.DB.WithContext(ctx).Model(&storage.VideoModel{}).
+		Where("status = ?", constant.VideoChecking).
+		Where("exists (?)", a.DB.Model(&storage.VideoAuditModel{}).Select("1").
+			Where("video_audits.video_id = user_videos.id and video_audits.decision = ?", constant.AuditReview))
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+
+	var videos []storage.VideoModel
+	if err := db.Order("created_at asc").Limit(size).Offset((page - 1) * size).Find(&videos).Error; err != nil {
+		return 0, nil, err
+	}
+
+	return total, videos, nil
+}
+
+// History 视频的所有审核记录
+func (a *Auditor) History(ctx context.Context, videoID string) ([]storage.VideoAuditModel, error) {
+	var records []storage.VideoAuditModel
+	if err := a.DB.WithContext(ctx).Where("video_id = ?", videoID).Order("created_at asc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
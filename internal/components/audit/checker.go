@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"context"
+	"stream_hub/pkg/constant"
+)
+
+// Target 送审的内容 Input 是原视频的临时下载链接 只审文本的检查器用不到
+type Target struct {
+	VideoID     string
+	Title       string
+	Description string
+	Input       string
+	Duration    float64
+}
+
+// Result 单个检查器的结论 Decision 为 approve / review / reject
+type Result struct {
+	Checker  string `json:"checker"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Checker 审核检查器 返回 error 表示检查本身失败(网络等) 任务会重试
+type Checker interface {
+	Name() string
+	Check(ctx context.Context, target *Target) (*Result, error)
+}
+
+// Pipeline 按注册顺序执行检查器 有一个拒绝就拒绝(后面的不再执行) 有一个需要人工就转人工 都通过才自动通过
+// 便宜的检查器放在前面
+type Pipeline struct {
+	checkers []Checker
+}
+
+func NewPipeline(checkers ...Checker) *Pipeline {
+	return &Pipeline{checkers: checkers}
+}
+
+func (p *Pipeline) Register(checker Checker) {
+	p.checkers = append(p.checkers, checker)
+}
+
+// Run 返回最终结论 起决定作用的检查器的原因 以及所有检查器的结果
+func (p *Pipeline) Run(ctx context.Context, target *Target) (string, string, []Result, error) {
+	decision := constant.AuditApprove
+	reason := ""
+	results := make([]Result, 0, len(p.checkers))
+
+	for _, checker := range p.checkers {
+		result, err := checker.Check(ctx, target)
+		if err != nil {
+			return "", "", nil, err
+		}
+		result.Checker = checker.Name()
+		results = append(results, *result)
+
+		switch result.Decision {
+		case constant.AuditReject:
+			return constant.AuditReject, result.Reason, results, nil
+		case constant.AuditReview:
+			if decision != constant.AuditReview {
+				decision, reason = constant.AuditReview, result.Reason
+			}
+		}
+	}
+
+	return decision, reason, results, nil
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"stream_hub/pkg/constant"
+	"stream_hub/pkg/model/config"
+	"strings"
+)
+
+// FrameClassifier 画面分类的扩展点 比如本地部署的鉴黄/暴恐模型或者云厂商的内容安全接口
+// frames 是截好的 jpg 本地路径 返回每一帧命中的违规标签 没有命中可以返回空
+type FrameClassifier interface {
+	Classify(ctx context.Context, frames []string) ([]Label, error)
+}
+
+// Label Score 取值 0-1 越大越可能违规
+type Label struct {
+	Frame string
+	Name  string
+	Score float64
+}
+
+// FrameChecker 从视频里均匀截取若干帧交给分类器 取得分最高的标签决定结论
+// 使用时注册到审核流程 handler.RegisterChecker(audit.NewFrameChecker(classifier, schedulerConf.Audit))
+type FrameChecker struct {
+	classifier  FrameClassifier
+	frames      int
+	reviewScore float64
+	rejectScore float64
+}
+
+func NewFrameChecker(classifier FrameClassifier, conf config.AuditConfig) *FrameChecker {
+	c := &FrameChecker{
+		classifier:  classifier,
+		frames:      conf.Frames,
+		reviewScore: conf.ReviewScore,
+		rejectScore: conf.RejectScore,
+	}
+	if c.frames <= 0 {
+		c.frames = 5
+	}
+	if c.reviewScore <= 0 {
+		c.reviewScore = 0.6
+	}
+	if c.rejectScore <= 0 {
+		c.rejectScore = 0.9
+	}
+
+	return c
+}
+
+func (f *FrameChecker) Name() string {
+	return "frame"
+}
+
+func (f *FrameChecker) Check(ctx context.Context, target *Target) (*Result, error) {
+	dir := filepath.Join("./tmp", target.VideoID+"_audit")
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	frames, err := f.extract(ctx, target, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := f.classifier.Classify(ctx, frames)
+	if err != nil {
+		return nil, err
+	}
+
+	var top *Label
+	for i := range labels {
+		if top == nil || labels[i].Score > top.Score {
+			top = &labels[i]
+		}
+	}
+	if top == nil || top.Score < f.reviewScore {
+		return &Result{Decision: constant.AuditApprove}, nil
+	}
+
+	reason := fmt.Sprintf("frame %s: %s %.2f", filepath.Base(top.Frame), top.Name, top.Score)
+	if top.Score >= f.rejectScore {
+		return &Result{Decision: constant.AuditReject, Reason: reason}, nil
+	}
+	return &Result{Decision: constant.AuditReview, Reason: reason}, nil
+}
+
+// extract 跳过开头和结尾 在中间均匀截取 frames 帧
+func (f *FrameChecker) extract(ctx context.Context, target *Target, dir string) ([]string, error) {
+	frames := make([]string, 0, f.frames)
+	for i := 1; i <= f.frames; i++ {
+		offset := target.Duration * float64(i) / float64(f.frames+1)
+		frame := filepath.Join(dir, fmt.Sprintf("frame%02d.jpg", i))
+
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-y",
+			"-ss", fmt.Sprintf("%.3f", offset),
+			"-i", target.Input,
+			"-frames:v", "1",
+			"-vf", "scale='min(640,iw)':-2",
+			"-q:v", "3",
+			frame,
+		)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+			return nil, fmt.Errorf("ffmpeg extract frame failed: %w: %s", err, lines[len(lines)-1])
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"stream_hub/pkg/constant"
+	"unicode"
+)
+
+// WordChecker 标题和简介的敏感词检查 命中 block 拒绝 命中 review 转人工
+// 匹配前去掉空格和标点 统一大小写和全角 "敏 感.词" 也能命中
+type WordChecker struct {
+	block  *trie
+	review *trie
+}
+
+func NewWordChecker(blockWords, reviewWords []string) *WordChecker {
+	return &WordChecker{
+		block:  newTrie(blockWords),
+		review: newTrie(reviewWords),
+	}
+}
+
+func (w *WordChecker) Name() string {
+	return "sensitive_word"
+}
+
+func (w *WordChecker) Check(ctx context.Context, target *Target) (*Result, error) {
+	text := normalize(target.Title + "\n" + target.Description)
+
+	if word, ok := w.block.find(text); ok {
+		return &Result{Decision: constant.AuditReject, Reason: fmt.Sprintf("sensitive word: %s", word)}, nil
+	}
+	if word, ok := w.review.find(text); ok {
+		return &Result{Decision: constant.AuditReview, Reason: fmt.Sprintf("suspicious word: %s", word)}, nil
+	}
+
+	return &Result{Decision: constant.AuditApprove}, nil
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	word     string // 非空表示到这里是一个完整的词
+}
+
+type trie struct {
+	root *trieNode
+}
+
+func newTrie(words []string) *trie {
+	t := &trie{root: &trieNode{children: make(map[rune]*trieNode)}}
+	for _, word := range words {
+		key := normalize(word)
+		if len(key) == 0 {
+			continue
+		}
+
+		node := t.root
+		for _, r := range key {
+			next, ok := node.children[r]
+			if !ok {
+				next = &trieNode{children: make(map[rune]*trieNode)}
+				node.children[r] = next
+			}
+			node = next
+		}
+		node.word = word
+	}
+
+	return t
+}
+
+// find 返回第一个命中的词
+func (t *trie) find(text []rune) (string, bool) {
+	for i := range text {
+		node := t.root
+		for _, r := range text[i:] {
+			next, ok := node.children[r]
+			if !ok {
+				break
+			}
+			if next.word != "" {
+				return next.word, true
+			}
+			node = next
+		}
+	}
+
+	return "", false
+}
+
+// normalize 全角转半角 转小写 去掉空白/标点/符号
+func normalize(s string) []rune {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == 0x3000:
+			continue
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+
+	return out
+}
@@ -44,6 +44,11 @@ func (cv *CounterVector) WithLabelValues(vals ...string) (*Counter, error) {
     cv.mu.Lock()
     defer cv.mu.Unlock()
 
+    // 拿写锁之前可能已经被别的协程创建了
+    if counter, ok := cv.counters[key]; ok {
+        return counter, nil
+    }
+
     counter = &Counter{}
     cv.counters[key] = counter
 
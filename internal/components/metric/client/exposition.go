@@ -0,0 +1,169 @@
+package metric_sdk
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (cv *CounterVector) name() string {
+	return cv.Name
+}
+
+func (cv *CounterVector) labels() []string {
+	return cv.labelNames
+}
+
+func (cv *CounterVector) write(w *bufio.Writer) {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	writeHeader(w, cv.Name, cv.Help, "counter")
+	for _, key := range sortedKeys(cv.counters) {
+		writeSample(w, cv.Name, cv.labelNames, splitKey(key), nil, float64(cv.counters[key].Load()))
+	}
+}
+
+func (gv *GaugeVector) name() string {
+	return gv.Name
+}
+
+func (gv *GaugeVector) labels() []string {
+	return gv.labelNames
+}
+
+func (gv *GaugeVector) write(w *bufio.Writer) {
+	gv.mu.RLock()
+	defer gv.mu.RUnlock()
+
+	writeHeader(w, gv.Name, gv.Help, "gauge")
+	for _, key := range sortedKeys(gv.gauges) {
+		writeSample(w, gv.Name, gv.labelNames, splitKey(key), nil, gv.gauges[key].Load())
+	}
+}
+
+func (hv *HistogramVector) name() string {
+	return hv.Name
+}
+
+func (hv *HistogramVector) labels() []string {
+	return hv.labelNames
+}
+
+// write 每个桶输出的是小于等于上界的累计数量
+func (hv *HistogramVector) write(w *bufio.Writer) {
+	hv.mu.RLock()
+	defer hv.mu.RUnlock()
+
+	writeHeader(w, hv.Name, hv.Help, "histogram")
+	for _, key := range sortedKeys(hv.histograms) {
+		values := splitKey(key)
+		buckets, sum, count := hv.histograms[key].snapshot()
+
+		cumulative := uint64(0)
+		for i, boundary := range hv.histograms[key].bucketBoundaries {
+			cumulative += buckets[i]
+			writeSample(w, hv.Name+"_bucket", hv.labelNames, values, []string{"le", formatFloat(boundary)}, float64(cumulative))
+		}
+		writeSample(w, hv.Name+"_sum", hv.labelNames, values, nil, sum)
+		writeSample(w, hv.Name+"_count", hv.labelNames, values, nil, float64(count))
+	}
+}
+
+func (sv *SummaryVector) name() string {
+	return sv.Name
+}
+
+func (sv *SummaryVector) labels() []string {
+	return sv.labelNames
+}
+
+func (sv *SummaryVector) write(w *bufio.Writer) {
+	sv.mu.RLock()
+	defer sv.mu.RUnlock()
+
+	writeHeader(w, sv.Name, sv.Help, "summary")
+	for _, key := range sortedKeys(sv.summaries) {
+		values := splitKey(key)
+		quantiles, sum, count := sv.summaries[key].quantiles(sv.objectives)
+
+		for i, q := range sv.objectives {
+			writeSample(w, sv.Name, sv.labelNames, values, []string{"quantile", formatFloat(q)}, quantiles[i])
+		}
+		writeSample(w, sv.Name+"_sum", sv.labelNames, values, nil, sum)
+		writeSample(w, sv.Name+"_count", sv.labelNames, values, nil, float64(count))
+	}
+}
+
+func (h *Histogram) snapshot() ([]uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]uint64{}, h.buckets...), h.sum, h.count
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// writeSample extra 是额外的一对标签 比如直方图的 le
+func writeSample(w *bufio.Writer, name string, labelNames, values, extra []string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || len(extra) > 0 {
+		w.WriteByte('{')
+		for i, label := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if len(extra) == 2 {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra[0] + `="` + escapeLabel(extra[1]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// 标签值要转义反斜杠 双引号 换行 HELP 只转义反斜杠和换行
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func splitKey(key string) []string {
+	return strings.Split(key, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,88 @@
+package metric_sdk
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GaugeVector 可增可减的瞬时值 比如队列长度
+type GaugeVector struct {
+	mu sync.RWMutex
+
+	Name       string
+	Help       string
+	labelNames []string
+
+	gauges map[string]*Gauge
+}
+
+func NewGaugeVector(options GaugeVectorOptions) *GaugeVector {
+	return &GaugeVector{
+		Name:       options.Name,
+		Help:       options.Help,
+		labelNames: options.Labels,
+		gauges:     make(map[string]*Gauge),
+	}
+}
+
+func (gv *GaugeVector) WithLabelValues(vals ...string) (*Gauge, error) {
+	if len(vals) != len(gv.labelNames) {
+		return nil, errors.New("label count mismatch")
+	}
+
+	key := strings.Join(vals, "\xff")
+
+	gv.mu.RLock()
+	gauge, ok := gv.gauges[key]
+	gv.mu.RUnlock()
+
+	if ok {
+		return gauge, nil
+	}
+
+	gv.mu.Lock()
+	defer gv.mu.Unlock()
+
+	if gauge, ok := gv.gauges[key]; ok {
+		return gauge, nil
+	}
+
+	gauge = &Gauge{}
+	gv.gauges[key] = gauge
+
+	return gauge, nil
+}
+
+// Gauge float64 按位存在 uint64 里 用 CAS 更新
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&g.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
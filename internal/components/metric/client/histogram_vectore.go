@@ -44,6 +44,10 @@ func (hv *HistogramVector) WithLabelValues (vals ...string) (*Histogram, error)
     hv.mu.Lock()
     defer hv.mu.Unlock()
 
+    if histogram, ok := hv.histograms[key]; ok {
+        return histogram, nil
+    }
+
     histogram = newHistogram(hv.bucketBoundaries)
     hv.histograms[key] = histogram
 
@@ -60,7 +64,7 @@ type Histogram struct {
 
 func newHistogram(boundaries []float64) *Histogram {
 	return &Histogram{
-		bucketBoundaries: append(boundaries, math.Inf(1)),
+		bucketBoundaries: append(append([]float64{}, boundaries...), math.Inf(1)),
 		buckets: make([]uint64, len(boundaries) + 1),
 	}
 }
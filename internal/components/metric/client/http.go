@@ -0,0 +1,80 @@
+package metric_sdk
+
+import (
+	"strconv"
+	"stream_hub/pkg/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPMetrics gin 服务的 RED 指标 请求数 / 错误数(5xx) / 耗时
+// 状态码优先取 utils 响应函数记在 ctx 上的业务状态码 它们写出去的 HTTP 状态码总是 200
+// route 用路由模板(ctx.FullPath) 不用原始路径 否则路径参数会让标签无限增长
+type HTTPMetrics struct {
+	module   string
+	requests *CounterVector
+	errors   *CounterVector
+	latency  *HistogramVector
+}
+
+var httpLabels = []string{"module", "route", "method", "status"}
+
+// NewHTTPMetrics 指标注册到 metrics 上 一个 Metrics 只能注册一次
+func NewHTTPMetrics(metrics *Metrics, module string) *HTTPMetrics {
+	h := &HTTPMetrics{
+		module: module,
+		requests: NewCounterVector(CounterVectorOptions{
+			Name:   "http_requests_total",
+			Help:   "HTTP requests by route template, method and status class.",
+			Labels: httpLabels,
+		}),
+		errors: NewCounterVector(CounterVectorOptions{
+			Name:   "http_request_errors_total",
+			Help:   "HTTP requests answered with a 5xx status.",
+			Labels: httpLabels,
+		}),
+		latency: NewHistogramVector(HistogramVectorOptions{
+			Name:              "http_request_duration_seconds",
+			Help:              "HTTP request latency including all middlewares.",
+			Labels:            httpLabels,
+			BucketsBoundaries: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}),
+	}
+	metrics.MustRegister(h.requests, h.errors, h.latency)
+
+	return h
+}
+
+// Middleware 放在最前面 后面中间件直接中断的请求(限流/鉴权)也会被记录
+func (h *HTTPMetrics) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ctx.Writer.Status()
+		if code, ok := ctx.Get(utils.StatusKey); ok {
+			if code, ok := code.(int); ok {
+				status = code
+			}
+		}
+		labels := []string{h.module, route, ctx.Request.Method, strconv.Itoa(status/100) + "xx"}
+
+		if counter, err := h.requests.WithLabelValues(labels...); err == nil {
+			counter.Inc()
+		}
+		if status >= 500 {
+			if counter, err := h.errors.WithLabelValues(labels...); err == nil {
+				counter.Inc()
+			}
+		}
+		if histogram, err := h.latency.WithLabelValues(labels...); err == nil {
+			histogram.Observe(time.Since(start).Seconds())
+		}
+	}
+}
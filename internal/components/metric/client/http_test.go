@@ -0,0 +1,46 @@
+package metric_sdk
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"stream_hub/pkg/utils"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHTTPMetricsStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	m := NewMetrics()
+	h := NewHTTPMetrics(m, "test")
+
+	router := gin.New()
+	router.Use(h.Middleware())
+	router.GET("/ok", func(ctx *gin.Context) { utils.StatusOK(ctx, nil, "ok") })
+	router.GET("/bad/:id", func(ctx *gin.Context) { utils.BadRequest(ctx, utils.MessageBadRequest) })
+	router.GET("/fail", func(ctx *gin.Context) { utils.InternalServerError(ctx) })
+	// 没有经过 utils 的响应退回到 HTTP 状态码
+	router.GET("/raw", func(ctx *gin.Context) { ctx.Status(http.StatusServiceUnavailable) })
+
+	for _, path := range []string{"/ok", "/bad/1", "/bad/2", "/fail", "/raw", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 耗时不固定 只比较计数
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "http_requests_total") || strings.HasPrefix(line, "http_request_errors_total") {
+			lines = append(lines, line)
+		}
+	}
+
+	golden(t, "http", []byte(strings.Join(lines, "\n")+"\n"))
+}
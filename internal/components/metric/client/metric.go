@@ -1,5 +1,133 @@
 package metric_sdk
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Collector 可以注册到 Metrics 的指标 CounterVector / GaugeVector / HistogramVector / SummaryVector
+type Collector interface {
+	name() string
+	labels() []string
+	write(w *bufio.Writer)
+}
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Metrics 指标注册表 按名字排序输出 Prometheus 文本格式
 type Metrics struct {
-	
-}
\ No newline at end of file
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Register 名字不能重复
+func (m *Metrics) Register(collectors ...Collector) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, collector := range collectors {
+		if err := validate(collector); err != nil {
+			return err
+		}
+		if _, ok := m.collectors[collector.name()]; ok {
+			return fmt.Errorf("metric %s already registered", collector.name())
+		}
+	}
+
+	for _, collector := range collectors {
+		m.collectors[collector.name()] = collector
+	}
+
+	return nil
+}
+
+// MustRegister 用在包初始化 名字重复是代码问题
+func (m *Metrics) MustRegister(collectors ...Collector) {
+	if err := m.Register(collectors...); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.collectors))
+	for name := range m.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, m.collectors[name])
+	}
+	m.mu.RUnlock()
+
+	buf := bufio.NewWriter(w)
+	for _, collector := range collectors {
+		collector.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// Handler GET /metrics
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Mount 挂到任意 gin 路由上 比如 metrics.Mount(router, "/metrics")
+func (m *Metrics) Mount(router gin.IRoutes, path string) {
+	router.GET(path, gin.WrapH(m.Handler()))
+}
+
+// Serve 在单独的端口上暴露 /metrics 不和对外的业务接口共用端口
+func (m *Metrics) Serve(port int) error {
+	router := gin.New()
+	m.Mount(router, "/metrics")
+
+	return router.Run(fmt.Sprintf(":%d", port))
+}
+
+// validate 名字要符合 Prometheus 的规则 le / quantile 留给直方图和 summary 自己用 __ 开头的是保留标签
+func validate(collector Collector) error {
+	if !metricNameRe.MatchString(collector.name()) {
+		return fmt.Errorf("invalid metric name %q", collector.name())
+	}
+
+	reserved := ""
+	switch collector.(type) {
+	case *HistogramVector:
+		reserved = "le"
+	case *SummaryVector:
+		reserved = "quantile"
+	}
+
+	for _, label := range collector.labels() {
+		if !labelNameRe.MatchString(label) || strings.HasPrefix(label, "__") || label == reserved {
+			return fmt.Errorf("invalid label name %q of metric %s", label, collector.name())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package metric_sdk
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// go test ./internal/components/metric/client -update 重新生成 testdata 下的文件
+var update = flag.Bool("update", false, "update golden files")
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func writeText(t *testing.T, collectors ...Collector) []byte {
+	t.Helper()
+
+	m := NewMetrics()
+	if err := m.Register(collectors...); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestCounterVector(t *testing.T) {
+	cv := NewCounterVector(CounterVectorOptions{
+		Name:   "test_requests_total",
+		Help:   "Requests by path.\nSecond line with a \\ backslash.",
+		Labels: []string{"path", "code"},
+	})
+
+	c, _ := cv.WithLabelValues("/b", "200")
+	c.Add(3)
+	c, _ = cv.WithLabelValues("/a", "500")
+	c.Inc()
+	c, _ = cv.WithLabelValues(`/q"x"`+"\n"+`\y`, "200")
+	c.Inc()
+
+	if _, err := cv.WithLabelValues("/a"); err == nil {
+		t.Error("expected label count mismatch")
+	}
+
+	golden(t, "counter", writeText(t, cv))
+}
+
+func TestGaugeVector(t *testing.T) {
+	labelled := NewGaugeVector(GaugeVectorOptions{
+		Name:   "test_queue_depth",
+		Help:   "Tasks waiting in each queue.",
+		Labels: []string{"queue"},
+	})
+	plain := NewGaugeVector(GaugeVectorOptions{
+		Name: "test_temperature",
+		Help: "Gauge without labels.",
+	})
+
+	g, _ := labelled.WithLabelValues("critical")
+	g.Set(5)
+	g.Inc()
+	g, _ = labelled.WithLabelValues("low")
+	g.Add(2.5)
+	g.Dec()
+
+	g, _ = plain.WithLabelValues()
+	g.Set(-1.25)
+
+	golden(t, "gauge", writeText(t, plain, labelled))
+}
+
+func TestHistogramVector(t *testing.T) {
+	hv := NewHistogramVector(HistogramVectorOptions{
+		Name:              "test_duration_seconds",
+		Help:              "Latency.",
+		Labels:            []string{"method"},
+		BucketsBoundaries: []float64{0.1, 0.5, 1},
+	})
+
+	h, _ := hv.WithLabelValues("GET")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(v)
+	}
+	h, _ = hv.WithLabelValues("POST")
+	h.Observe(0.5)
+
+	golden(t, "histogram", writeText(t, hv))
+}
+
+func TestSummaryVector(t *testing.T) {
+	sv := NewSummaryVector(SummaryVectorOptions{
+		Name:       "test_size_bytes",
+		Help:       "Response size.",
+		Labels:     []string{"route"},
+		Objectives: []float64{0.99, 0.5, 0.9},
+		MaxAge:     time.Hour,
+		MaxSamples: 10,
+	})
+
+	s, _ := sv.WithLabelValues("/a")
+	// 超过 MaxSamples 的观测值只计入 _sum 和 _count
+	for i := 1; i <= 15; i++ {
+		s.Observe(float64(i))
+	}
+	// 没有观测值的分位数是 NaN
+	_, _ = sv.WithLabelValues("/empty")
+
+	golden(t, "summary", writeText(t, sv))
+}
+
+func TestRegisterValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		collector Collector
+	}{
+		{"metric name", NewCounterVector(CounterVectorOptions{Name: "1_bad"})},
+		{"metric name dash", NewGaugeVector(GaugeVectorOptions{Name: "bad-name"})},
+		{"label name", NewCounterVector(CounterVectorOptions{Name: "ok_total", Labels: []string{"bad-label"}})},
+		{"reserved label", NewGaugeVector(GaugeVectorOptions{Name: "ok", Labels: []string{"__name"}})},
+		{"histogram le", NewHistogramVector(HistogramVectorOptions{Name: "ok_seconds", Labels: []string{"le"}})},
+		{"summary quantile", NewSummaryVector(SummaryVectorOptions{Name: "ok_bytes", Labels: []string{"quantile"}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewMetrics().Register(tt.collector); err == nil {
+				t.Errorf("expected %s to be rejected", tt.name)
+			}
+		})
+	}
+
+	// le 只对直方图保留
+	if err := NewMetrics().Register(NewCounterVector(CounterVectorOptions{Name: "ok_total", Labels: []string{"le"}})); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+
+	m := NewMetrics()
+	m.MustRegister(NewCounterVector(CounterVectorOptions{Name: "dup_total"}))
+	if err := m.Register(NewGaugeVector(GaugeVectorOptions{Name: "dup_total"})); err == nil {
+		t.Error("expected duplicate name to be rejected")
+	}
+
+	// 一批里有一个不合法 整批都不注册
+	m = NewMetrics()
+	if err := m.Register(NewCounterVector(CounterVectorOptions{Name: "first_total"}), NewCounterVector(CounterVectorOptions{Name: "bad name"})); err == nil {
+		t.Error("expected batch to be rejected")
+	}
+	if err := m.Register(NewCounterVector(CounterVectorOptions{Name: "first_total"})); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cv := NewCounterVector(CounterVectorOptions{Name: "test_requests_total", Help: "Requests.", Labels: []string{"path"}})
+	c, _ := cv.WithLabelValues("/")
+	c.Inc()
+
+	m := NewMetrics()
+	m.MustRegister(cv)
+	router := gin.New()
+	m.Mount(router, "/metrics")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	golden(t, "mount", w.Body.Bytes())
+}
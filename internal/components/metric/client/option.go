@@ -1,5 +1,7 @@
 package metric_sdk
 
+import "time"
+
 type CounterVectorOptions struct {
 	Name string 
 	Help string 
@@ -11,4 +13,18 @@ type HistogramVectorOptions  struct {
 	Help string 
 	Labels []string
 	BucketsBoundaries []float64
-}
\ No newline at end of file
+}
+type GaugeVectorOptions struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+type SummaryVectorOptions struct {
+	Name       string
+	Help       string
+	Labels     []string
+	Objectives []float64     // 要输出的分位数 比如 0.5 0.9 0.99
+	MaxAge     time.Duration // 只用这段时间内的观测值计算分位数 0 表示 10 分钟
+	MaxSamples int           // 窗口内最多保留的观测值 0 表示 1024
+}
@@ -0,0 +1,153 @@
+package metric_sdk
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSummaryMaxAge     = 10 * time.Minute
+	defaultSummaryMaxSamples = 1024
+)
+
+// SummaryVector 客户端计算分位数 _sum 和 _count 是全部观测值的累计
+// 分位数只用最近 MaxAge 内的最多 MaxSamples 个观测值 超出的按时间先后丢弃
+type SummaryVector struct {
+	mu sync.RWMutex
+
+	Name       string
+	Help       string
+	labelNames []string
+	objectives []float64
+	maxAge     time.Duration
+	maxSamples int
+
+	summaries map[string]*Summary
+}
+
+func NewSummaryVector(options SummaryVectorOptions) *SummaryVector {
+	objectives := append([]float64{}, options.Objectives...)
+	sort.Float64s(objectives)
+
+	maxAge := options.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSummaryMaxAge
+	}
+	maxSamples := options.MaxSamples
+	if maxSamples <= 0 {
+		maxSamples = defaultSummaryMaxSamples
+	}
+
+	return &SummaryVector{
+		Name:       options.Name,
+		Help:       options.Help,
+		labelNames: options.Labels,
+		objectives: objectives,
+		maxAge:     maxAge,
+		maxSamples: maxSamples,
+		summaries:  make(map[string]*Summary),
+	}
+}
+
+func (sv *SummaryVector) WithLabelValues(vals ...string) (*Summary, error) {
+	if len(vals) != len(sv.labelNames) {
+		return nil, errors.New("label count mismatch")
+	}
+
+	key := strings.Join(vals, "\xff")
+
+	sv.mu.RLock()
+	summary, ok := sv.summaries[key]
+	sv.mu.RUnlock()
+
+	if ok {
+		return summary, nil
+	}
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if summary, ok := sv.summaries[key]; ok {
+		return summary, nil
+	}
+
+	summary = &Summary{
+		maxAge:  sv.maxAge,
+		samples: make([]sample, 0, sv.maxSamples),
+		limit:   sv.maxSamples,
+	}
+	sv.summaries[key] = summary
+
+	return summary, nil
+}
+
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+// Summary samples 是一个环形缓冲 next 指向最旧的位置
+type Summary struct {
+	mu      sync.Mutex
+	sum     float64
+	count   uint64
+	maxAge  time.Duration
+	samples []sample
+	limit   int
+	next    int
+}
+
+func (s *Summary) Observe(v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sum += v
+	s.count++
+
+	if len(s.samples) < s.limit {
+		s.samples = append(s.samples, sample{value: v, at: time.Now()})
+		return
+	}
+	s.samples[s.next] = sample{value: v, at: time.Now()}
+	s.next = (s.next + 1) % s.limit
+}
+
+// quantiles 窗口内没有观测值时分位数是 NaN 和 Prometheus 客户端一致
+func (s *Summary) quantiles(objectives []float64) ([]float64, float64, uint64) {
+	s.mu.Lock()
+	expire := time.Now().Add(-s.maxAge)
+	values := make([]float64, 0, len(s.samples))
+	for _, sample := range s.samples {
+		if sample.at.After(expire) {
+			values = append(values, sample.value)
+		}
+	}
+	sum, count := s.sum, s.count
+	s.mu.Unlock()
+
+	sort.Float64s(values)
+
+	result := make([]float64, len(objectives))
+	for i, q := range objectives {
+		if len(values) == 0 {
+			result[i] = math.NaN()
+			continue
+		}
+
+		// nearest rank
+		rank := int(math.Ceil(q*float64(len(values)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= len(values) {
+			rank = len(values) - 1
+		}
+		result[i] = values[rank]
+	}
+
+	return result, sum, count
+}
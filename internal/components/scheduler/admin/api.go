@@ -0,0 +1,247 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"stream_hub/internal/components/audit"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/internal/infra"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/api"
+	"stream_hub/pkg/model/config"
+	"stream_hub/pkg/utils"
+)
+
+type AdminApi struct {
+	inspector *core.Inspector
+	sender    *infra.TaskSender
+	auditor   *audit.Auditor
+}
+
+// NewAdminApi auditor 和审核任务的 handler 共用一个 注册的检查器在人工审核和自动审核里一致
+func NewAdminApi(base *infra.Base, conf *config.SchedulerConfig, auditor *audit.Auditor) *AdminApi {
+	return &AdminApi{
+		inspector: core.NewInspector(base.DB, base.Redis, conf),
+		sender:    base.TaskSender,
+		auditor:   auditor,
+	}
+}
+
+func (a *AdminApi) QueueStats(ctx *gin.Context) {
+	stats, err := a.inspector.QueueStats(context.Background())
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, stats, "get queue stats successfully")
+}
+
+func (a *AdminApi) GetTask(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	task, err := a.inspector.GetTask(context.Background(), req.TaskID)
+	if err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, task, "get task successfully")
+}
+
+func (a *AdminApi) CancelTask(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if err := a.sender.Cancel(context.Background(), req.TaskID); err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, nil, "cancel task successfully")
+}
+
+func (a *AdminApi) GetWorkflow(ctx *gin.Context) {
+	var req api.WorkflowIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	workflow, err := a.inspector.GetWorkflow(context.Background(), req.WorkflowID)
+	if err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, workflow, "get workflow successfully")
+}
+
+func (a *AdminApi) ListDeadLetters(ctx *gin.Context) {
+	var req api.ListDeadLetterReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	resp, err := a.inspector.ListDeadLetters(context.Background(), req.Page, req.Size)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, resp, "list dead letters successfully")
+}
+
+func (a *AdminApi) RequeueDeadLetter(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if err := a.inspector.RequeueDeadLetter(context.Background(), req.TaskID); err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, nil, "requeue task successfully")
+}
+
+func (a *AdminApi) RequeueDeadLetters(ctx *gin.Context) {
+	var req api.DeadLetterFilter
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	requeued, err := a.inspector.RequeueDeadLetters(context.Background(), req)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, api.RequeueDeadLettersResp{Requeued: requeued}, "requeue tasks successfully")
+}
+
+func (a *AdminApi) DeleteDeadLetter(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if err := a.inspector.DeleteDeadLetter(context.Background(), req.TaskID); err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, nil, "delete dead letter successfully")
+}
+
+func (a *AdminApi) ListBlacklist(ctx *gin.Context) {
+	breakers, err := a.inspector.ListBlacklist(context.Background())
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, breakers, "list blacklist successfully")
+}
+
+func (a *AdminApi) ClearBlacklist(ctx *gin.Context) {
+	var req api.TaskTypeReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if err := a.inspector.ClearBlacklist(context.Background(), req.Type); err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, nil, "clear blacklist successfully")
+}
+
+func (a *AdminApi) ListNodes(ctx *gin.Context) {
+	nodes, err := a.inspector.ListNodes(context.Background())
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, nodes, "list nodes successfully")
+}
+
+func (a *AdminApi) ListReviews(ctx *gin.Context) {
+	var req api.ListReviewsReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	total, videos, err := a.auditor.ListReviews(context.Background(), req.Page, req.Size)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, api.ListReviewsResp{Total: total, Videos: videos}, "list reviews successfully")
+}
+
+func (a *AdminApi) AuditHistory(ctx *gin.Context) {
+	var req api.VideoIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	records, err := a.auditor.History(context.Background(), req.VideoID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, records, "get audit history successfully")
+}
+
+// DecideVideo 人工审核 审核人为当前登录用户
+func (a *AdminApi) DecideVideo(ctx *gin.Context) {
+	var uri api.VideoIDReq
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	var req api.AuditDecisionReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if err := a.auditor.Decide(context.Background(), uri.VideoID, req.Decision, ctx.GetString("user_id"), req.Reason, nil); err != nil {
+		a.handleError(ctx, err)
+		return
+	}
+
+	utils.StatusOK(ctx, nil, "audit video successfully")
+}
+
+func (a *AdminApi) handleError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errors_.TaskNotFound), errors.Is(err, errors_.TaskFinished), errors.Is(err, errors_.RecordNotFound),
+		errors.Is(err, errors_.TaskInWorkflow):
+		utils.BadRequest(ctx, err.Error())
+	default:
+		utils.InternalServerError(ctx)
+	}
+}
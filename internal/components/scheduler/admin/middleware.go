@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"stream_hub/internal/security"
+	"stream_hub/pkg/constant"
+	"stream_hub/pkg/utils"
+)
+
+type Middleware struct {
+	auth *security.Auth
+}
+
+func NewMiddleware(auth *security.Auth) *Middleware {
+	return &Middleware{
+		auth: auth,
+	}
+}
+
+func (m *Middleware) Auth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader("Authorization")
+		if token == "" {
+			utils.UnAuthorizationRequest(ctx, "need token")
+			return
+		}
+
+		claims, err := m.auth.ParseToken(token)
+		if err != nil {
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				utils.UnAuthorizationRequest(ctx, "token expired")
+				return
+			}
+
+			utils.UnAuthorizationRequest(ctx, "token invalid")
+			return
+		}
+
+		ctx.Set("user_id", claims.UserID)
+		ctx.Set("role", claims.Role)
+	}
+}
+
+// Admin 只允许管理员访问
+func (m *Middleware) Admin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString("role") != constant.RoleAdmin {
+			utils.Forbidden(ctx, "permission denied")
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Reviewer 审核员和管理员可以访问
+func (m *Middleware) Reviewer() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role := ctx.GetString("role")
+		if role != constant.RoleReviewer && role != constant.RoleAdmin {
+			utils.Forbidden(ctx, "permission denied")
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"stream_hub/internal/components/audit"
+	"stream_hub/internal/infra"
+	"stream_hub/internal/security"
+	"stream_hub/pkg/model/config"
+)
+
+type AdminRouter struct {
+	router     *gin.Engine
+	admin      *AdminApi
+	middleware *Middleware
+	port       int
+}
+
+func NewAdminRouter(base *infra.Base, conf *config.SchedulerConfig, auth *security.Auth, auditor *audit.Auditor) *AdminRouter {
+	r := new(AdminRouter)
+	r.admin = NewAdminApi(base, conf, auditor)
+	r.middleware = NewMiddleware(auth)
+	r.port = conf.Admin.Port
+	r.init()
+
+	return r
+}
+
+func (r *AdminRouter) init() {
+	r.router = gin.Default()
+	admin := r.router.Group("/admin").Use(r.middleware.Auth(), r.middleware.Admin())
+	{
+		admin.GET("/queues", r.admin.QueueStats)
+		admin.GET("/task/:task_id", r.admin.GetTask)
+		admin.DELETE("/task/:task_id", r.admin.CancelTask)
+		admin.GET("/workflow/:workflow_id", r.admin.GetWorkflow)
+
+		admin.GET("/dlq", r.admin.ListDeadLetters)
+		admin.POST("/dlq/requeue", r.admin.RequeueDeadLetters)
+		admin.POST("/dlq/:task_id/requeue", r.admin.RequeueDeadLetter)
+		admin.DELETE("/dlq/:task_id", r.admin.DeleteDeadLetter)
+
+		admin.GET("/blacklist", r.admin.ListBlacklist)
+		admin.DELETE("/blacklist/:type", r.admin.ClearBlacklist)
+
+		admin.GET("/nodes", r.admin.ListNodes)
+	}
+
+	// 人工审核 审核员和管理员都可以访问
+	audit := r.router.Group("/admin/audit").Use(r.middleware.Auth(), r.middleware.Reviewer())
+	{
+		audit.GET("/reviews", r.admin.ListReviews)
+		audit.GET("/videos/:video_id", r.admin.AuditHistory)
+		audit.POST("/videos/:video_id", r.admin.DecideVideo)
+	}
+}
+
+func (r *AdminRouter) Run() error {
+	return r.router.Run(fmt.Sprintf(":%d", r.port))
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"stream_hub/pkg/utils"
+
+	"gorm.io/gorm"
+)
+
+// BatchTracker 批量任务的完成判定 以 mysql 里子任务的状态为准
+// 子任务成功 进入死信 执行中被取消时都会检查一次 排队中被取消的由 relay 定期兜底
+type BatchTracker struct {
+	db       *infra.DB
+	broker   Broker
+	progress *Progress
+}
+
+func NewBatchTracker(db *infra.DB, broker Broker) *BatchTracker {
+	return &BatchTracker{
+		db:       db,
+		broker:   broker,
+		progress: NewProgress(db, broker),
+	}
+}
+
+// Check 子任务还没全部结束时只更新父任务进度 全部结束后完成父任务并投递回调
+// 多个子任务同时结束时只有一个能把父任务从待执行改掉 回调不会重复投递
+func (b *BatchTracker) Check(ctx context.Context, batchID string) error {
+	var counts []struct {
+		Status int8
+		Count  int64
+	}
+	if err := b.db.WithContext(ctx).Model(&storage.Task{}).
+		Select("status, count(*) as count").
+		Where("batch_id = ?", batchID).
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	result := infra_.BatchResult{BatchID: batchID}
+	pending := int64(0)
+	for _, count := range counts {
+		result.Total += count.Count
+		switch count.Status {
+		case constant.TaskPending:
+			pending = count.Count
+		case constant.TaskSuccess:
+			result.Success = count.Count
+		case constant.TaskFailed:
+			result.Failed = count.Count
+		case constant.TaskCancelled:
+			result.Cancelled = count.Count
+		}
+	}
+	if result.Total == 0 {
+		return nil
+	}
+
+	if pending > 0 {
+		return b.db.WithContext(ctx).Model(&storage.Task{}).
+			Where("id = ? and status = ?", batchID, constant.TaskPending).
+			Update("progress", (result.Total-pending)*100/result.Total).Error
+	}
+
+	return b.complete(ctx, batchID, &result)
+}
+
+func (b *BatchTracker) complete(ctx context.Context, batchID string, result *infra_.BatchResult) error {
+	var record storage.Task
+	if err := b.db.WithContext(ctx).Where("id = ? and type = ?", batchID, constant.TaskBatch).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if record.Status != constant.TaskPending {
+		return nil
+	}
+
+	var batch infra_.Batch
+	if err := json.Unmarshal([]byte(record.Payload), &batch); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	status, errMsg := constant.TaskSuccess, ""
+	if failed := result.Failed + result.Cancelled; failed > 0 {
+		status = constant.TaskFailed
+		errMsg = fmt.Sprintf("%d of %d tasks failed or cancelled", failed, result.Total)
+	}
+
+	var callback *infra_.TaskMessage
+	if err := b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&storage.Task{}).Where("id = ? and status = ?", batchID, constant.TaskPending).Updates(map[string]interface{}{
+			"status":    status,
+			"error_msg": errMsg,
+			"progress":  100,
+			"result":    string(data),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		// 别的子任务已经完成了父任务 或者父任务被取消了
+		if res.RowsAffected == 0 || batch.Callback == nil {
+			return nil
+		}
+
+		message := *batch.Callback
+		message.TaskID = utils.CreateID()
+		message.Payload.Data = data
+		if message.BizID == "" {
+			message.BizID = batch.BizID
+		}
+		if message.Priority == "" {
+			message.Priority = "default"
+		}
+
+		payload, err := json.Marshal(&message.Payload)
+		if err != nil {
+			return err
+		}
+
+		// 和父任务的状态一起落库 投递失败由 relay 补投
+		if err := tx.Create(&storage.Task{
+			BaseModel: storage.BaseModel{ID: message.TaskID},
+			Type:      message.Type,
+			BizID:     message.BizID,
+			Priority:  message.Priority,
+			Status:    constant.TaskPending,
+			Payload:   string(payload),
+			Timeout:   message.Timeout,
+		}).Error; err != nil {
+			return err
+		}
+		callback = &message
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("batch %s finished, success: %d, failed: %d, cancelled: %d\n", batchID, result.Success, result.Failed, result.Cancelled)
+	b.progress.finish(ctx, batchID, status, errMsg)
+
+	if callback == nil {
+		return nil
+	}
+	if err := b.broker.Enqueue(ctx, callback); err != nil {
+		log.Println("err:", err)
+		return nil
+	}
+
+	return b.db.WithContext(ctx).Model(&storage.Task{}).Where("id = ?", callback.TaskID).Update("enqueued", true).Error
+}
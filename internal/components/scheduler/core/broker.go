@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"time"
+)
+
+// Broker 调度器的任务存储 队列/延时/执行中/死信/熔断/控制通道都通过它操作
+// 任务的流转:
+// Enqueue -> 优先级队列 -> Dequeue -> worker 的 active 队列 -> Receive -> MarkRunning -> Release
+// 成功 Ack / 失败 Nack 后 Delay 或 DeadLetter
+type Broker interface {
+	// Enqueue 保存任务数据并放入优先级队列
+	Enqueue(ctx context.Context, task *infra_.TaskMessage) error
+	// Dequeue 按 priorities 的顺序从第一个不为空的优先级队列取出一个任务放进 worker 的 active 队列 超时返回 ErrNoTask
+	Dequeue(ctx context.Context, priorities []string, workerID string, timeout time.Duration) (string, error)
+	// Receive 从 worker 的 active 队列取出一个任务ID 超时返回 ErrNoTask
+	Receive(ctx context.Context, workerID string, timeout time.Duration) (string, error)
+	// Load 读取任务数据
+	Load(ctx context.Context, taskID string) (*infra_.TaskMessage, error)
+	// Meta 任务的原始 meta 包括执行节点等运行时信息 不存在返回空 map
+	Meta(ctx context.Context, taskID string) (map[string]string, error)
+	// UpdateMeta 更新 meta 里的部分字段 比如执行进度
+	UpdateMeta(ctx context.Context, taskID string, fields map[string]string) error
+	// Requeue worker 挂了 把它 active 队列里的任务放回优先级队列
+	Requeue(ctx context.Context, workerID string) error
+
+	// MarkRunning 记录执行节点 并放进执行中集合 deadline 之后会被 janitor 回收
+	// 任务数据已经不在了(被取消) 返回 TaskNotFound
+	MarkRunning(ctx context.Context, taskID, nodeID, workerID string, deadline time.Time) error
+	// Release 从执行中集合移除 返回 true 表示调用方拿到了任务的收尾权
+	// janitor 带上锁的 fence 不是最新的持有者时不移除 返回 ErrLockLost worker 不持锁 传零值
+	Release(ctx context.Context, taskID string, fence Fence) (bool, error)
+	// Expired 截止时间早于 now 的执行中任务
+	Expired(ctx context.Context, now time.Time, limit int) ([]string, error)
+
+	// Ack 任务结束(成功或者死信落库) 删除任务数据并释放去重锁
+	Ack(ctx context.Context, task *infra_.TaskMessage) error
+	// Nack 记录一次失败 返回累计的失败次数
+	Nack(ctx context.Context, taskID string, errMsg string) (int64, error)
+	// Delay 在 at 之后重新执行
+	Delay(ctx context.Context, taskID string, at time.Time) error
+	// PromoteDue 把到期的延时任务放回优先级队列 返回数量
+	PromoteDue(ctx context.Context, now time.Time, limit int) (int, error)
+	// PromoteAged 老化 from 队列里 before 之前入队的任务挪到 to 队列 下一个被取走 返回数量
+	PromoteAged(ctx context.Context, from, to string, before time.Time, limit int) (int, error)
+	// Depths 各个优先级队列和延时集合里的任务数
+	Depths(ctx context.Context, priorities []string) (queues map[string]int64, delayed int64, err error)
+	// WaitStats 各个优先级出队任务的累计等待次数和时间 从入队(或者被提升)开始算
+	WaitStats(ctx context.Context, priorities []string) (map[string]WaitStat, error)
+	// DeadLetter 放入死信队列
+	DeadLetter(ctx context.Context, taskID string) error
+	// ReceiveDeadLetter 从死信队列取出一个任务ID 超时返回 ErrNoTask
+	ReceiveDeadLetter(ctx context.Context, timeout time.Duration) (string, error)
+
+	// 按任务类型的熔断器 状态在集群内共享 返回值 prev/state 是调用前后的状态
+	// BreakerAllow 是否放行 open 超过 openTimeout 后转为 half_open 最多同时放行 probes 个探测任务
+	BreakerAllow(ctx context.Context, taskType string, openTimeout time.Duration, probes int) (prev, state string, allowed bool, err error)
+	// BreakerSuccess half_open 下成功 successThreshold 次后恢复 closed
+	BreakerSuccess(ctx context.Context, taskType string, successThreshold int) (prev, state string, err error)
+	// BreakerFailure closed 下 window 内失败 threshold 次转为 open half_open 下失败直接 open
+	BreakerFailure(ctx context.Context, taskType, taskID string, window time.Duration, threshold int) (prev, state string, err error)
+	// BreakerRelease 探测任务没有成功也没有失败就结束了(取消/没有 handler/退出/被回收) 归还 half_open 的探测名额
+	// since 是拿到名额的时间 之后重新进入过 half_open 的名额已经清零 不再归还
+	BreakerRelease(ctx context.Context, taskType string, since time.Time) error
+
+	// 按任务类型限流 集群内共享
+	// AcquireSlot 同时执行的任务数小于 limit 时占一个名额 名额到 deadline 自动失效 防止节点挂了名额泄漏
+	AcquireSlot(ctx context.Context, taskType, taskID string, limit int, deadline time.Time) (bool, error)
+	// ReleaseSlot 归还名额
+	ReleaseSlot(ctx context.Context, taskType, taskID string) error
+	// TakeToken 令牌桶 每秒补充 rate 个 最多存 burst 个
+	TakeToken(ctx context.Context, taskType string, rate float64, burst int) (bool, error)
+
+	// SendCancel 通知 nodeID 对应的节点取消任务
+	SendCancel(ctx context.Context, nodeID, taskID string) error
+	// ReceiveCancel 节点读取自己的控制通道 超时返回 ErrNoTask
+	ReceiveCancel(ctx context.Context, nodeID string, timeout time.Duration) (string, error)
+	// Publish 发布任务的进度事件 内存模式没有进程外的订阅方 直接丢弃
+	Publish(ctx context.Context, taskID string, event []byte) error
+
+	// 工作流的运行时状态 成员任务本身还是通过 Enqueue 投递
+	// StartWorkflow 记录成员数、还没投递的任务剩余的依赖数和每个任务的后继 已经存在时不做处理
+	StartWorkflow(ctx context.Context, workflowID string, total int, deps map[string]int, next map[string][]string) error
+	// CompleteWorkflow 记录一个成员成功 返回依赖全部满足可以投递的后继任务 所有成员都成功时 finished 为 true
+	// 同一个成员重复调用不会重复计数 工作流不在运行中时什么都不做
+	CompleteWorkflow(ctx context.Context, workflowID, taskID string) (ready []string, finished bool, err error)
+	// FailWorkflow 工作流标记失败 返回还没投递的任务 它们不会再被投递 工作流不在运行中时返回空
+	FailWorkflow(ctx context.Context, workflowID string) (waiting []string, err error)
+}
+
+// NewBroker 按 scheduler.yaml 的 broker 配置创建 memory 模式不需要 redis
+func NewBroker(rdb *infra.Redis, conf *config.SchedulerConfig) Broker {
+	if conf.Broker == "memory" {
+		return NewMemoryBroker()
+	}
+
+	return NewRedisBroker(rdb, conf)
+}
+
+// WaitStat 一个优先级累计的出队次数和等待时间
+type WaitStat struct {
+	Count int64
+	SumMs int64
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	errors_ "stream_hub/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Control node 的控制通道 其他节点(janitor)通过 Broker.SendCancel 发送任务ID
+// node 收到后取消对应任务的 context
+type Control struct {
+	mu      sync.Mutex
+	broker  Broker
+	nodeID  string
+	cancels map[string]context.CancelFunc
+}
+
+func NewControl(nodeID string, broker Broker) *Control {
+	return &Control{
+		broker:  broker,
+		nodeID:  nodeID,
+		cancels: make(map[string]context.CancelFunc),
+	}
+}
+
+func (c *Control) Register(taskID string, cancel context.CancelFunc) {
+	c.mu.Lock()
+	c.cancels[taskID] = cancel
+	c.mu.Unlock()
+}
+
+func (c *Control) Unregister(taskID string) {
+	c.mu.Lock()
+	delete(c.cancels, taskID)
+	c.mu.Unlock()
+}
+
+func (c *Control) Cancel(taskID string) bool {
+	c.mu.Lock()
+	cancel, ok := c.cancels[taskID]
+	delete(c.cancels, taskID)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+// CancelAll 取消本节点所有执行中的任务 节点退出时使用
+func (c *Control) CancelAll() {
+	c.mu.Lock()
+	cancels := c.cancels
+	c.cancels = make(map[string]context.CancelFunc)
+	c.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func (c *Control) Listen() {
+	for {
+		taskID, err := c.broker.ReceiveCancel(context.Background(), c.nodeID, 5*time.Second)
+		if err != nil {
+			if !errors.Is(err, errors_.ErrNoTask) {
+				log.Println("control err:", err)
+			}
+			continue
+		}
+
+		if c.Cancel(taskID) {
+			log.Printf("node %s cancelled task %s\n", c.nodeID, taskID)
+		}
+	}
+}
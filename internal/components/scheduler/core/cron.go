@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 单次最多回溯的触发次数 防止停机太久时一直往前算
+const maxCronBacktrack = 100000
+
+// 每个触发时间点投递时按 job 和时间点去重 上一次投递的任务还没结束时同一个时间点不会再投递一次
+const cronUniqueTTL = time.Hour * 24
+
+// 推进最后触发时间 只往后推 锁已经被别的节点拿到时返回 -1
+const cronAdvanceScript = fenceGuard + `
+local last = tonumber(redis.call("HGET", KEYS[2], ARGV[2]) or "0")
+if tonumber(ARGV[3]) <= last then
+	return 0
+end
+redis.call("HSET", KEYS[2], ARGV[2], ARGV[3])
+return 1
+`
+
+type CronJob struct {
+	Name     string
+	Schedule *CronSchedule
+	Message  infra_.TaskMessage
+	Catchup  string
+	next     time.Time
+}
+
+// Cron 定时任务 到点后把 TaskMessage 投递到普通队列
+// 每个 job 的最后触发时间记录在 redis 中，配合分布式锁保证集群内每个时间点只投递一次
+type Cron struct {
+	mu               sync.Mutex
+	rdb              *infra.Redis
+	sender           *infra.TaskSender
+	lock             *DistributedLock
+	jobs             map[string]*CronJob
+	lastKey          string
+	scanInterval     time.Duration
+	catchup          string
+	maxCatchup       int
+	misfireThreshold time.Duration
+}
+
+func NewCron(sender *infra.TaskSender, rdb *infra.Redis, conf *config.SchedulerConfig) (*Cron, error) {
+	c := &Cron{
+		rdb:              rdb,
+		sender:           sender,
+		lock:             NewDistributedLock(rdb, conf),
+		jobs:             make(map[string]*CronJob),
+		lastKey:          conf.Cron.LastKey,
+		scanInterval:     time.Duration(conf.Cron.ScanInterval) * time.Millisecond,
+		catchup:          conf.Cron.Catchup,
+		maxCatchup:       conf.Cron.MaxCatchup,
+		misfireThreshold: time.Duration(conf.Cron.MisfireThreshold) * time.Millisecond,
+	}
+
+	for _, job := range conf.Cron.Jobs {
+		if err := c.Register(job.Name, job.Spec, infra_.TaskMessage{
+			Type:     job.Type,
+			BizID:    job.BizID,
+			Priority: job.Priority,
+		}, job.Catchup); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Register 注册定时任务 catchup 为空时使用全局配置
+func (c *Cron) Register(name, spec string, message infra_.TaskMessage, catchup string) error {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("cron: %q never fires", spec)
+	}
+
+	if catchup == "" {
+		catchup = c.catchup
+	}
+	if message.Priority == "" {
+		message.Priority = "default"
+	}
+
+	c.mu.Lock()
+	c.jobs[name] = &CronJob{
+		Name:     name,
+		Schedule: schedule,
+		Message:  message,
+		Catchup:  catchup,
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Cron) Start() {
+	log.Println("cron is running")
+	ticker := time.NewTicker(c.scanInterval)
+	for {
+		select {
+		case now := <-ticker.C:
+			c.mu.Lock()
+			jobs := make([]*CronJob, 0, len(c.jobs))
+			for _, job := range c.jobs {
+				jobs = append(jobs, job)
+			}
+			c.mu.Unlock()
+
+			for _, job := range jobs {
+				if err := c.fire(job, now); err != nil {
+					log.Printf("cron %s err: %v\n", job.Name, err)
+				}
+			}
+		}
+	}
+}
+
+func (c *Cron) fire(job *CronJob, now time.Time) error {
+	// 本地先判断一下 没到点就不去抢锁
+	if !job.next.IsZero() && job.next.After(now) {
+		return nil
+	}
+
+	lease, err := c.lock.Lock("scheduler:cron:" + job.Name)
+	if err != nil {
+		if errors.Is(err, errors_.ErrKeyExists) {
+			return nil
+		}
+		return err
+	}
+	defer lease.Unlock()
+
+	ctx := lease.Context()
+	last, err := c.lastFired(ctx, job.Name, now)
+	if err != nil {
+		return err
+	}
+
+	ticks := c.dueTicks(job, last, now)
+	if len(ticks) == 0 {
+		job.next = job.Schedule.Next(last)
+		return nil
+	}
+
+	latest := ticks[len(ticks)-1]
+	for _, tick := range c.applyCatchup(job, ticks, now) {
+		// 锁丢了就不能再投递 否则会和其他节点重复
+		if err := lease.Check(); err != nil {
+			return err
+		}
+
+		// 先推进 last 再投递 同一个时间点不会被再次触发 代价是推进之后任务写库失败会丢掉这一次
+		advanced, err := c.advance(ctx, lease.Fence(), job.Name, tick)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			continue
+		}
+
+		if err := c.enqueue(job, tick); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.advance(ctx, lease.Fence(), job.Name, latest); err != nil {
+		return err
+	}
+
+	job.next = job.Schedule.Next(latest)
+	return nil
+}
+
+// advance 带 fencing token 记录最后触发时间 返回 false 表示这个时间点已经记录过了
+func (c *Cron) advance(ctx context.Context, fence Fence, name string, tick time.Time) (bool, error) {
+	n, err := c.rdb.Eval(ctx, cronAdvanceScript, []string{fence.Key, c.lastKey}, fence.Token, name, tick.Unix()).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, errors_.ErrLockLost
+	}
+
+	return n == 1, nil
+}
+
+// lastFired 第一次运行时没有记录 从上一个扫描周期开始算 避免启动时把历史的全部补一遍
+func (c *Cron) lastFired(ctx context.Context, name string, now time.Time) (time.Time, error) {
+	data, err := c.rdb.HGet(ctx, c.lastKey, name).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return now.Add(-c.scanInterval), nil
+		}
+		return time.Time{}, err
+	}
+
+	sec, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(sec, 0), nil
+}
+
+// dueTicks (last, now] 之间所有的触发时间点 按时间顺序
+func (c *Cron) dueTicks(job *CronJob, last, now time.Time) []time.Time {
+	ticks := make([]time.Time, 0)
+	tick := job.Schedule.Next(last)
+	for i := 0; i < maxCronBacktrack && !tick.IsZero() && !tick.After(now); i++ {
+		ticks = append(ticks, tick)
+		tick = job.Schedule.Next(tick)
+	}
+
+	return ticks
+}
+
+func (c *Cron) applyCatchup(job *CronJob, ticks []time.Time, now time.Time) []time.Time {
+	latest := ticks[len(ticks)-1]
+	switch job.Catchup {
+	case constant.CatchupAll:
+		if c.maxCatchup > 0 && len(ticks) > c.maxCatchup {
+			return ticks[len(ticks)-c.maxCatchup:]
+		}
+		return ticks
+	case constant.CatchupSkip:
+		if now.Sub(latest) > c.misfireThreshold {
+			log.Printf("cron %s skip %d missed ticks\n", job.Name, len(ticks))
+			return nil
+		}
+		return ticks[len(ticks)-1:]
+	default:
+		return ticks[len(ticks)-1:]
+	}
+}
+
+func (c *Cron) enqueue(job *CronJob, tick time.Time) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"cron": job.Name,
+		"tick": tick.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s:%d", job.Name, tick.Unix())
+	message := job.Message
+	if message.BizID == "" {
+		message.BizID = key
+	}
+	message.Payload = infra_.TaskPayload{
+		Source: constant.Scheduler,
+		Data:   data,
+	}
+
+	log.Printf("cron %s fired at %s\n", job.Name, tick.Format(time.DateTime))
+	return c.sender.SendTask(message, infra.WithUnique(cronUniqueTTL), infra.WithUniqueKey("cron:"+key), infra.WithCoalesce())
+}
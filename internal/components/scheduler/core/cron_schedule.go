@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule 标准 5 段 cron 表达式: 分 时 日 月 周
+// 支持 * , - / 以及 @yearly @monthly @weekly @daily @hourly
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronBound struct {
+	min, max int
+}
+
+var (
+	minuteBound = cronBound{0, 59}
+	hourBound   = cronBound{0, 23}
+	domBound    = cronBound{1, 31}
+	monthBound  = cronBound{1, 12}
+	dowBound    = cronBound{0, 7}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), spec)
+	}
+
+	s := new(CronSchedule)
+	var err error
+	if s.minute, err = parseCronField(fields[0], minuteBound); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], hourBound); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], domBound); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], monthBound); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], dowBound); err != nil {
+		return nil, err
+	}
+
+	// 7 和 0 都表示周日
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// */2 这种带步长的也算不限制 和 crontab 一致 只看第一个字符
+	s.domStar = isCronStar(fields[2])
+	s.dowStar = isCronStar(fields[4])
+
+	return s, nil
+}
+
+func isCronStar(field string) bool {
+	return strings.HasPrefix(field, "*") || strings.HasPrefix(field, "?")
+}
+
+func parseCronField(field string, bound cronBound) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		start, end := bound.min, bound.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("cron: invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("cron: invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("cron: invalid value %q", part)
+			}
+			start, end = n, n
+			// 5/10 表示从 5 开始每 10 个
+			if step > 1 {
+				end = bound.max
+			}
+		}
+
+		if start < bound.min || end > bound.max || start > end {
+			return 0, fmt.Errorf("cron: %q out of range [%d, %d]", part, bound.min, bound.max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next 返回严格晚于 t 的下一个触发时间 找不到返回零值
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches 日和周都被限制时满足其一即可 和 crontab 的语义保持一致
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
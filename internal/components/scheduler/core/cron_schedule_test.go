@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+
+		v, err := time.ParseInLocation("2006-01-02 15:04", value, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// 2024-01-01 是周一
+	cases := []struct {
+		spec string
+		from string
+		want []string
+	}{
+		{"*/15 * * * *", "2024-01-01 10:07", []string{"2024-01-01 10:15", "2024-01-01 10:30"}},
+		{"5/20 * * * *", "2024-01-01 10:30", []string{"2024-01-01 10:45", "2024-01-01 11:05"}},
+		{"0 9-17/4 * * *", "2024-01-01 10:00", []string{"2024-01-01 13:00", "2024-01-01 17:00", "2024-01-02 09:00"}},
+		{"0 8 * * 1-5", "2024-01-05 09:00", []string{"2024-01-08 08:00"}},
+		{"0,30 12 * * *", "2024-01-01 12:00", []string{"2024-01-01 12:30", "2024-01-02 12:00"}},
+		// 7 和 0 都是周日
+		{"30 2 * * 7", "2024-01-01 00:00", []string{"2024-01-07 02:30", "2024-01-14 02:30"}},
+		{"30 2 * * 0", "2024-01-01 00:00", []string{"2024-01-07 02:30"}},
+		// 日和周都限制时满足其一
+		{"0 0 13 * 5", "2024-01-01 00:00", []string{"2024-01-05 00:00", "2024-01-12 00:00", "2024-01-13 00:00"}},
+		// 带步长的日不算限制 日和周都要满足
+		{"0 0 */2 * 1", "2023-12-31 00:00", []string{"2024-01-01 00:00", "2024-01-15 00:00", "2024-01-29 00:00"}},
+		{"0 0 * * */3", "2024-01-01 00:00", []string{"2024-01-03 00:00", "2024-01-06 00:00", "2024-01-07 00:00"}},
+		// 跨月 跨年 没有 31 号的月份跳过
+		{"0 0 31 * *", "2024-01-31 00:00", []string{"2024-03-31 00:00", "2024-05-31 00:00"}},
+		{"0 0 29 2 *", "2024-03-01 00:00", []string{"2028-02-29 00:00"}},
+		{"59 23 31 12 *", "2024-12-31 23:59", []string{"2025-12-31 23:59"}},
+		{"@monthly", "2024-12-15 08:00", []string{"2025-01-01 00:00", "2025-02-01 00:00"}},
+		{"@hourly", "2024-01-01 10:00", []string{"2024-01-01 11:00"}},
+	}
+
+	for _, tc := range cases {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q) err: %v", tc.spec, err)
+			continue
+		}
+
+		next := at(tc.from)
+		for _, want := range tc.want {
+			next = s.Next(next)
+			if !next.Equal(at(want)) {
+				t.Errorf("%q: next = %s, want %s", tc.spec, next.Format("2006-01-02 15:04"), want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+}
@@ -7,26 +7,30 @@ import (
 	"strconv"
 	"stream_hub/internal/infra"
 	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
 	"stream_hub/pkg/model/storage"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 type DeadLetter struct {
-	rdb      *infra.Redis
+	broker   Broker
 	db       *infra.DB
-	queueKey string
 	enable   bool
+	workflow *WorkflowTracker
+	batch    *BatchTracker
+	progress *Progress
 }
 
-func NewDeadLetter(db *infra.DB, rdb *infra.Redis, conf *config.SchedulerConfig) *DeadLetter {
+func NewDeadLetter(db *infra.DB, broker Broker, conf *config.SchedulerConfig) *DeadLetter {
 	return &DeadLetter{
-		rdb:      rdb,
+		broker:   broker,
 		db:       db,
-		queueKey: conf.DeadLetter.QueueKey,
 		enable:   conf.DeadLetter.Enabled,
+		workflow: NewWorkflowTracker(db, broker),
+		batch:    NewBatchTracker(db, broker),
+		progress: NewProgress(db, broker),
 	}
 }
 
@@ -41,36 +45,63 @@ func (d *DeadLetter) Start() {
 
 func (d *DeadLetter) consume() {
 	for {
-		data, err := d.rdb.BRPop(context.Background(), time.Second*5, d.queueKey)
+		taskID, err := d.broker.ReceiveDeadLetter(context.Background(), time.Second*5)
 		if err != nil {
-			if !errors.Is(err, redis.Nil)  {
+			if !errors.Is(err, errors_.ErrNoTask) {
 				log.Println("deadletter: err:", err)
 			}
 			continue
 		}
-		taskID := data[1]
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		pipeline := d.rdb.Pipeline()
-		errMsgCmd := pipeline.HGet(ctx, "task:meta:"+taskID, "error_msg")
-		retryCountCmd := pipeline.HGet(ctx, "task:meta:"+taskID, "retry_count")
-		pipeline.Del(ctx, "task:meta:"+taskID, "task:payload:"+taskID)	
-		_, err = pipeline.Exec(ctx)
+		meta, err := d.broker.Meta(ctx, taskID)
 		if err != nil {
 			log.Println("err:", err)
+			cancel()
 			continue
 		}
 
-		errMsg, _ := errMsgCmd.Result()
-		retryCount, _ := retryCountCmd.Result()
-		count, _ := strconv.Atoi(retryCount)
+		task := new(infra_.TaskMessage)
+		if err := task.TransformByMap(meta); err != nil {
+			task.TaskID = taskID
+		}
+		errMsg := meta["error_msg"]
+		count, _ := strconv.Atoi(meta["retry_count"])
 
-		if err := d.db.Model(&storage.Task{}).Where("id = ?", taskID).Updates(map[string]interface{}{
+		// 先落库再删 meta 否则 relay 会把这段时间里还是待执行、meta 又没了的记录当成丢失重新投递
+		// 落库失败放回死信队列 下一轮再处理
+		if err := d.db.Model(&storage.Task{}).Where("id = ? and status = ?", taskID, constant.TaskPending).Updates(map[string]interface{}{
 			"status":      constant.TaskFailed,
 			"error_msg":   errMsg,
 			"retry_count": count,
 		}).Error; err != nil {
 			log.Println("err:", err)
+			if err := d.broker.DeadLetter(ctx, taskID); err != nil {
+				log.Println("err:", err)
+			}
+			cancel()
+			continue
+		}
+
+		if err := d.broker.Ack(ctx, task); err != nil {
+			log.Println("err:", err)
+			cancel()
+			continue
+		}
+		d.progress.finish(ctx, taskID, constant.TaskFailed, errMsg)
+		incCounter(tasksDeadLettered, 1, task.Type)
+
+		// 工作流中任意一个任务进入死信 整个工作流失败
+		if task.WorkflowID != "" {
+			if err := d.workflow.Fail(ctx, task.WorkflowID, taskID, errMsg); err != nil {
+				log.Println("workflow err:", err)
+			}
+		}
+
+		if task.BatchID != "" {
+			if err := d.batch.Check(ctx, task.BatchID); err != nil {
+				log.Println("batch err:", err)
+			}
 		}
 
 		cancel()
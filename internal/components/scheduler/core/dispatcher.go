@@ -3,30 +3,35 @@ package core
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
-	"strconv"
 	"stream_hub/internal/infra"
 	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/model/config"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 type Dispatcher struct {
-	rdb *infra.Redis
-	queue string 
+	broker Broker
 	batchSize int
 	lock *DistributedLock
 	ticker *time.Ticker
 	scanInterval time.Duration
+	tiers        []string
+	maxWait      map[string]time.Duration
+	agingBatch   int
 }
 
-func NewDispatcher(rdb *infra.Redis, conf *config.SchedulerConfig) *Dispatcher {
+func NewDispatcher(rdb *infra.Redis, broker Broker, conf *config.SchedulerConfig) *Dispatcher {
+	maxWait := make(map[string]time.Duration, len(conf.Aging.MaxWait))
+	for priority, wait := range conf.Aging.MaxWait {
+		maxWait[priority] = time.Duration(wait) * time.Millisecond
+	}
+
 	return &Dispatcher{
-		rdb: rdb,
-		queue: conf.Dispatcher.Queue,
+		tiers:      NewQueuePicker(conf.Queue).Tiers(),
+		maxWait:    maxWait,
+		agingBatch: conf.Aging.BatchSize,
+		broker: broker,
 		batchSize: conf.Dispatcher.BatchSize,
 		lock: NewDistributedLock(rdb, conf),
 		scanInterval: time.Duration(conf.Dispatcher.ScanInterval)*time.Millisecond,
@@ -47,57 +52,86 @@ func (d *Dispatcher) Start() {
 }
 
 func (d *Dispatcher) Scan(ctx context.Context) error {
+	d.sample(ctx)
+
 	resource := "scheduler:dispathcer"
-	id, err := d.lock.Lock(resource)
+	lease, err := d.lock.Lock(resource)
 	if err != nil {
+		// 别的节点正在扫描 这一轮跳过
 		if errors.Is(err, errors_.ErrKeyExists) {
-			if err := d.lock.Wait(resource); err != nil {
-				return err
-			}
-		} else {
-			return err
+			return nil
 		}
+		return err
 	}
 
-	defer d.lock.Unlock(resource, id)
+	defer lease.Unlock()
 
-	now := strconv.FormatInt(time.Now().Unix(), 10)
+	// 锁丢了 ctx 会被取消 后面的操作都会失败
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(lease.Context(), cancel)
+	defer stop()
 
-	taskIDs, err := d.rdb.ZRangeByScore(ctx, d.queue, &redis.ZRangeBy{
-		Min:   "-inf",
-		Max:   now,
-		Count: int64(d.batchSize),
-	}).Result()
-	if err != nil || len(taskIDs) == 0 {
+	// 移动到优先级队列之前 先确认锁还在
+	if err := lease.Check(); err != nil {
 		return err
 	}
 
-	pipe := d.rdb.Pipeline()
-	cmds := make(map[string]*redis.StringCmd, len(taskIDs))
-
-	for _, taskID := range taskIDs {
-		cmds[taskID] = pipe.HGet(ctx, "task:meta:"+taskID, "priority")
+	n, err := d.broker.PromoteDue(ctx, time.Now(), d.batchSize)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("dispatcher moved %d delayed tasks\n", n)
+		incCounter(tasksPromoted, uint64(n), "due")
 	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return err
+	return d.age(ctx)
+}
+
+// age 从高往低处理 刚提升上来的任务这一轮不会再被提升
+func (d *Dispatcher) age(ctx context.Context) error {
+	for i := 1; i < len(d.tiers); i++ {
+		from, to := d.tiers[i], d.tiers[i-1]
+		wait, ok := d.maxWait[from]
+		if !ok || wait <= 0 {
+			continue
+		}
+
+		n, err := d.broker.PromoteAged(ctx, from, to, time.Now().Add(-wait), d.agingBatch)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("dispatcher aged %d tasks from %s to %s\n", n, from, to)
+			incCounter(tasksPromoted, uint64(n), "aged")
+		}
 	}
 
-	n, err := d.rdb.ZRem(ctx, d.queue, taskIDs).Result()
+	return nil
+}
+
+// sample 刷新队列长度和等待时间指标 每个节点都会执行 没抢到锁的节点指标也是新的
+func (d *Dispatcher) sample(ctx context.Context) {
+	queues, delayed, err := d.broker.Depths(ctx, d.tiers)
 	if err != nil {
-		return err
+		log.Println("err:", err)
+		return
 	}
-	if n != int64(len(taskIDs)) {
-		return errors.New("miss zset remove")
+
+	for priority, n := range queues {
+		setGauge(queueDepth, float64(n), priority)
 	}
+	setGauge(delayedTasks, float64(delayed))
 
-	pipe = d.rdb.Pipeline()
-	for taskID, cmd := range cmds {
-		priority := cmd.Val()
-		queue := fmt.Sprintf("scheduler:queue:%s", priority)
-		pipe.LPush(ctx, queue, taskID)
+	waits, err := d.broker.WaitStats(ctx, d.tiers)
+	if err != nil {
+		log.Println("err:", err)
+		return
 	}
 
-	_, err = pipe.Exec(ctx)
-	return err
+	for priority, wait := range waits {
+		setGauge(queueWaitCount, float64(wait.Count), priority)
+		setGauge(queueWaitSeconds, float64(wait.SumMs)/1000, priority)
+	}
 }
@@ -2,81 +2,154 @@ package core
 
 import (
 	"context"
-	"fmt"
-	"github.com/go-redis/redis/v8"
+	"encoding/json"
 	"log"
 	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
 	"stream_hub/pkg/model/config"
 	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"sync"
 	"time"
 )
 
-type TaskHealth struct {
-	rdb               *infra.Redis
-	threshold         int
-	duration          time.Duration
-	blacklistDuration time.Duration
-	taskDelay         time.Duration
+// CircuitBreaker 按任务类型熔断 状态存在 broker 里 集群内共享
+// closed:    正常执行 duration 内失败 threshold 次转为 open
+// open:      任务延后 delay 再检查 openTimeout 之后转为 half_open
+// half_open: 同时最多放行 probes 个探测任务 成功 successThreshold 次恢复 closed 失败一次重新 open
+type CircuitBreaker struct {
+	broker           Broker
+	logger           *infra.Logger
+	nodeID           string
+	threshold        int
+	duration         time.Duration
+	openTimeout      time.Duration
+	taskDelay        time.Duration
+	probes           int
+	successThreshold int
+	probeDelay       time.Duration
+	// 本节点拿到探测名额还没有结果的任务 task_id -> 拿到名额的时间
+	probing sync.Map
 }
 
-func NewTaskHealth(rdb *infra.Redis, conf *config.SchedulerConfig) *TaskHealth {
-	return &TaskHealth{
-		rdb:               rdb,
-		threshold:         conf.Health.Threshold,
-		duration:          time.Duration(conf.Health.Duration) * time.Millisecond,
-		blacklistDuration: time.Duration(conf.Health.BlacklistDuration) * time.Millisecond,
-		taskDelay:         time.Duration(conf.Health.Delay) * time.Millisecond,
+func NewCircuitBreaker(broker Broker, logger *infra.Logger, nodeID string, conf *config.SchedulerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		broker:           broker,
+		logger:           logger,
+		nodeID:           nodeID,
+		threshold:        conf.Health.Threshold,
+		duration:         time.Duration(conf.Health.Duration) * time.Millisecond,
+		openTimeout:      time.Duration(conf.Health.BlacklistDuration) * time.Millisecond,
+		taskDelay:        time.Duration(conf.Health.Delay) * time.Millisecond,
+		probes:           conf.Health.Probes,
+		successThreshold: conf.Health.SuccessThreshold,
+		probeDelay:       time.Duration(conf.Health.ProbeDelay) * time.Millisecond,
 	}
 }
 
-func (t *TaskHealth) Check(task *infra_.TaskMessage) bool {
-	existed, err := t.rdb.IsExisted(context.Background(), fmt.Sprintf("scheduler:blacklist:%s", task.Type))
+// Allow 不放行的任务已经放回延时队列 调用方直接丢掉即可
+// broker 出错时放行 熔断器不可用不应该让任务停摆
+func (c *CircuitBreaker) Allow(task *infra_.TaskMessage) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	prev, state, allowed, err := c.broker.BreakerAllow(ctx, task.Type, c.openTimeout, c.probes)
 	if err != nil {
 		log.Println("err:", err)
-		return existed
+		return true
 	}
+	c.emit(task.Type, prev, state)
 
-	return existed
-}
+	if allowed {
+		if state == constant.BreakerHalfOpen {
+			c.probing.Store(task.TaskID, time.Now())
+		}
+		return true
+	}
 
-func (t *TaskHealth) HandleBlackList(task *infra_.TaskMessage) {
-	if err := t.rdb.ZAdd(context.Background(), "task:delay", &redis.Z{
-		Score:  float64(time.Now().Add(t.blacklistDuration).Unix()),
-		Member: task.TaskID,
-	}); err != nil {
+	delay := c.taskDelay
+	if state == constant.BreakerHalfOpen {
+		delay = c.probeDelay
+	}
+	if err := c.broker.Delay(ctx, task.TaskID, time.Now().Add(delay)); err != nil {
 		log.Println("err:", err)
 	}
+
+	return false
 }
 
-func (t *TaskHealth) HandleError(task *infra_.TaskMessage) error {
+func (c *CircuitBreaker) Success(task *infra_.TaskMessage) {
+	c.probing.Delete(task.TaskID)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	pipeline := t.rdb.Pipeline()
-	pipeline.SAdd(ctx, fmt.Sprintf("scheduler:failed:%s", task.Type), task.TaskID)
-	countCmd := pipeline.SCard(ctx, fmt.Sprintf("scheduler:failed:%s", task.Type))
+	prev, state, err := c.broker.BreakerSuccess(ctx, task.Type, c.successThreshold)
+	if err != nil {
+		log.Println("err:", err)
+		return
+	}
+	c.emit(task.Type, prev, state)
+}
+
+func (c *CircuitBreaker) Failure(task *infra_.TaskMessage) {
+	c.probing.Delete(task.TaskID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
 
-	_, err := pipeline.Exec(ctx)
+	prev, state, err := c.broker.BreakerFailure(ctx, task.Type, task.TaskID, c.duration, c.threshold)
 	if err != nil {
-		return err
+		log.Println("err:", err)
+		return
 	}
+	c.emit(task.Type, prev, state)
+}
 
-	count, _ := countCmd.Result()
+// Release 任务结束时调用 已经通过 Success/Failure 给出结果的不做处理
+// 否则(取消/没有 handler/退出/被 janitor 回收)归还探测名额 不然 half_open 会一直占满 直到探测超时
+func (c *CircuitBreaker) Release(task *infra_.TaskMessage) {
+	since, ok := c.probing.LoadAndDelete(task.TaskID)
+	if !ok {
+		return
+	}
 
-	if count == 1 {
-		if err := t.rdb.Expire(ctx, fmt.Sprintf("scheduler:failed:%s", task.Type), t.duration); err != nil {
-			log.Println("err:", err)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	if err := c.broker.BreakerRelease(ctx, task.Type, since.(time.Time)); err != nil {
+		log.Println("err:", err)
 	}
+}
 
-	if count >= int64(t.threshold) {
-		if err := t.rdb.Del(ctx, fmt.Sprintf("scheduler:failed:%s", task.Type)); err != nil {
-			log.Println("err:", err)
-		}
-		if err := t.rdb.Set(ctx, fmt.Sprintf("scheduler:blacklist:%s", task.Type), 1, t.blacklistDuration); err != nil {
-			log.Println("err:", err)
-		}
+// emit 状态变化写入系统日志
+func (c *CircuitBreaker) emit(taskType, prev, state string) {
+	if prev == state {
+		return
+	}
+
+	log.Printf("circuit breaker of %s: %s -> %s\n", taskType, prev, state)
+	if c.logger == nil {
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"task_type": taskType,
+		"from":      prev,
+		"to":        state,
+	})
+
+	level := "info"
+	if state == constant.BreakerOpen {
+		level = "warn"
 	}
 
-	return nil
+	c.logger.System(&storage.SystemLogEntry{
+		Level:   level,
+		Type:    "task",
+		NodeID:  c.nodeID,
+		Module:  constant.Scheduler,
+		Payload: string(payload),
+		Msg:     "circuit breaker " + state,
+	})
 }
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"stream_hub/pkg/constant"
+	"testing"
+	"time"
+)
+
+// expireOpen 把熔断器的状态时间往前拨 下一次 Allow 进入 half_open
+func expireOpen(broker *MemoryBroker, taskType string) {
+	broker.mu.Lock()
+	broker.breakers[taskType].changedAt = time.Now().Add(-2 * time.Hour)
+	broker.mu.Unlock()
+}
+
+func breakerState(broker *MemoryBroker, taskType string) string {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	breaker, ok := broker.breakers[taskType]
+	if !ok {
+		return constant.BreakerClosed
+	}
+	return breaker.state
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	broker := NewMemoryBroker()
+	c := NewCircuitBreaker(broker, nil, "node", testConfig())
+
+	t1 := enqueueTest(t, broker, "t1", "transcode")
+	t2 := enqueueTest(t, broker, "t2", "transcode")
+	t3 := enqueueTest(t, broker, "t3", "transcode")
+
+	// 同一个任务失败多次只算一次
+	c.Failure(t1)
+	c.Failure(t1)
+	if state := breakerState(broker, "transcode"); state != constant.BreakerClosed {
+		t.Fatalf("state = %s, want closed", state)
+	}
+	if !c.Allow(t3) {
+		t.Fatal("closed breaker should allow")
+	}
+
+	c.Failure(t2)
+	if state := breakerState(broker, "transcode"); state != constant.BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+
+	before := time.Now()
+	if c.Allow(t3) {
+		t.Fatal("open breaker should not allow")
+	}
+	if at, ok := broker.delay[t3.TaskID]; !ok || at.Before(before.Add(c.taskDelay).Truncate(time.Second)) {
+		t.Errorf("rejected task delayed to %v (%v)", at, ok)
+	}
+
+	// 其它类型不受影响
+	if !c.Allow(enqueueTest(t, broker, "t4", "email")) {
+		t.Error("other types should not be blocked")
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	broker := NewMemoryBroker()
+	c := NewCircuitBreaker(broker, nil, "node", testConfig())
+
+	c.Failure(enqueueTest(t, broker, "f1", "transcode"))
+	c.Failure(enqueueTest(t, broker, "f2", "transcode"))
+	expireOpen(broker, "transcode")
+
+	p1 := enqueueTest(t, broker, "p1", "transcode")
+	p2 := enqueueTest(t, broker, "p2", "transcode")
+	if !c.Allow(p1) {
+		t.Fatal("half_open should allow a probe")
+	}
+	if state := breakerState(broker, "transcode"); state != constant.BreakerHalfOpen {
+		t.Fatalf("state = %s, want half_open", state)
+	}
+
+	// 名额只有一个
+	if c.Allow(p2) {
+		t.Fatal("probe slots are full")
+	}
+	if _, ok := broker.delay[p2.TaskID]; !ok {
+		t.Error("rejected probe should be delayed")
+	}
+
+	// 探测任务没有结果就结束了 名额要还回来
+	c.Release(p1)
+	if !c.Allow(p2) {
+		t.Fatal("released probe slot should be reusable")
+	}
+	// 已经给出结果的任务 Release 不再归还
+	c.Success(p2)
+	c.Release(p2)
+	if broker.breakers["transcode"].probes != 0 {
+		t.Fatalf("probes = %d, want 0", broker.breakers["transcode"].probes)
+	}
+
+	p3 := enqueueTest(t, broker, "p3", "transcode")
+	if !c.Allow(p3) {
+		t.Fatal("half_open should allow the next probe")
+	}
+	c.Success(p3)
+	if state := breakerState(broker, "transcode"); state != constant.BreakerClosed {
+		t.Fatalf("state = %s, want closed after %d successes", state, c.successThreshold)
+	}
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	broker := NewMemoryBroker()
+	c := NewCircuitBreaker(broker, nil, "node", testConfig())
+
+	c.Failure(enqueueTest(t, broker, "f1", "transcode"))
+	c.Failure(enqueueTest(t, broker, "f2", "transcode"))
+	expireOpen(broker, "transcode")
+
+	p1 := enqueueTest(t, broker, "p1", "transcode")
+	p2 := enqueueTest(t, broker, "p2", "transcode")
+	if !c.Allow(p1) {
+		t.Fatal("half_open should allow a probe")
+	}
+
+	// 别的节点(janitor)判定 p1 超时 重新 open
+	other := NewCircuitBreaker(broker, nil, "janitor", testConfig())
+	other.Failure(p1)
+	if state := breakerState(broker, "transcode"); state != constant.BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+
+	// 重新进入 half_open 之后 上一轮的名额不能归还到这一轮
+	expireOpen(broker, "transcode")
+	if !c.Allow(p2) {
+		t.Fatal("half_open should allow a probe")
+	}
+	c.Release(p1)
+	if probes := broker.breakers["transcode"].probes; probes != 1 {
+		t.Errorf("probes = %d, want 1", probes)
+	}
+
+	if _, _, err := broker.BreakerFailure(context.Background(), "transcode", p2.TaskID, time.Minute, 2); err != nil {
+		t.Fatal(err)
+	}
+	if state := breakerState(broker, "transcode"); state != constant.BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+}
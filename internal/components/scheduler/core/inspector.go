@@ -0,0 +1,414 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/api"
+	"stream_hub/pkg/model/config"
+	"stream_hub/pkg/model/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// Inspector 运维查看和管理调度器里的任务 供 admin 接口使用
+// 直接读 redis 的 key 只支持 redis broker 内存模式下 admin 接口和 ctl 都不会创建它
+type Inspector struct {
+	rdb         *infra.Redis
+	db          *infra.DB
+	broker      Broker
+	queues      []string
+	delayKey    string
+	dlqKey      string
+	runningKey  string
+	registerKey string
+}
+
+func NewInspector(db *infra.DB, rdb *infra.Redis, conf *config.SchedulerConfig) *Inspector {
+	queues := make([]string, 0, len(conf.Queue))
+	for priority := range conf.Queue {
+		queues = append(queues, priority)
+	}
+
+	return &Inspector{
+		rdb:         rdb,
+		db:          db,
+		broker:      NewRedisBroker(rdb, conf),
+		queues:      queues,
+		delayKey:    conf.Dispatcher.Queue,
+		dlqKey:      conf.DeadLetter.QueueKey,
+		runningKey:  conf.Timeout.RunningKey,
+		registerKey: conf.RegisterKey,
+	}
+}
+
+func (i *Inspector) QueueStats(ctx context.Context) (*api.QueueStatsResp, error) {
+	pipeline := i.rdb.Pipeline()
+	queueCmds := make(map[string]*redis.IntCmd, len(i.queues))
+	waitCmds := make(map[string]*redis.StringStringMapCmd, len(i.queues))
+	oldestCmds := make(map[string]*redis.StringCmd, len(i.queues))
+	for _, priority := range i.queues {
+		queueCmds[priority] = pipeline.LLen(ctx, fmt.Sprintf("scheduler:queue:%s", priority))
+		waitCmds[priority] = pipeline.HGetAll(ctx, waitKey(priority))
+		oldestCmds[priority] = pipeline.LIndex(ctx, fmt.Sprintf("scheduler:queue:%s", priority), -1)
+	}
+	delayCmd := pipeline.ZCard(ctx, i.delayKey)
+	runningCmd := pipeline.ZCard(ctx, i.runningKey)
+	dlqCmd := pipeline.LLen(ctx, i.dlqKey)
+
+	// 空队列的 LIndex 返回 redis.Nil
+	if _, err := pipeline.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	resp := &api.QueueStatsResp{
+		Queues:     make(map[string]int64, len(queueCmds)),
+		Delay:      delayCmd.Val(),
+		Running:    runningCmd.Val(),
+		DeadLetter: dlqCmd.Val(),
+		Active:     make(map[string]int64),
+		Wait:       make(map[string]api.QueueWait, len(waitCmds)),
+	}
+	for priority, cmd := range queueCmds {
+		resp.Queues[priority] = cmd.Val()
+	}
+
+	now := time.Now().UnixMilli()
+	for priority, cmd := range waitCmds {
+		wait := api.QueueWait{}
+		wait.Count, _ = strconv.ParseInt(cmd.Val()["count"], 10, 64)
+		sum, _ := strconv.ParseInt(cmd.Val()["sum_ms"], 10, 64)
+		if wait.Count > 0 {
+			wait.AvgMs = sum / wait.Count
+		}
+
+		if taskID := oldestCmds[priority].Val(); taskID != "" {
+			at, _ := strconv.ParseInt(i.rdb.HGet(ctx, "task:meta:"+taskID, "enqueued_at").Val(), 10, 64)
+			if at > 0 {
+				wait.OldestMs = now - at
+			}
+		}
+		resp.Wait[priority] = wait
+	}
+
+	iter := i.rdb.Scan(ctx, 0, "scheduler:active:worker_*", 500).Iterator()
+	for iter.Next(ctx) {
+		n, err := i.rdb.LLen(ctx, iter.Val())
+		if err != nil {
+			return nil, err
+		}
+		resp.Active[strings.TrimPrefix(iter.Val(), "scheduler:active:worker_")] = n
+	}
+
+	return resp, iter.Err()
+}
+
+func (i *Inspector) GetTask(ctx context.Context, taskID string) (*api.TaskDetailResp, error) {
+	meta, err := i.rdb.HGetAll(ctx, "task:meta:"+taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := i.rdb.Get(ctx, "task:payload:"+taskID)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	resp := &api.TaskDetailResp{
+		Meta:    meta,
+		Payload: string(payload),
+	}
+
+	var task storage.Task
+	if err := i.db.Where("id = ?", taskID).First(&task).Error; err == nil {
+		resp.Record = &task
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if len(meta) == 0 && resp.Record == nil {
+		return nil, errors_.TaskNotFound
+	}
+
+	return resp, nil
+}
+
+// ListDeadLetters 死信队列的消费者会把任务落库为失败 所以这里同时返回还没落库的和已经落库的
+func (i *Inspector) ListDeadLetters(ctx context.Context, page, size int) (*api.ListDeadLetterResp, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+
+	start := int64((page - 1) * size)
+	pending, err := i.rdb.LRange(ctx, i.dlqKey, start, start+int64(size)-1)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingTotal, err := i.rdb.LLen(ctx, i.dlqKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.ListDeadLetterResp{Pending: pending, PendingTotal: pendingTotal}
+	db := i.db.Model(&storage.Task{}).Where("status = ?", constant.TaskFailed)
+	if err := db.Count(&resp.Total).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Order("updated_at desc").
+		Limit(size).
+		Offset((page - 1) * size).
+		Find(&resp.Tasks).Error; err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// RequeueDeadLetter 用 mysql 里的记录重建消息 通过 broker 重新放回优先级队列
+// 去重 key 和超时都要带上 否则重投的任务成功后释放不了去重锁
+// 工作流在成员进入死信时已经结束 单独重投的成员成功后也推进不了 不允许重投
+func (i *Inspector) RequeueDeadLetter(ctx context.Context, taskID string) error {
+	var task storage.Task
+	if err := i.db.Where("id = ?", taskID).First(&task).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors_.TaskNotFound
+		}
+		return err
+	}
+	if task.WorkflowID != "" {
+		return errors_.TaskInWorkflow
+	}
+
+	// 还在 scheduler:dlq 里的任务没有落库 状态仍然是待执行
+	removed, err := i.rdb.LRem(ctx, i.dlqKey, 0, taskID)
+	if err != nil {
+		return err
+	}
+
+	// 已经落库的按状态条件重置 并发重投时只有一个能更新成功
+	db := i.db.Model(&storage.Task{}).Where("id = ?", taskID)
+	if removed == 0 {
+		db = db.Where("status = ?", constant.TaskFailed)
+	}
+	res := db.Updates(map[string]interface{}{
+		"status":       constant.TaskPending,
+		"error_msg":    "",
+		"retry_count":  0,
+		"progress":     0,
+		"progress_msg": "",
+		"result":       "",
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if removed == 0 && res.RowsAffected == 0 {
+		return errors_.TaskNotFound
+	}
+
+	task.RetryCount = 0
+	message, err := messageFromRecord(&task)
+	if err != nil {
+		return err
+	}
+
+	// 还没落库的死信 meta 里有上一次执行留下的 node_id/worker_id 先清掉
+	if err := i.rdb.Del(ctx, "task:meta:"+taskID); err != nil {
+		return err
+	}
+
+	return i.broker.Enqueue(ctx, message)
+}
+
+// 批量重投一次最多处理的死信数
+const requeueLimit = 1000
+
+// FindDeadLetters 每次从 scheduler:dlq 读取的条数
+const dlqPageSize = 200
+
+// FindDeadLetters 按条件筛选死信 先找还在 scheduler:dlq 里没落库的 再找已经落库为失败的
+func (i *Inspector) FindDeadLetters(ctx context.Context, filter api.DeadLetterFilter) ([]string, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > requeueLimit {
+		limit = requeueLimit
+	}
+
+	ids := make([]string, 0)
+	seen := make(map[string]struct{})
+	// 死信队列可能很长 分页读 凑够 limit 就停
+	for start := int64(0); len(ids) < limit; start += dlqPageSize {
+		pending, err := i.rdb.LRange(ctx, i.dlqKey, start, start+dlqPageSize-1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, taskID := range pending {
+			if len(ids) >= limit {
+				return ids, nil
+			}
+			if _, ok := seen[taskID]; ok {
+				continue
+			}
+
+			meta, err := i.rdb.HGetAll(ctx, "task:meta:"+taskID)
+			if err != nil {
+				return nil, err
+			}
+			if meta["workflow_id"] != "" {
+				continue
+			}
+			if filter.Type != "" && meta["type"] != filter.Type {
+				continue
+			}
+			if filter.ErrorMsg != "" && !strings.Contains(meta["error_msg"], filter.ErrorMsg) {
+				continue
+			}
+
+			seen[taskID] = struct{}{}
+			ids = append(ids, taskID)
+		}
+
+		if len(pending) < dlqPageSize {
+			break
+		}
+	}
+	if len(ids) >= limit {
+		return ids, nil
+	}
+
+	var failed []string
+	db := i.db.Model(&storage.Task{}).Where("status = ? and workflow_id = '' and type not in ?", constant.TaskFailed, []string{constant.TaskWorkflow, constant.TaskBatch})
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.ErrorMsg != "" {
+		db = db.Where("error_msg like ?", "%"+filter.ErrorMsg+"%")
+	}
+	if err := db.Order("updated_at desc").Limit(limit-len(ids)).Pluck("id", &failed).Error; err != nil {
+		return nil, err
+	}
+
+	for _, taskID := range failed {
+		if _, ok := seen[taskID]; !ok {
+			ids = append(ids, taskID)
+		}
+	}
+
+	return ids, nil
+}
+
+// RequeueDeadLetters 批量重投 中途出错时返回已经重投的任务和错误
+func (i *Inspector) RequeueDeadLetters(ctx context.Context, filter api.DeadLetterFilter) ([]string, error) {
+	ids, err := i.FindDeadLetters(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	requeued := make([]string, 0, len(ids))
+	for _, taskID := range ids {
+		if err := i.RequeueDeadLetter(ctx, taskID); err != nil {
+			// 筛选之后被别人重投或删掉了
+			if errors.Is(err, errors_.TaskNotFound) || errors.Is(err, errors_.TaskInWorkflow) {
+				continue
+			}
+			return requeued, err
+		}
+		requeued = append(requeued, taskID)
+	}
+
+	return requeued, nil
+}
+
+func (i *Inspector) DeleteDeadLetter(ctx context.Context, taskID string) error {
+	if _, err := i.rdb.LRem(ctx, i.dlqKey, 0, taskID); err != nil {
+		return err
+	}
+
+	if err := i.rdb.Del(ctx, "task:meta:"+taskID, "task:payload:"+taskID); err != nil {
+		return err
+	}
+
+	return i.db.Where("id = ? and status = ?", taskID, constant.TaskFailed).Delete(&storage.Task{}).Error
+}
+
+// ListBlacklist 没有恢复 closed 的熔断器 类型 -> 状态
+func (i *Inspector) ListBlacklist(ctx context.Context) (map[string]string, error) {
+	breakers := make(map[string]string)
+	iter := i.rdb.Scan(ctx, 0, "scheduler:breaker:*", 500).Iterator()
+	for iter.Next(ctx) {
+		state, err := i.rdb.HGet(ctx, iter.Val(), "state").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		breakers[strings.TrimPrefix(iter.Val(), "scheduler:breaker:")] = state
+	}
+
+	return breakers, iter.Err()
+}
+
+// ClearBlacklist 手动恢复 closed
+func (i *Inspector) ClearBlacklist(ctx context.Context, taskType string) error {
+	return i.rdb.Del(ctx, breakerKey(taskType), failedKey(taskType))
+}
+
+// ListNodes 注册表里的节点 有心跳的是存活节点
+func (i *Inspector) ListNodes(ctx context.Context) ([]api.NodeInfo, error) {
+	nodes := make([]api.NodeInfo, 0)
+	iter := i.rdb.Scan(ctx, 0, i.registerKey+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		nodeID := strings.TrimPrefix(iter.Val(), i.registerKey)
+		alive, err := i.rdb.IsExisted(ctx, "scheduler:heartbeat:"+nodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		workers, err := i.rdb.HGetAll(ctx, iter.Val())
+		if err != nil {
+			return nil, err
+		}
+
+		node := api.NodeInfo{
+			NodeID:  nodeID,
+			Alive:   alive,
+			Workers: make(map[string]int64, len(workers)),
+		}
+		for workerID := range workers {
+			n, err := i.rdb.LLen(ctx, fmt.Sprintf("scheduler:active:worker_%s", workerID))
+			if err != nil {
+				return nil, err
+			}
+			node.Workers[workerID] = n
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, iter.Err()
+}
+
+func (i *Inspector) GetWorkflow(ctx context.Context, workflowID string) (*api.WorkflowDetailResp, error) {
+	resp := new(api.WorkflowDetailResp)
+	if err := i.db.Where("id = ? and type = ?", workflowID, constant.TaskWorkflow).First(&resp.Workflow).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors_.TaskNotFound
+		}
+		return nil, err
+	}
+
+	if err := i.db.Where("workflow_id = ?", workflowID).Order("created_at").Find(&resp.Tasks).Error; err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -3,39 +3,49 @@ package core
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/go-redis/redis/v8"
 	"log"
 	"stream_hub/internal/infra"
 	"stream_hub/pkg/model/config"
 	errors_ "stream_hub/pkg/errors"
+	infra_ "stream_hub/pkg/model/infra"
 	"strings"
 	"time"
 )
 
+// Janitor 回收死掉的节点和超时的任务
+// rdb 为空(内存模式)时只有一个节点 不需要检查心跳
 type Janitor struct {
 	rdb               *infra.Redis
+	broker            Broker
 	heartbeatInterval time.Duration
 	ticker            *time.Ticker
 	registerKey       string
 	deathKey          string
 	lock *DistributedLock
+	retry             *Retry
+	breaker           *CircuitBreaker
 }
 
-func NewJanitor(rdb *infra.Redis, conf *config.SchedulerConfig) *Janitor {
+func NewJanitor(rdb *infra.Redis, broker Broker, logger *infra.Logger, conf *config.SchedulerConfig) *Janitor {
 	return &Janitor{
 		rdb:               rdb,
+		broker:            broker,
 		heartbeatInterval: time.Duration(conf.HeartbeatInterval) * time.Millisecond,
 		registerKey:       conf.RegisterKey,
 		deathKey:          conf.DeathKey,
 		lock: NewDistributedLock(rdb, conf),
+		retry:             NewRetry(broker, conf),
+		breaker:           NewCircuitBreaker(broker, logger, "janitor", conf),
 	}
 }
 
 func (j *Janitor) Run() {
 	log.Printf("janitor is running\n")
 	j.ticker = time.NewTicker(j.heartbeatInterval)
-	go j.ListenDeath()
+	if j.rdb != nil {
+		go j.ListenDeath()
+	}
 	for {
 		select {
 		case <-j.ticker.C:
@@ -51,29 +61,41 @@ func (j *Janitor) Run() {
 
 func (j *Janitor) Scan() error {
 	resource := "scheduler:janitor"
-	id, err := j.lock.Lock(resource)
+	lease, err := j.lock.Lock(resource)
 	if err != nil {
+		// 别的节点正在扫描 这一轮跳过
 		if errors.Is(err, errors_.ErrKeyExists) {
-			if err := j.lock.Wait(resource); err != nil {
-				return err
-			}
-		} else {
+			return nil
+		}
+		return err
+	}
+
+	defer lease.Unlock()
+
+	// 锁丢了 ctx 会被取消 后面的操作都会失败
+	ctx := lease.Context()
+
+	if j.rdb != nil {
+		if err := j.scanNodes(ctx); err != nil {
 			return err
 		}
 	}
 
-	defer j.lock.Unlock(resource, id)
+	return j.reclaim(ctx, lease.Fence())
+}
 
+// scanNodes 注册了但是没有心跳的节点 它的 worker 手里的任务放回队列
+func (j *Janitor) scanNodes(ctx context.Context) error {
 	aliveNodes := make(map[string]struct{})
 	// 心跳名单
-	iter := j.rdb.Scan(context.Background(), 0, "scheduler:heartbeat:*", 500).Iterator()
-	for iter.Next(context.Background()) {
+	iter := j.rdb.Scan(ctx, 0, "scheduler:heartbeat:*", 500).Iterator()
+	for iter.Next(ctx) {
 		parts := strings.Split(iter.Val(), ":")
 		aliveNodes[parts[len(parts)-1]] = struct{}{}
 	}
 
-	regIter := j.rdb.Scan(context.Background(), 0, j.registerKey+"*", 500).Iterator()
-	for regIter.Next(context.Background()) {
+	regIter := j.rdb.Scan(ctx, 0, j.registerKey+"*", 500).Iterator()
+	for regIter.Next(ctx) {
 		fullKey := regIter.Val()
 		parts := strings.Split(fullKey, ":")
 		nodeID := parts[len(parts)-1]
@@ -81,9 +103,9 @@ func (j *Janitor) Scan() error {
 		// 不在存活 Map 里，说明 Node 挂了
 		if _, ok := aliveNodes[nodeID]; !ok {
 			log.Printf("发现失联节点: %s", nodeID)
-			workerMap, _ := j.rdb.HGetAll(context.Background(), fullKey)
+			workerMap, _ := j.rdb.HGetAll(ctx, fullKey)
 			for workerID := range workerMap {
-				if err := j.rdb.Del(context.Background(), j.registerKey+nodeID); err != nil {
+				if err := j.rdb.Del(ctx, j.registerKey+nodeID); err != nil {
 					return err
 				}
 				if err := j.cleanup(workerID); err != nil {
@@ -93,6 +115,57 @@ func (j *Janitor) Scan() error {
 		}
 	}
 
+	return regIter.Err()
+}
+
+// reclaim 扫描执行中已经超过截止时间的任务 通知所属 node 取消 context 然后走重试
+// 移出执行中集合时比较 fencing token 旧的持有者不会和新的持有者重复回收
+func (j *Janitor) reclaim(ctx context.Context, fence Fence) error {
+	taskIDs, err := j.broker.Expired(ctx, time.Now(), 500)
+	if err != nil {
+		return err
+	}
+
+	for _, taskID := range taskIDs {
+		// 删除成功才算抢到了这个任务 删不掉说明 worker 刚好执行完了
+		owned, err := j.broker.Release(ctx, taskID, fence)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			continue
+		}
+
+		meta, err := j.broker.Meta(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		// 任务数据已经没了(被取消或者已经收尾) Release 已经把它移出了执行中集合 不能再走重试
+		// 否则 Nack 会写出一个没有类型的 meta 最后进入死信
+		if len(meta) == 0 {
+			log.Printf("task %s timeout but its meta is gone, drop it\n", taskID)
+			continue
+		}
+
+		log.Printf("task %s timeout, node: %s, worker: %s\n", taskID, meta["node_id"], meta["worker_id"])
+		incCounter(tasksReclaimed, 1, meta["type"])
+
+		if meta["node_id"] != "" {
+			if err := j.broker.SendCancel(ctx, meta["node_id"], taskID); err != nil {
+				log.Println("err:", err)
+			}
+		}
+
+		task := new(infra_.TaskMessage)
+		if err := task.TransformByMap(meta); err != nil {
+			task.TaskID = taskID
+		}
+		// 超时和执行失败一样计入熔断 half_open 的探测任务超时会重新 open 探测名额随之清零
+		j.breaker.Failure(task)
+		j.retry.retry(task, errors_.TaskTimeout)
+	}
+
 	return nil
 }
 
@@ -126,17 +199,10 @@ func (j *Janitor) ListenDeath() {
 }
 
 func (j *Janitor) cleanup(workerID string) error {
-	queue := fmt.Sprintf("scheduler:active:worker_%s", workerID)
-	for {
-		taskID, err := j.rdb.RPop(context.Background(), queue)
-		if err != nil {
-			return nil
-		}
-
-		priority := j.rdb.HGet(context.Background(), "task:meta:"+taskID, "priority").Val()
-		queue = fmt.Sprintf("scheduler:queue:%s", priority)
-		if err := j.rdb.LPush(context.Background(), queue, taskID); err != nil {
-			return err
-		}
+	if err := j.broker.Requeue(context.Background(), workerID); err != nil {
+		return err
 	}
+	incCounter(workersCleaned, 1)
+
+	return nil
 }
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJanitorReclaim(t *testing.T) {
+	broker := NewMemoryBroker()
+	j := NewJanitor(nil, broker, nil, testConfig())
+	ctx := context.Background()
+
+	expired := enqueueTest(t, broker, "expired", "transcode")
+	running := enqueueTest(t, broker, "running", "transcode")
+	if err := broker.MarkRunning(ctx, expired.TaskID, "node1", "worker1", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.MarkRunning(ctx, running.TaskID, "node1", "worker1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// 已经被取消的任务 meta 没了但还留在执行中集合里
+	broker.mu.Lock()
+	broker.running["cancelled"] = time.Now().Add(-time.Second)
+	broker.mu.Unlock()
+
+	if err := j.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := broker.running[expired.TaskID]; ok {
+		t.Error("expired task should leave the running set")
+	}
+	if _, ok := broker.running[running.TaskID]; !ok {
+		t.Error("task within its deadline should keep running")
+	}
+
+	// 通知所在节点取消 然后按超时走重试
+	if items := broker.list("scheduler:control:node1").items; len(items) != 1 || items[0] != expired.TaskID {
+		t.Errorf("control = %v", items)
+	}
+	if _, ok := broker.delay[expired.TaskID]; !ok {
+		t.Error("expired task should be delayed for retry")
+	}
+	meta, _ := broker.Meta(ctx, expired.TaskID)
+	if meta["retry_count"] != "1" || meta["error_msg"] != "task execution timeout" {
+		t.Errorf("meta = %v", meta)
+	}
+
+	// 超时计入熔断
+	if failures := broker.failures["transcode"]; failures == nil || len(failures.ids) != 1 {
+		t.Errorf("failures = %v", failures)
+	}
+
+	// meta 没了的任务直接丢掉 不能重试出一个没有类型的 meta
+	if _, ok := broker.running["cancelled"]; ok {
+		t.Error("cancelled task should leave the running set")
+	}
+	if _, ok := broker.metas["cancelled"]; ok {
+		t.Error("cancelled task should not get its meta back")
+	}
+	if _, ok := broker.delay["cancelled"]; ok {
+		t.Error("cancelled task should not be retried")
+	}
+}
+
+func TestMarkRunningAfterCancel(t *testing.T) {
+	broker := NewMemoryBroker()
+	task := enqueueTest(t, broker, "t1", "transcode")
+
+	if err := broker.Ack(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.MarkRunning(context.Background(), task.TaskID, "node1", "worker1", time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("expected TaskNotFound")
+	}
+	if _, ok := broker.running[task.TaskID]; ok {
+		t.Error("cancelled task should not be running")
+	}
+}
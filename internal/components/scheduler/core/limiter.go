@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"log"
+	"math"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"sync"
+	"time"
+)
+
+// Limiter 按任务类型限流 一个节点的所有 worker 共用
+// node 单节点同时执行数 本地计数
+// cluster 集群同时执行数 broker 里的名额
+// rate 每秒执行数 broker 里的令牌桶
+// 拿不到的任务延时 delay 后重新排队 不占用 worker 的并发名额 其他类型照常执行
+type Limiter struct {
+	mu      sync.Mutex
+	broker  Broker
+	limits  map[string]config.TypeLimit
+	running map[string]int
+	delay   time.Duration
+}
+
+func NewLimiter(broker Broker, conf *config.SchedulerConfig) *Limiter {
+	limits := make(map[string]config.TypeLimit, len(conf.Limit.Types))
+	for taskType, limit := range conf.Limit.Types {
+		if limit.Rate > 0 && limit.Burst <= 0 {
+			limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+		}
+		limits[taskType] = limit
+	}
+
+	return &Limiter{
+		broker:  broker,
+		limits:  limits,
+		running: make(map[string]int),
+		delay:   time.Duration(conf.Limit.Delay) * time.Millisecond,
+	}
+}
+
+// Acquire 拿到名额返回 true 执行结束后必须调用 Release
+// 拿不到时任务已经放回延时队列 调用方直接丢掉即可
+// broker 出错时放行 限流不可用不应该让任务停摆
+func (l *Limiter) Acquire(task *infra_.TaskMessage, deadline time.Time) bool {
+	limit, ok := l.limits[task.Type]
+	if !ok {
+		return true
+	}
+
+	if !l.acquireNode(task.Type, limit.Node) {
+		l.reject(task, "node")
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	if limit.Cluster > 0 {
+		allowed, err := l.broker.AcquireSlot(ctx, task.Type, task.TaskID, limit.Cluster, deadline)
+		if err != nil {
+			log.Println("err:", err)
+		} else if !allowed {
+			l.releaseNode(task.Type, limit.Node)
+			l.reject(task, "cluster")
+			return false
+		}
+	}
+
+	// 令牌放最后 前面没拿到名额的任务不消耗令牌
+	if limit.Rate > 0 {
+		allowed, err := l.broker.TakeToken(ctx, task.Type, limit.Rate, limit.Burst)
+		if err != nil {
+			log.Println("err:", err)
+		} else if !allowed {
+			l.Release(task)
+			l.reject(task, "rate")
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *Limiter) Release(task *infra_.TaskMessage) {
+	limit, ok := l.limits[task.Type]
+	if !ok {
+		return
+	}
+
+	l.releaseNode(task.Type, limit.Node)
+
+	if limit.Cluster > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+
+		if err := l.broker.ReleaseSlot(ctx, task.Type, task.TaskID); err != nil {
+			log.Println("err:", err)
+		}
+	}
+}
+
+func (l *Limiter) acquireNode(taskType string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.running[taskType] >= limit {
+		return false
+	}
+	l.running[taskType]++
+
+	return true
+}
+
+func (l *Limiter) releaseNode(taskType string, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	if l.running[taskType] > 0 {
+		l.running[taskType]--
+	}
+	l.mu.Unlock()
+}
+
+func (l *Limiter) reject(task *infra_.TaskMessage, reason string) {
+	log.Printf("task %s of %s is limited by %s, delay %s\n", task.TaskID, task.Type, reason, l.delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	if err := l.broker.Delay(ctx, task.TaskID, time.Now().Add(l.delay)); err != nil {
+		log.Println("err:", err)
+	}
+}
@@ -3,83 +3,203 @@ package core
 import (
 	"context"
 	"fmt"
+	"log"
 	"stream_hub/internal/infra"
-	"stream_hub/pkg/errors"
 	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/model/config"
 	"stream_hub/pkg/utils"
+	"sync"
 	"time"
 )
 
+// 加锁成功的同时自增 fencing token，token 单调递增，fence key 里始终是最新持有者的 token
+const acquireScript = `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`
+
+// 只有值等于自己的 id 才删除，避免删掉别人的锁
+const releaseScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// 持锁期间的关键写操作用 lua 执行 脚本开头先比较 fencing token 别的节点拿到过锁就不写
+// 拼在脚本前面 KEYS[1] 固定是 fence key ARGV[1] 固定是 token
+const fenceGuard = `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+`
+
+const renewScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`
+
 type DistributedLock struct {
-	rdb     *infra.Redis
-	timeout time.Duration
-	detectInterval time.Duration
-	deadline time.Duration
+	rdb           *infra.Redis
+	timeout       time.Duration
+	renewInterval time.Duration
 }
 
+// NewDistributedLock rdb 为空(内存模式)时返回 nil 只有一个节点 Lock 直接返回本地租约
 func NewDistributedLock(rdb *infra.Redis, conf *config.SchedulerConfig) *DistributedLock {
+	if rdb == nil {
+		return nil
+	}
+
 	return &DistributedLock{
-		rdb: rdb,
-		timeout: time.Duration(conf.Lock.LockTimeout) * time.Millisecond,
-		deadline: time.Duration(conf.Lock.WaitDeadline) * time.Millisecond,
-		detectInterval: time.Duration(conf.Lock.DetectInterval) * time.Millisecond,
+		rdb:           rdb,
+		timeout:       time.Duration(conf.Lock.LockTimeout) * time.Millisecond,
+		renewInterval: time.Duration(conf.Lock.RenewInterval) * time.Millisecond,
 	}
 }
 
-func (l *DistributedLock) Lock(resource string) (string, error) {
-	lock := fmt.Sprintf("lock:%s", resource)
+// Lock 非阻塞加锁 锁被占用时返回 ErrKeyExists
+// 加锁成功后会启动看门狗自动续期 直到 Unlock 或者续期失败
+func (l *DistributedLock) Lock(resource string) (*Lease, error) {
+	key := fmt.Sprintf("lock:%s", resource)
+	if l == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &Lease{key: key, ctx: ctx, cancel: cancel, stop: make(chan struct{})}, nil
+	}
+
 	id := utils.CreateUUID()
-	success, err := l.rdb.SetNX(context.Background(), lock, id, l.timeout)
+
+	token, err := l.rdb.Eval(context.Background(), acquireScript, []string{key, key + ":fence"}, id, l.timeout.Milliseconds()).Int64()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if token == 0 {
+		return nil, errors_.ErrKeyExists
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lease := &Lease{
+		lock:   l,
+		key:    key,
+		id:     id,
+		token:  token,
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+	}
+
+	go lease.watchDog()
+
+	return lease, nil
+}
+
+// Lease 一次加锁的凭证
+type Lease struct {
+	lock   *DistributedLock
+	key    string
+	id     string
+	token  int64
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Token fencing token
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Fence 持锁期间的写操作带上它 存储端比较 token 后才写 内存模式只有一个节点 返回零值不做比较
+func (l *Lease) Fence() Fence {
+	if l.lock == nil {
+		return Fence{}
+	}
+
+	return Fence{Key: l.key + ":fence", Token: l.token}
+}
+
+// Fence 写操作的 fencing token 和它所在的 key
+type Fence struct {
+	Key   string
+	Token int64
+}
+
+// Context 锁丢失(续期失败或被 Unlock)时会被取消 持锁期间的操作都应该用这个 ctx
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Check 确认锁还在自己手里 用于关键写操作之前
+func (l *Lease) Check() error {
+	if l.ctx.Err() != nil {
+		return errors_.ErrLockLost
+	}
+	if l.lock == nil {
+		return nil
 	}
 
-	if !success {
-		return "", errors_.ErrKeyExists
+	data, err := l.lock.rdb.Get(context.Background(), l.key)
+	if err != nil || string(data) != l.id {
+		l.cancel()
+		return errors_.ErrLockLost
 	}
 
-	return id, nil
+	return nil
 }
 
-func (l *DistributedLock) Unlock(lock, key string) error {
-	lock = fmt.Sprintf("lock:%s", lock)
-	data, err := l.rdb.Get(context.Background(), lock)
+func (l *Lease) Unlock() error {
+	l.once.Do(func() { close(l.stop) })
+	defer l.cancel()
+	if l.lock == nil {
+		return nil
+	}
+
+	n, err := l.lock.rdb.Eval(context.Background(), releaseScript, []string{l.key}, l.id).Int64()
 	if err != nil {
 		return err
 	}
 
-	if string(data) != key {
-		return errors_.ErrInvalidValue
+	if n == 0 {
+		return errors_.ErrLockLost
 	}
 
-	return l.rdb.Del(context.Background(), lock)
+	return nil
 }
 
-func (l *DistributedLock) Wait(lock string) error {
-	ticker := time.NewTicker(l.detectInterval)
-	deadlineTimer := time.NewTimer(l.deadline)
-	lock = fmt.Sprintf("lock:%s", lock)
+// watchDog 定时续期 续期失败说明锁已经丢了 取消 ctx 通知持有者
+func (l *Lease) watchDog() {
+	ticker := time.NewTicker(l.lock.renewInterval)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+
 	for {
 		select {
+		case <-l.stop:
+			return
 		case <-ticker.C:
-			isExisted, err := l.rdb.IsExisted(context.Background(), lock)
+			n, err := l.lock.rdb.Eval(context.Background(), renewScript, []string{l.key}, l.id, l.lock.timeout.Milliseconds()).Int64()
 			if err != nil {
-				return err
-			}
-
-			if isExisted {
+				log.Println("lock renew err:", err)
+				// 一直续不上 锁已经过期了
+				if time.Since(renewedAt) >= l.lock.timeout {
+					l.cancel()
+					return
+				}
 				continue
 			}
 
-			return nil
-		case <- deadlineTimer.C:
-			return errors.ErrWaitTimeout
+			if n == 0 {
+				log.Printf("lock %s lost, token: %d\n", l.key, l.token)
+				l.cancel()
+				return
+			}
+			renewedAt = time.Now()
 		}
 	}
 }
-
-// 这个暂时不用 
-func (l *DistributedLock) watchDog() {
-
-}
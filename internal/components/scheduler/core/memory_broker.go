@@ -0,0 +1,692 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	infra_ "stream_hub/pkg/model/infra"
+	"sync"
+	"time"
+)
+
+// MemoryBroker 进程内的实现 单机开发模式使用 进程退出任务就没了
+// 语义和 RedisBroker 保持一致 重试/熔断/超时回收的逻辑可以不依赖 redis 验证
+type MemoryBroker struct {
+	mu        sync.Mutex
+	metas     map[string]map[string]string
+	payloads  map[string][]byte
+	lists     map[string]*memoryList
+	delay     map[string]time.Time
+	running   map[string]time.Time
+	failures  map[string]*memoryFailures
+	breakers  map[string]*memoryBreaker
+	slots     map[string]map[string]time.Time
+	buckets   map[string]*memoryBucket
+	workflows map[string]*memoryWorkflow
+	waits     map[string]*WaitStat
+}
+
+// memoryList 先进先出 notify 用来唤醒阻塞读取的一方
+type memoryList struct {
+	items  []string
+	notify chan struct{}
+}
+
+type memoryFailures struct {
+	ids      map[string]struct{}
+	expireAt time.Time
+}
+
+type memoryBucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+// memoryWorkflow 运行中的工作流 结束后直接删掉
+type memoryWorkflow struct {
+	total int
+	done  map[string]struct{}
+	deps  map[string]int
+	next  map[string][]string
+}
+
+type memoryBreaker struct {
+	state     string
+	changedAt time.Time
+	probes    int
+	successes int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		metas:     make(map[string]map[string]string),
+		payloads:  make(map[string][]byte),
+		lists:     make(map[string]*memoryList),
+		delay:     make(map[string]time.Time),
+		running:   make(map[string]time.Time),
+		failures:  make(map[string]*memoryFailures),
+		breakers:  make(map[string]*memoryBreaker),
+		slots:     make(map[string]map[string]time.Time),
+		buckets:   make(map[string]*memoryBucket),
+		workflows: make(map[string]*memoryWorkflow),
+		waits:     make(map[string]*WaitStat),
+	}
+}
+
+func (b *MemoryBroker) Enqueue(ctx context.Context, task *infra_.TaskMessage) error {
+	payload, err := json.Marshal(&task.Payload)
+	if err != nil {
+		return err
+	}
+	if task.EnqueuedAt == 0 {
+		task.EnqueuedAt = time.Now().UnixMilli()
+	}
+
+	meta := make(map[string]string)
+	for k, v := range task.StructToMap() {
+		switch value := v.(type) {
+		case string:
+			meta[k] = value
+		case int:
+			meta[k] = strconv.Itoa(value)
+		case int64:
+			meta[k] = strconv.FormatInt(value, 10)
+		}
+	}
+
+	b.mu.Lock()
+	b.metas[task.TaskID] = meta
+	b.payloads[task.TaskID] = payload
+	b.push(queueKey(task.Priority), task.TaskID)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) Dequeue(ctx context.Context, priorities []string, workerID string, timeout time.Duration) (string, error) {
+	keys := make([]string, 0, len(priorities))
+	for _, priority := range priorities {
+		keys = append(keys, queueKey(priority))
+	}
+
+	taskID, err := b.pop(ctx, timeout, keys...)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	b.push(activeKey(workerID), taskID)
+	if meta, ok := b.metas[taskID]; ok {
+		if at, _ := strconv.ParseInt(meta["enqueued_at"], 10, 64); at > 0 {
+			wait, ok := b.waits[meta["priority"]]
+			if !ok {
+				wait = new(WaitStat)
+				b.waits[meta["priority"]] = wait
+			}
+			wait.Count++
+			wait.SumMs += max(0, time.Now().UnixMilli()-at)
+		}
+	}
+	b.mu.Unlock()
+
+	return taskID, nil
+}
+
+func (b *MemoryBroker) Receive(ctx context.Context, workerID string, timeout time.Duration) (string, error) {
+	return b.pop(ctx, timeout, activeKey(workerID))
+}
+
+func (b *MemoryBroker) Load(ctx context.Context, taskID string) (*infra_.TaskMessage, error) {
+	b.mu.Lock()
+	meta := b.copyMeta(taskID)
+	payload := b.payloads[taskID]
+	b.mu.Unlock()
+
+	task := new(infra_.TaskMessage)
+	if err := task.TransformByMap(meta); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payload, &task.Payload); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (b *MemoryBroker) Meta(ctx context.Context, taskID string) (map[string]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.copyMeta(taskID), nil
+}
+
+func (b *MemoryBroker) UpdateMeta(ctx context.Context, taskID string, fields map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, ok := b.metas[taskID]
+	if !ok {
+		return nil
+	}
+	for k, v := range fields {
+		meta[k] = v
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Requeue(ctx context.Context, workerID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list, ok := b.lists[activeKey(workerID)]
+	if !ok {
+		return nil
+	}
+
+	for _, taskID := range list.items {
+		b.push(queueKey(b.metas[taskID]["priority"]), taskID)
+	}
+	list.items = nil
+
+	return nil
+}
+
+func (b *MemoryBroker) MarkRunning(ctx context.Context, taskID, nodeID, workerID string, deadline time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, ok := b.metas[taskID]
+	if !ok {
+		return errors_.TaskNotFound
+	}
+	meta["node_id"] = nodeID
+	meta["worker_id"] = workerID
+	b.running[taskID] = deadline
+
+	return nil
+}
+
+func (b *MemoryBroker) Release(ctx context.Context, taskID string, fence Fence) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.running[taskID]
+	delete(b.running, taskID)
+
+	return ok, nil
+}
+
+func (b *MemoryBroker) Expired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return dueMembers(b.running, now, limit), nil
+}
+
+func (b *MemoryBroker) Ack(ctx context.Context, task *infra_.TaskMessage) error {
+	b.mu.Lock()
+	delete(b.metas, task.TaskID)
+	delete(b.payloads, task.TaskID)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) Nack(ctx context.Context, taskID string, errMsg string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	meta, ok := b.metas[taskID]
+	if !ok {
+		meta = make(map[string]string)
+		b.metas[taskID] = meta
+	}
+
+	count, _ := strconv.ParseInt(meta["retry_count"], 10, 64)
+	count++
+	meta["retry_count"] = strconv.FormatInt(count, 10)
+	meta["error_msg"] = errMsg
+
+	return count, nil
+}
+
+func (b *MemoryBroker) Delay(ctx context.Context, taskID string, at time.Time) error {
+	b.mu.Lock()
+	b.delay[taskID] = at.Truncate(time.Second)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) PromoteDue(ctx context.Context, now time.Time, limit int) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	taskIDs := dueMembers(b.delay, now, limit)
+	for _, taskID := range taskIDs {
+		delete(b.delay, taskID)
+		if meta, ok := b.metas[taskID]; ok {
+			meta["enqueued_at"] = strconv.FormatInt(now.UnixMilli(), 10)
+		}
+		b.push(queueKey(b.metas[taskID]["priority"]), taskID)
+	}
+
+	return len(taskIDs), nil
+}
+
+// PromoteAged 列表头部是等得最久的 挪到高一级队列的头部
+func (b *MemoryBroker) PromoteAged(ctx context.Context, from, to string, before time.Time, limit int) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	src := b.list(queueKey(from))
+	aged := make([]string, 0)
+	for len(src.items) > 0 && len(aged) < limit {
+		taskID := src.items[0]
+		meta, ok := b.metas[taskID]
+		if !ok {
+			src.items = src.items[1:]
+			continue
+		}
+
+		at, _ := strconv.ParseInt(meta["enqueued_at"], 10, 64)
+		if at == 0 {
+			meta["enqueued_at"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+			break
+		}
+		if at > before.UnixMilli() {
+			break
+		}
+
+		src.items = src.items[1:]
+		meta["priority"] = to
+		aged = append(aged, taskID)
+	}
+
+	if len(aged) > 0 {
+		dst := b.list(queueKey(to))
+		dst.items = append(aged, dst.items...)
+		dst.signal()
+	}
+
+	return len(aged), nil
+}
+
+func (b *MemoryBroker) Depths(ctx context.Context, priorities []string) (map[string]int64, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queues := make(map[string]int64, len(priorities))
+	for _, priority := range priorities {
+		queues[priority] = int64(len(b.list(queueKey(priority)).items))
+	}
+
+	return queues, int64(len(b.delay)), nil
+}
+
+func (b *MemoryBroker) WaitStats(ctx context.Context, priorities []string) (map[string]WaitStat, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make(map[string]WaitStat, len(priorities))
+	for _, priority := range priorities {
+		if wait, ok := b.waits[priority]; ok {
+			stats[priority] = *wait
+		} else {
+			stats[priority] = WaitStat{}
+		}
+	}
+
+	return stats, nil
+}
+
+func (b *MemoryBroker) DeadLetter(ctx context.Context, taskID string) error {
+	b.mu.Lock()
+	b.push("scheduler:dlq", taskID)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) ReceiveDeadLetter(ctx context.Context, timeout time.Duration) (string, error) {
+	return b.pop(ctx, timeout, "scheduler:dlq")
+}
+
+func (b *MemoryBroker) BreakerAllow(ctx context.Context, taskType string, openTimeout time.Duration, probes int) (string, string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[taskType]
+	if !ok {
+		return constant.BreakerClosed, constant.BreakerClosed, true, nil
+	}
+
+	prev := breaker.state
+	if time.Since(breaker.changedAt) >= openTimeout {
+		b.breakers[taskType] = &memoryBreaker{state: constant.BreakerHalfOpen, changedAt: time.Now()}
+		breaker = b.breakers[taskType]
+	}
+
+	if breaker.state == constant.BreakerOpen || breaker.probes >= probes {
+		return prev, breaker.state, false, nil
+	}
+	breaker.probes++
+
+	return prev, breaker.state, true, nil
+}
+
+func (b *MemoryBroker) BreakerSuccess(ctx context.Context, taskType string, successThreshold int) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[taskType]
+	if !ok {
+		return constant.BreakerClosed, constant.BreakerClosed, nil
+	}
+	if breaker.state != constant.BreakerHalfOpen {
+		return breaker.state, breaker.state, nil
+	}
+
+	breaker.probes--
+	breaker.successes++
+	if breaker.successes >= successThreshold {
+		delete(b.breakers, taskType)
+		delete(b.failures, taskType)
+		return constant.BreakerHalfOpen, constant.BreakerClosed, nil
+	}
+
+	return constant.BreakerHalfOpen, constant.BreakerHalfOpen, nil
+}
+
+func (b *MemoryBroker) BreakerFailure(ctx context.Context, taskType, taskID string, window time.Duration, threshold int) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if breaker, ok := b.breakers[taskType]; ok {
+		prev := breaker.state
+		if prev == constant.BreakerHalfOpen {
+			b.breakers[taskType] = &memoryBreaker{state: constant.BreakerOpen, changedAt: time.Now()}
+		}
+		return prev, constant.BreakerOpen, nil
+	}
+
+	failures, ok := b.failures[taskType]
+	if !ok || !time.Now().Before(failures.expireAt) {
+		failures = &memoryFailures{
+			ids:      make(map[string]struct{}),
+			expireAt: time.Now().Add(window),
+		}
+		b.failures[taskType] = failures
+	}
+	failures.ids[taskID] = struct{}{}
+
+	if len(failures.ids) >= threshold {
+		delete(b.failures, taskType)
+		b.breakers[taskType] = &memoryBreaker{state: constant.BreakerOpen, changedAt: time.Now()}
+		return constant.BreakerClosed, constant.BreakerOpen, nil
+	}
+
+	return constant.BreakerClosed, constant.BreakerClosed, nil
+}
+
+func (b *MemoryBroker) BreakerRelease(ctx context.Context, taskType string, since time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[taskType]
+	if !ok || breaker.state != constant.BreakerHalfOpen || breaker.changedAt.After(since) || breaker.probes <= 0 {
+		return nil
+	}
+	breaker.probes--
+
+	return nil
+}
+
+func (b *MemoryBroker) AcquireSlot(ctx context.Context, taskType, taskID string, limit int, deadline time.Time) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slots, ok := b.slots[taskType]
+	if !ok {
+		slots = make(map[string]time.Time)
+		b.slots[taskType] = slots
+	}
+
+	for _, id := range dueMembers(slots, time.Now(), 0) {
+		delete(slots, id)
+	}
+	if _, ok := slots[taskID]; ok {
+		return true, nil
+	}
+	if len(slots) >= limit {
+		return false, nil
+	}
+	slots[taskID] = deadline
+
+	return true, nil
+}
+
+func (b *MemoryBroker) ReleaseSlot(ctx context.Context, taskType, taskID string) error {
+	b.mu.Lock()
+	delete(b.slots[taskType], taskID)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) TakeToken(ctx context.Context, taskType string, rate float64, burst int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := b.buckets[taskType]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(burst), ts: now}
+		b.buckets[taskType] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.ts).Seconds()*rate)
+	bucket.ts = now
+	if bucket.tokens < 1 {
+		return false, nil
+	}
+	bucket.tokens--
+
+	return true, nil
+}
+
+func (b *MemoryBroker) SendCancel(ctx context.Context, nodeID, taskID string) error {
+	b.mu.Lock()
+	b.push("scheduler:control:"+nodeID, taskID)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *MemoryBroker) ReceiveCancel(ctx context.Context, nodeID string, timeout time.Duration) (string, error) {
+	return b.pop(ctx, timeout, "scheduler:control:"+nodeID)
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, taskID string, event []byte) error {
+	return nil
+}
+
+func (b *MemoryBroker) StartWorkflow(ctx context.Context, workflowID string, total int, deps map[string]int, next map[string][]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.workflows[workflowID]; ok {
+		return nil
+	}
+
+	workflow := &memoryWorkflow{
+		total: total,
+		done:  make(map[string]struct{}),
+		deps:  make(map[string]int, len(deps)),
+		next:  make(map[string][]string, len(next)),
+	}
+	for taskID, n := range deps {
+		workflow.deps[taskID] = n
+	}
+	for taskID, successors := range next {
+		workflow.next[taskID] = append([]string(nil), successors...)
+	}
+	b.workflows[workflowID] = workflow
+
+	return nil
+}
+
+func (b *MemoryBroker) CompleteWorkflow(ctx context.Context, workflowID, taskID string) ([]string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	workflow, ok := b.workflows[workflowID]
+	if !ok {
+		return nil, false, nil
+	}
+	if _, ok := workflow.done[taskID]; ok {
+		return nil, false, nil
+	}
+	workflow.done[taskID] = struct{}{}
+
+	ready := make([]string, 0)
+	for _, next := range workflow.next[taskID] {
+		workflow.deps[next]--
+		if workflow.deps[next] <= 0 {
+			delete(workflow.deps, next)
+			ready = append(ready, next)
+		}
+	}
+
+	if len(workflow.done) < workflow.total {
+		return ready, false, nil
+	}
+	delete(b.workflows, workflowID)
+
+	return ready, true, nil
+}
+
+func (b *MemoryBroker) FailWorkflow(ctx context.Context, workflowID string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	workflow, ok := b.workflows[workflowID]
+	if !ok {
+		return nil, nil
+	}
+	delete(b.workflows, workflowID)
+
+	waiting := make([]string, 0, len(workflow.deps))
+	for taskID := range workflow.deps {
+		waiting = append(waiting, taskID)
+	}
+	sort.Strings(waiting)
+
+	return waiting, nil
+}
+
+// push 调用方持有锁
+func (b *MemoryBroker) push(key, taskID string) {
+	list := b.list(key)
+	list.items = append(list.items, taskID)
+	list.signal()
+}
+
+// pop 按 keys 的顺序从第一个不为空的列表取 都为空时等待任意一个列表的通知
+func (b *MemoryBroker) pop(ctx context.Context, timeout time.Duration, keys ...string) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		b.mu.Lock()
+		lists := make([]*memoryList, 0, len(keys))
+		for _, key := range keys {
+			lists = append(lists, b.list(key))
+		}
+		for _, list := range lists {
+			if len(list.items) == 0 {
+				continue
+			}
+
+			taskID := list.items[0]
+			list.items = list.items[1:]
+			// 还有剩余的 唤醒下一个等待者
+			for _, l := range lists {
+				if len(l.items) > 0 {
+					l.signal()
+				}
+			}
+			b.mu.Unlock()
+			return taskID, nil
+		}
+		b.mu.Unlock()
+
+		cases := make([]reflect.SelectCase, 0, len(lists)+2)
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		)
+		for _, list := range lists {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(list.notify)})
+		}
+
+		switch chosen, _, _ := reflect.Select(cases); chosen {
+		case 0:
+			return "", errors_.ErrNoTask
+		case 1:
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (l *memoryList) signal() {
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *MemoryBroker) list(key string) *memoryList {
+	list, ok := b.lists[key]
+	if !ok {
+		list = &memoryList{notify: make(chan struct{}, 1)}
+		b.lists[key] = list
+	}
+
+	return list
+}
+
+func (b *MemoryBroker) copyMeta(taskID string) map[string]string {
+	meta := make(map[string]string, len(b.metas[taskID]))
+	for k, v := range b.metas[taskID] {
+		meta[k] = v
+	}
+
+	return meta
+}
+
+// dueMembers 时间不晚于 now 的成员 按时间先后
+func dueMembers(members map[string]time.Time, now time.Time, limit int) []string {
+	taskIDs := make([]string, 0)
+	for taskID, at := range members {
+		if !at.After(now) {
+			taskIDs = append(taskIDs, taskID)
+		}
+	}
+
+	sort.Slice(taskIDs, func(i, j int) bool {
+		return members[taskIDs[i]].Before(members[taskIDs[j]])
+	})
+	if limit > 0 && len(taskIDs) > limit {
+		taskIDs = taskIDs[:limit]
+	}
+
+	return taskIDs
+}
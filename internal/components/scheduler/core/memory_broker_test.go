@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// a -> b, a -> c, (b, c) -> d
+func TestMemoryWorkflow(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx := context.Background()
+
+	deps := map[string]int{"b": 1, "c": 1, "d": 2}
+	next := map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}}
+	if err := broker.StartWorkflow(ctx, "w1", 4, deps, next); err != nil {
+		t.Fatal(err)
+	}
+	// 重复初始化不覆盖
+	if err := broker.StartWorkflow(ctx, "w1", 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		taskID   string
+		ready    []string
+		finished bool
+	}{
+		{"a", []string{"b", "c"}, false},
+		{"a", nil, false}, // 重复完成不重复计数
+		{"b", []string{}, false},
+		{"c", []string{"d"}, false},
+		{"d", []string{}, true},
+	}
+	for _, step := range steps {
+		ready, finished, err := broker.CompleteWorkflow(ctx, "w1", step.taskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ready, step.ready) || finished != step.finished {
+			t.Errorf("complete %s = %v %v, want %v %v", step.taskID, ready, finished, step.ready, step.finished)
+		}
+	}
+}
+
+func TestMemoryWorkflowFail(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx := context.Background()
+
+	if err := broker.StartWorkflow(ctx, "w1", 3, map[string]int{"b": 1, "c": 1}, map[string][]string{"a": {"b"}, "b": {"c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	waiting, err := broker.FailWorkflow(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(waiting, []string{"b", "c"}) {
+		t.Errorf("waiting = %v", waiting)
+	}
+
+	// 失败之后成员的完成不再投递后继
+	ready, finished, err := broker.CompleteWorkflow(ctx, "w1", "a")
+	if err != nil || len(ready) != 0 || finished {
+		t.Errorf("complete after fail = %v %v %v", ready, finished, err)
+	}
+}
+
+func TestMemoryWaitStats(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx := context.Background()
+
+	enqueueTest(t, broker, "t1", "transcode")
+	enqueueTest(t, broker, "t2", "transcode")
+	// 入队时间往前拨 模拟在队列里等了 2s
+	at := strconv.FormatInt(time.Now().Add(-2*time.Second).UnixMilli(), 10)
+	broker.mu.Lock()
+	broker.metas["t1"]["enqueued_at"] = at
+	broker.metas["t2"]["enqueued_at"] = at
+	broker.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if _, err := broker.Dequeue(ctx, []string{"default"}, "worker1", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := broker.WaitStats(ctx, []string{"critical", "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["critical"] != (WaitStat{}) {
+		t.Errorf("critical = %+v, want empty", stats["critical"])
+	}
+	if wait := stats["default"]; wait.Count != 2 || wait.SumMs < 4000 {
+		t.Errorf("default = %+v", wait)
+	}
+}
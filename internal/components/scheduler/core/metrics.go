@@ -0,0 +1,108 @@
+package core
+
+import (
+	"log"
+	metric_sdk "stream_hub/internal/components/metric/client"
+	"time"
+)
+
+// Metrics 调度器指标 每个节点在自己的 /metrics 上暴露 集群汇总交给 Prometheus
+// 队列长度和等待时间每个节点的 dispatcher 扫描时都会刷新 不依赖是否抢到锁
+// 等待时间是集群共享的累计值 count / sum 相除是平均等待 用 rate 看一段时间内的变化
+var Metrics = metric_sdk.NewMetrics()
+
+var (
+	tasksStarted = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_started_total",
+		Help:   "Tasks picked up by workers.",
+		Labels: []string{"type"},
+	})
+	tasksSucceeded = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_succeeded_total",
+		Help:   "Tasks finished successfully.",
+		Labels: []string{"type"},
+	})
+	tasksRetried = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_retried_total",
+		Help:   "Failed tasks scheduled for another attempt.",
+		Labels: []string{"type"},
+	})
+	tasksDeadLettered = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_dead_lettered_total",
+		Help:   "Tasks consumed from the dead letter queue and marked failed.",
+		Labels: []string{"type"},
+	})
+	taskDuration = metric_sdk.NewHistogramVector(metric_sdk.HistogramVectorOptions{
+		Name:              "scheduler_task_duration_seconds",
+		Help:              "Handler latency by task type and result.",
+		Labels:            []string{"type", "result"},
+		BucketsBoundaries: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	})
+	queueDepth = metric_sdk.NewGaugeVector(metric_sdk.GaugeVectorOptions{
+		Name:   "scheduler_queue_depth",
+		Help:   "Tasks waiting in each priority queue.",
+		Labels: []string{"queue"},
+	})
+	queueWaitCount = metric_sdk.NewGaugeVector(metric_sdk.GaugeVectorOptions{
+		Name:   "scheduler_queue_wait_count",
+		Help:   "Tasks dequeued from each priority queue, cluster wide and cumulative.",
+		Labels: []string{"queue"},
+	})
+	queueWaitSeconds = metric_sdk.NewGaugeVector(metric_sdk.GaugeVectorOptions{
+		Name:   "scheduler_queue_wait_seconds_sum",
+		Help:   "Total time dequeued tasks waited in each priority queue, cluster wide and cumulative.",
+		Labels: []string{"queue"},
+	})
+	delayedTasks = metric_sdk.NewGaugeVector(metric_sdk.GaugeVectorOptions{
+		Name: "scheduler_delayed_tasks",
+		Help: "Tasks waiting in the delayed set.",
+	})
+	tasksPromoted = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_promoted_total",
+		Help:   "Tasks moved into priority queues by the dispatcher, reason is due or aged.",
+		Labels: []string{"reason"},
+	})
+	tasksReclaimed = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name:   "scheduler_tasks_reclaimed_total",
+		Help:   "Running tasks reclaimed by the janitor after their deadline.",
+		Labels: []string{"type"},
+	})
+	workersCleaned = metric_sdk.NewCounterVector(metric_sdk.CounterVectorOptions{
+		Name: "scheduler_workers_cleaned_total",
+		Help: "Dead workers whose active tasks were requeued by the janitor.",
+	})
+)
+
+func init() {
+	Metrics.MustRegister(tasksStarted, tasksSucceeded, tasksRetried, tasksDeadLettered, taskDuration,
+		queueDepth, queueWaitCount, queueWaitSeconds, delayedTasks, tasksPromoted, tasksReclaimed, workersCleaned)
+}
+
+// 标签数量写错是代码问题 记日志不影响任务
+
+func incCounter(cv *metric_sdk.CounterVector, n uint64, labels ...string) {
+	counter, err := cv.WithLabelValues(labels...)
+	if err != nil {
+		log.Println("metric err:", err)
+		return
+	}
+	counter.Add(n)
+}
+
+func setGauge(gv *metric_sdk.GaugeVector, v float64, labels ...string) {
+	gauge, err := gv.WithLabelValues(labels...)
+	if err != nil {
+		log.Println("metric err:", err)
+		return
+	}
+	gauge.Set(v)
+}
+
+func observeDuration(hv *metric_sdk.HistogramVector, start time.Time, labels ...string) {
+	histogram, err := hv.WithLabelValues(labels...)
+	if err != nil {
+		log.Println("metric err:", err)
+		return
+	}
+	histogram.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	infra_ "stream_hub/pkg/model/infra"
+	"time"
+)
+
+// Recovery handler panic 时转成 TaskPanic 错误 走正常的重试流程 不会打挂 worker
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, task *infra_.TaskMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("task %s panic: %v\n%s", task.TaskID, r, debug.Stack())
+					err = fmt.Errorf("%w: %v", errors_.TaskPanic, r)
+				}
+			}()
+
+			return next(ctx, task)
+		}
+	}
+}
+
+// Logging 按任务类型记录耗时和结果 path 为任务类型 trace_id 为任务ID
+// status: 200 成功 / 404 没有 handler / 500 失败
+func Logging(logger *infra.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, task *infra_.TaskMessage) error {
+			start := time.Now()
+			err := next(ctx, task)
+			latency := time.Since(start).Microseconds()
+
+			switch {
+			case err == nil:
+				logger.Info("task processed", "", "", task.TaskID, "TASK", task.Type, constant.Scheduler, 200, latency)
+			case errors.Is(err, errors_.TaskNoHandler):
+				logger.Error(err.Error(), "", "", task.TaskID, "TASK", task.Type, constant.Scheduler, 404, latency)
+			default:
+				logger.Error(err.Error(), "", "", task.TaskID, "TASK", task.Type, constant.Scheduler, 500, latency)
+			}
+
+			return err
+		}
+	}
+}
@@ -2,39 +2,77 @@ package core
 
 import (
 	"math/rand"
+	"sort"
 	"sync"
-	"fmt"
 	"time"
 )
 
 type Picker struct {
-	pool []string
-	mu   sync.RWMutex
-	rng  *rand.Rand
+	weights map[string]int
+	total   int
+	mu      sync.Mutex
+	rng     *rand.Rand
 }
 
 func NewQueuePicker(weights map[string]int) *Picker {
-	var pool []string
-	for priority, weight := range weights {
-		for i := 0; i < weight; i++ {
-			queue := fmt.Sprintf("scheduler:queue:%s", priority)
-			pool = append(pool, queue)
-		}
+	total := 0
+	for _, weight := range weights {
+		total += weight
 	}
 
 	return &Picker{
-		pool: pool,
-		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		weights: weights,
+		total:   total,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
-func (p *Picker) NextQueue() string {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+// NextQueues 按权重随机排出所有优先级的顺序 权重越大越可能排在前面
+// 配合多个 key 的 BRPop 使用 排在前面的队列空了会取后面的 worker 不会在空队列上干等
+func (p *Picker) NextQueues() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	remain := make(map[string]int, len(p.weights))
+	for priority, weight := range p.weights {
+		remain[priority] = weight
+	}
+	total := p.total
+
+	queues := make([]string, 0, len(remain))
+	for total > 0 {
+		n := p.rng.Intn(total)
+		for _, priority := range sortedKeys(remain) {
+			if n < remain[priority] {
+				queues = append(queues, priority)
+				total -= remain[priority]
+				delete(remain, priority)
+				break
+			}
+			n -= remain[priority]
+		}
+	}
+
+	return queues
+}
+
+// Tiers 按权重从高到低的优先级 老化时从低往高挪
+func (p *Picker) Tiers() []string {
+	tiers := sortedKeys(p.weights)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return p.weights[tiers[i]] > p.weights[tiers[j]]
+	})
+
+	return tiers
+}
 
-	if len(p.pool) == 0 {
-		return ""
+// sortedKeys map 的遍历顺序不固定 同样的随机数要对应同样的结果
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return p.pool[p.rng.Intn(len(p.pool))]
+	return keys
 }
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+)
+
+type progressCtxKey struct{}
+
+// Progress 任务进度和结果 写到 task:meta 和任务记录 并通过 broker 发布到 task:progress:{id} 供 SSE 订阅
+// 内存模式不发布 订阅方只能轮询任务记录
+type Progress struct {
+	db     *infra.DB
+	broker Broker
+}
+
+func NewProgress(db *infra.DB, broker Broker) *Progress {
+	return &Progress{
+		db:     db,
+		broker: broker,
+	}
+}
+
+type progressCtx struct {
+	progress *Progress
+	taskID   string
+}
+
+// withTask worker 执行任务前把上报入口放进 ctx
+func (p *Progress) withTask(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, progressCtxKey{}, &progressCtx{progress: p, taskID: taskID})
+}
+
+// ReportProgress handler 上报进度 percent 取值 0-100 ctx 不是 worker 给的时候什么都不做
+func ReportProgress(ctx context.Context, percent int, msg string) error {
+	pc, ok := ctx.Value(progressCtxKey{}).(*progressCtx)
+	if !ok {
+		return nil
+	}
+
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	return pc.progress.report(ctx, pc.taskID, percent, msg)
+}
+
+// SetResult handler 写入执行结果 result 会被序列化成 JSON
+func SetResult(ctx context.Context, result interface{}) error {
+	pc, ok := ctx.Value(progressCtxKey{}).(*progressCtx)
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return pc.progress.result(ctx, pc.taskID, data)
+}
+
+func (p *Progress) report(ctx context.Context, taskID string, percent int, msg string) error {
+	if err := p.broker.UpdateMeta(ctx, taskID, map[string]string{
+		"progress":     strconv.Itoa(percent),
+		"progress_msg": msg,
+	}); err != nil {
+		return err
+	}
+
+	if err := p.db.Model(&storage.Task{}).Where("id = ?", taskID).Updates(map[string]interface{}{
+		"progress":     percent,
+		"progress_msg": msg,
+	}).Error; err != nil {
+		return err
+	}
+
+	p.publish(ctx, &infra_.TaskProgress{
+		TaskID:   taskID,
+		Status:   constant.TaskPending,
+		Progress: percent,
+		Msg:      msg,
+	})
+
+	return nil
+}
+
+func (p *Progress) result(ctx context.Context, taskID string, result []byte) error {
+	if err := p.broker.UpdateMeta(ctx, taskID, map[string]string{"result": string(result)}); err != nil {
+		return err
+	}
+
+	return p.db.Model(&storage.Task{}).Where("id = ?", taskID).Update("result", string(result)).Error
+}
+
+// finish 任务成功或者进入死信 通知订阅方结束 结果由订阅方从任务记录读取
+func (p *Progress) finish(ctx context.Context, taskID string, status int8, errMsg string) {
+	event := &infra_.TaskProgress{
+		TaskID:   taskID,
+		Status:   status,
+		ErrorMsg: errMsg,
+	}
+	if status == constant.TaskSuccess {
+		event.Progress = 100
+	}
+
+	p.publish(ctx, event)
+}
+
+// publish 发布失败只影响实时推送 不影响任务本身
+func (p *Progress) publish(ctx context.Context, event *infra_.TaskProgress) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println("err:", err)
+		return
+	}
+
+	if err := p.broker.Publish(ctx, event.TaskID, data); err != nil {
+		log.Println("err:", err)
+	}
+}
@@ -0,0 +1,681 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 到期的延时任务按 meta 里的优先级放回队列 放在一个脚本里 多个节点同时执行也不会重复投递
+const promoteScript = `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	local priority = redis.call("HGET", "task:meta:" .. id, "priority")
+	if not priority or priority == "" then
+		priority = "default"
+	end
+	redis.call("HSET", "task:meta:" .. id, "enqueued_at", ARGV[3])
+	redis.call("LPUSH", "scheduler:queue:" .. priority, id)
+end
+return #ids
+`
+
+// 按优先级顺序从队尾取一个任务放进 worker 的 active 队列 同时累计所在优先级的等待时间
+// 取出和放进 active 在一个脚本里 中途出错不会丢任务
+// KEYS[1] 是 active 队列 之后每两个是一个优先级的队列和等待统计
+const claimScript = `
+for i = 2, #KEYS, 2 do
+	local id = redis.call("RPOP", KEYS[i])
+	if id then
+		redis.call("LPUSH", KEYS[1], id)
+		local at = tonumber(redis.call("HGET", "task:meta:" .. id, "enqueued_at") or "")
+		if at and at > 0 then
+			redis.call("HINCRBY", KEYS[i + 1], "count", 1)
+			redis.call("HINCRBY", KEYS[i + 1], "sum_ms", math.max(0, tonumber(ARGV[1]) - at))
+		end
+		return id
+	end
+end
+return false
+`
+
+// 队尾是等得最久的任务 等待超过阈值就挪到高一级队列的队尾(下一个被取走) 没超过说明后面的也没超过
+// 没有 enqueued_at 的老数据从现在开始计时 meta 已经不存在的任务直接丢掉
+const agingScript = `
+local moved = 0
+while moved < tonumber(ARGV[2]) do
+	local id = redis.call("LINDEX", KEYS[1], -1)
+	if not id then
+		break
+	end
+	local meta = "task:meta:" .. id
+	if redis.call("EXISTS", meta) == 0 then
+		redis.call("RPOP", KEYS[1])
+	else
+		local at = tonumber(redis.call("HGET", meta, "enqueued_at") or "")
+		if not at or at == 0 then
+			redis.call("HSET", meta, "enqueued_at", ARGV[3])
+			break
+		end
+		if at > tonumber(ARGV[1]) then
+			break
+		end
+		redis.call("RPOP", KEYS[1])
+		redis.call("HSET", meta, "priority", ARGV[4])
+		redis.call("RPUSH", KEYS[2], id)
+		moved = moved + 1
+	end
+end
+return moved
+`
+
+// 任务数据不在了说明已经被取消 不能再 HSET 把 meta 写回来
+const markRunningScript = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "node_id", ARGV[2], "worker_id", ARGV[3])
+redis.call("ZADD", KEYS[2], ARGV[4], ARGV[1])
+return 1
+`
+
+// half_open 太久没有结果(探测任务丢了)也重新开始探测
+const breakerAllowScript = `
+local state = redis.call("HGET", KEYS[1], "state")
+if not state then
+	return {"closed", "closed", 1}
+end
+local prev = state
+local now = tonumber(ARGV[1])
+local changed = tonumber(redis.call("HGET", KEYS[1], "changed_at") or "0")
+if now - changed >= tonumber(ARGV[2]) then
+	state = "half_open"
+	redis.call("HSET", KEYS[1], "state", state, "changed_at", ARGV[1], "probes", 0, "successes", 0)
+end
+if state == "open" then
+	return {prev, state, 0}
+end
+if redis.call("HINCRBY", KEYS[1], "probes", 1) > tonumber(ARGV[3]) then
+	redis.call("HINCRBY", KEYS[1], "probes", -1)
+	return {prev, state, 0}
+end
+return {prev, state, 1}
+`
+
+const breakerSuccessScript = `
+local state = redis.call("HGET", KEYS[1], "state")
+if not state then
+	return {"closed", "closed"}
+end
+if state ~= "half_open" then
+	return {state, state}
+end
+redis.call("HINCRBY", KEYS[1], "probes", -1)
+if redis.call("HINCRBY", KEYS[1], "successes", 1) >= tonumber(ARGV[1]) then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	return {state, "closed"}
+end
+return {state, state}
+`
+
+const breakerReleaseScript = `
+if redis.call("HGET", KEYS[1], "state") ~= "half_open" then
+	return 0
+end
+if tonumber(redis.call("HGET", KEYS[1], "changed_at") or "0") > tonumber(ARGV[1]) then
+	return 0
+end
+if tonumber(redis.call("HGET", KEYS[1], "probes") or "0") <= 0 then
+	return 0
+end
+redis.call("HINCRBY", KEYS[1], "probes", -1)
+return 1
+`
+
+const breakerFailureScript = `
+local state = redis.call("HGET", KEYS[1], "state")
+if state == "open" then
+	return {state, state}
+end
+if state == "half_open" then
+	redis.call("HSET", KEYS[1], "state", "open", "changed_at", ARGV[4], "probes", 0, "successes", 0)
+	return {state, "open"}
+end
+redis.call("SADD", KEYS[2], ARGV[1])
+local count = redis.call("SCARD", KEYS[2])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[2], ARGV[2])
+end
+if count >= tonumber(ARGV[3]) then
+	redis.call("DEL", KEYS[2])
+	redis.call("HSET", KEYS[1], "state", "open", "changed_at", ARGV[4], "probes", 0, "successes", 0)
+	return {"closed", "open"}
+end
+return {"closed", "closed"}
+`
+
+// 先清掉过期的名额 再判断是否还有空位
+const acquireSlotScript = `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZSCORE", KEYS[1], ARGV[3]) then
+	return 1
+end
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[4], ARGV[3])
+return 1
+`
+
+// 令牌按上次取令牌到现在的时间补充 ts 单位为 ms
+const takeTokenScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", ARGV[3])
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`
+
+// 工作流结束后 redis 里的状态保留一段时间 方便排查
+const workflowExpiry = time.Hour * 24
+
+// 队列都是空的时候 Dequeue 重新检查的间隔
+const dequeuePollInterval = time.Millisecond * 200
+
+const startWorkflowScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "total", ARGV[1], "done", 0, "status", "running")
+local i = 3
+for _ = 1, tonumber(ARGV[2]) do
+	redis.call("HSET", KEYS[2], ARGV[i], ARGV[i + 1])
+	i = i + 2
+end
+while i < #ARGV do
+	redis.call("HSET", KEYS[3], ARGV[i], ARGV[i + 1])
+	i = i + 2
+end
+return 1
+`
+
+// 回收超时任务 锁已经被别的节点拿到时返回 -1
+const releaseRunningScript = fenceGuard + `
+return redis.call("ZREM", KEYS[2], ARGV[2])
+`
+
+// 返回值第一个元素是 finished 后面是可以投递的后继任务
+const completeWorkflowScript = `
+if redis.call("HGET", KEYS[1], "status") ~= "running" then
+	return {"0"}
+end
+if redis.call("SADD", KEYS[4], ARGV[1]) == 0 then
+	return {"0"}
+end
+local res = {"0"}
+local next = redis.call("HGET", KEYS[3], ARGV[1])
+if next then
+	for id in string.gmatch(next, "[^,]+") do
+		if redis.call("HINCRBY", KEYS[2], id, -1) <= 0 then
+			redis.call("HDEL", KEYS[2], id)
+			table.insert(res, id)
+		end
+	end
+end
+if redis.call("HINCRBY", KEYS[1], "done", 1) >= tonumber(redis.call("HGET", KEYS[1], "total")) then
+	redis.call("HSET", KEYS[1], "status", "success")
+	for _, key in ipairs(KEYS) do
+		redis.call("PEXPIRE", key, ARGV[2])
+	end
+	res[1] = "1"
+end
+return res
+`
+
+const failWorkflowScript = `
+if redis.call("HGET", KEYS[1], "status") ~= "running" then
+	return {}
+end
+redis.call("HSET", KEYS[1], "status", "failed")
+local waiting = redis.call("HKEYS", KEYS[2])
+redis.call("DEL", KEYS[2])
+for _, key in ipairs(KEYS) do
+	redis.call("PEXPIRE", key, ARGV[1])
+end
+return waiting
+`
+
+// RedisBroker 集群模式 key 的约定和 TaskSender / Inspector 保持一致
+// task:meta:{id} task:payload:{id}     任务数据
+// scheduler:queue:{priority}           优先级队列
+// scheduler:wait:{priority}            出队任务的等待时间 count / sum_ms
+// scheduler:active:worker_{id}         worker 的私有队列
+// task:delay                           延时/重试 zset score 为执行时间(s)
+// scheduler:running                    执行中 zset score 为截止时间(ms)
+// scheduler:dlq                        死信队列
+// scheduler:failed:{type}              类型 closed 状态下的失败集合
+// scheduler:breaker:{type}             类型的熔断器 state / changed_at / probes / successes
+// scheduler:limit:{type}               类型执行中的名额 zset score 为失效时间(ms)
+// scheduler:rate:{type}                类型的令牌桶 tokens / ts
+// scheduler:control:{node}             节点的控制通道
+// task:progress:{id}                  任务进度的发布订阅频道
+// workflow:{id}                        工作流 total / done / status
+// workflow:{id}:deps                   还没投递的任务 -> 剩余未完成的依赖数
+// workflow:{id}:next                   任务 -> 后继任务ID(逗号分隔)
+// workflow:{id}:done                   已经成功的任务 防止重复计数
+type RedisBroker struct {
+	rdb        *infra.Redis
+	delayKey   string
+	runningKey string
+	dlqKey     string
+	controlKey string
+}
+
+func NewRedisBroker(rdb *infra.Redis, conf *config.SchedulerConfig) *RedisBroker {
+	return &RedisBroker{
+		rdb:        rdb,
+		delayKey:   conf.Dispatcher.Queue,
+		runningKey: conf.Timeout.RunningKey,
+		dlqKey:     conf.DeadLetter.QueueKey,
+		controlKey: conf.Timeout.ControlKey,
+	}
+}
+
+func (b *RedisBroker) Enqueue(ctx context.Context, task *infra_.TaskMessage) error {
+	payload, err := json.Marshal(&task.Payload)
+	if err != nil {
+		return err
+	}
+	if task.EnqueuedAt == 0 {
+		task.EnqueuedAt = time.Now().UnixMilli()
+	}
+
+	pipeline := b.rdb.TxPipeline()
+	pipeline.HSet(ctx, "task:meta:"+task.TaskID, task.StructToMap())
+	pipeline.Set(ctx, "task:payload:"+task.TaskID, payload, -1)
+	pipeline.LPush(ctx, queueKey(task.Priority), task.TaskID)
+	_, err = pipeline.Exec(ctx)
+
+	return err
+}
+
+// Dequeue 队列都是空的时候轮询 直到 timeout
+func (b *RedisBroker) Dequeue(ctx context.Context, priorities []string, workerID string, timeout time.Duration) (string, error) {
+	keys := make([]string, 0, 2*len(priorities)+1)
+	keys = append(keys, activeKey(workerID))
+	for _, priority := range priorities {
+		keys = append(keys, queueKey(priority), waitKey(priority))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		taskID, err := b.rdb.Eval(ctx, claimScript, keys, time.Now().UnixMilli()).Text()
+		if err == nil {
+			return taskID, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return "", err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return "", errors_.ErrNoTask
+		}
+		if wait > dequeuePollInterval {
+			wait = dequeuePollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (b *RedisBroker) Receive(ctx context.Context, workerID string, timeout time.Duration) (string, error) {
+	return b.pop(ctx, activeKey(workerID), timeout)
+}
+
+func (b *RedisBroker) Load(ctx context.Context, taskID string) (*infra_.TaskMessage, error) {
+	pipeline := b.rdb.Pipeline()
+	metaCmd := pipeline.HGetAll(ctx, "task:meta:"+taskID)
+	dataCmd := pipeline.Get(ctx, "task:payload:"+taskID)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	task := new(infra_.TaskMessage)
+	if err := task.TransformByMap(metaCmd.Val()); err != nil {
+		return nil, err
+	}
+
+	data, _ := dataCmd.Bytes()
+	if err := json.Unmarshal(data, &task.Payload); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (b *RedisBroker) Meta(ctx context.Context, taskID string) (map[string]string, error) {
+	return b.rdb.HGetAll(ctx, "task:meta:"+taskID)
+}
+
+func (b *RedisBroker) UpdateMeta(ctx context.Context, taskID string, fields map[string]string) error {
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = v
+	}
+
+	return b.rdb.HSet(ctx, "task:meta:"+taskID, values)
+}
+
+func (b *RedisBroker) Requeue(ctx context.Context, workerID string) error {
+	for {
+		taskID, err := b.rdb.RPop(ctx, activeKey(workerID))
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return err
+		}
+
+		priority := b.rdb.HGet(ctx, "task:meta:"+taskID, "priority").Val()
+		if err := b.rdb.LPush(ctx, queueKey(priority), taskID); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *RedisBroker) MarkRunning(ctx context.Context, taskID, nodeID, workerID string, deadline time.Time) error {
+	n, err := b.rdb.Eval(ctx, markRunningScript, []string{"task:meta:" + taskID, b.runningKey},
+		taskID, nodeID, workerID, deadline.UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors_.TaskNotFound
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Release(ctx context.Context, taskID string, fence Fence) (bool, error) {
+	if fence.Key == "" {
+		n, err := b.rdb.ZRem(ctx, b.runningKey, taskID).Result()
+		return n > 0, err
+	}
+
+	n, err := b.rdb.Eval(ctx, releaseRunningScript, []string{fence.Key, b.runningKey}, fence.Token, taskID).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, errors_.ErrLockLost
+	}
+
+	return n > 0, nil
+}
+
+func (b *RedisBroker) Expired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return b.rdb.ZRangeByScore(ctx, b.runningKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+func (b *RedisBroker) Ack(ctx context.Context, task *infra_.TaskMessage) error {
+	if err := b.rdb.Del(ctx, "task:meta:"+task.TaskID, "task:payload:"+task.TaskID); err != nil {
+		return err
+	}
+
+	return releaseUnique(ctx, b.rdb, task.UniqueKey, task.TaskID)
+}
+
+func (b *RedisBroker) Nack(ctx context.Context, taskID string, errMsg string) (int64, error) {
+	pipeline := b.rdb.Pipeline()
+	countCmd := pipeline.HIncrBy(ctx, "task:meta:"+taskID, "retry_count", 1)
+	pipeline.HSet(ctx, "task:meta:"+taskID, "error_msg", errMsg)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return countCmd.Val(), nil
+}
+
+func (b *RedisBroker) Delay(ctx context.Context, taskID string, at time.Time) error {
+	return b.rdb.ZAdd(ctx, b.delayKey, &redis.Z{
+		Score:  float64(at.Unix()),
+		Member: taskID,
+	})
+}
+
+func (b *RedisBroker) PromoteDue(ctx context.Context, now time.Time, limit int) (int, error) {
+	n, err := b.rdb.Eval(ctx, promoteScript, []string{b.delayKey}, now.Unix(), limit, now.UnixMilli()).Int()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (b *RedisBroker) PromoteAged(ctx context.Context, from, to string, before time.Time, limit int) (int, error) {
+	return b.rdb.Eval(ctx, agingScript, []string{queueKey(from), queueKey(to)},
+		before.UnixMilli(), limit, time.Now().UnixMilli(), to).Int()
+}
+
+func (b *RedisBroker) Depths(ctx context.Context, priorities []string) (map[string]int64, int64, error) {
+	pipeline := b.rdb.Pipeline()
+	cmds := make(map[string]*redis.IntCmd, len(priorities))
+	for _, priority := range priorities {
+		cmds[priority] = pipeline.LLen(ctx, queueKey(priority))
+	}
+	delayCmd := pipeline.ZCard(ctx, b.delayKey)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	queues := make(map[string]int64, len(cmds))
+	for priority, cmd := range cmds {
+		queues[priority] = cmd.Val()
+	}
+
+	return queues, delayCmd.Val(), nil
+}
+
+func (b *RedisBroker) WaitStats(ctx context.Context, priorities []string) (map[string]WaitStat, error) {
+	pipeline := b.rdb.Pipeline()
+	cmds := make(map[string]*redis.StringStringMapCmd, len(priorities))
+	for _, priority := range priorities {
+		cmds[priority] = pipeline.HGetAll(ctx, waitKey(priority))
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]WaitStat, len(cmds))
+	for priority, cmd := range cmds {
+		data := cmd.Val()
+		count, _ := strconv.ParseInt(data["count"], 10, 64)
+		sum, _ := strconv.ParseInt(data["sum_ms"], 10, 64)
+		stats[priority] = WaitStat{Count: count, SumMs: sum}
+	}
+
+	return stats, nil
+}
+
+func (b *RedisBroker) DeadLetter(ctx context.Context, taskID string) error {
+	return b.rdb.LPush(ctx, b.dlqKey, taskID)
+}
+
+func (b *RedisBroker) ReceiveDeadLetter(ctx context.Context, timeout time.Duration) (string, error) {
+	return b.pop(ctx, b.dlqKey, timeout)
+}
+
+func (b *RedisBroker) BreakerAllow(ctx context.Context, taskType string, openTimeout time.Duration, probes int) (string, string, bool, error) {
+	res, err := b.rdb.Eval(ctx, breakerAllowScript, []string{breakerKey(taskType)},
+		time.Now().UnixMilli(), openTimeout.Milliseconds(), probes).Slice()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	allowed, _ := res[2].(int64)
+	return res[0].(string), res[1].(string), allowed == 1, nil
+}
+
+func (b *RedisBroker) BreakerSuccess(ctx context.Context, taskType string, successThreshold int) (string, string, error) {
+	res, err := b.rdb.Eval(ctx, breakerSuccessScript, []string{breakerKey(taskType), failedKey(taskType)}, successThreshold).StringSlice()
+	if err != nil {
+		return "", "", err
+	}
+
+	return res[0], res[1], nil
+}
+
+func (b *RedisBroker) BreakerFailure(ctx context.Context, taskType, taskID string, window time.Duration, threshold int) (string, string, error) {
+	res, err := b.rdb.Eval(ctx, breakerFailureScript, []string{breakerKey(taskType), failedKey(taskType)},
+		taskID, window.Milliseconds(), threshold, time.Now().UnixMilli()).StringSlice()
+	if err != nil {
+		return "", "", err
+	}
+
+	return res[0], res[1], nil
+}
+
+func (b *RedisBroker) BreakerRelease(ctx context.Context, taskType string, since time.Time) error {
+	return b.rdb.Eval(ctx, breakerReleaseScript, []string{breakerKey(taskType)}, since.UnixMilli()).Err()
+}
+
+func (b *RedisBroker) AcquireSlot(ctx context.Context, taskType, taskID string, limit int, deadline time.Time) (bool, error) {
+	n, err := b.rdb.Eval(ctx, acquireSlotScript, []string{limitKey(taskType)},
+		time.Now().UnixMilli(), limit, taskID, deadline.UnixMilli()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (b *RedisBroker) ReleaseSlot(ctx context.Context, taskType, taskID string) error {
+	return b.rdb.ZRem(ctx, limitKey(taskType), taskID).Err()
+}
+
+func (b *RedisBroker) TakeToken(ctx context.Context, taskType string, rate float64, burst int) (bool, error) {
+	n, err := b.rdb.Eval(ctx, takeTokenScript, []string{rateKey(taskType)},
+		rate, burst, time.Now().UnixMilli()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (b *RedisBroker) SendCancel(ctx context.Context, nodeID, taskID string) error {
+	return b.rdb.LPush(ctx, b.controlKey+nodeID, taskID)
+}
+
+func (b *RedisBroker) ReceiveCancel(ctx context.Context, nodeID string, timeout time.Duration) (string, error) {
+	return b.pop(ctx, b.controlKey+nodeID, timeout)
+}
+
+func (b *RedisBroker) pop(ctx context.Context, key string, timeout time.Duration) (string, error) {
+	data, err := b.rdb.BRPop(ctx, timeout, key)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", errors_.ErrNoTask
+		}
+		return "", err
+	}
+
+	return data[1], nil
+}
+
+func queueKey(priority string) string {
+	if priority == "" {
+		priority = "default"
+	}
+
+	return fmt.Sprintf("scheduler:queue:%s", priority)
+}
+
+func waitKey(priority string) string {
+	return fmt.Sprintf("scheduler:wait:%s", priority)
+}
+
+func activeKey(workerID string) string {
+	return fmt.Sprintf("scheduler:active:worker_%s", workerID)
+}
+
+func breakerKey(taskType string) string {
+	return fmt.Sprintf("scheduler:breaker:%s", taskType)
+}
+
+func failedKey(taskType string) string {
+	return fmt.Sprintf("scheduler:failed:%s", taskType)
+}
+
+func limitKey(taskType string) string {
+	return fmt.Sprintf("scheduler:limit:%s", taskType)
+}
+
+func rateKey(taskType string) string {
+	return fmt.Sprintf("scheduler:rate:%s", taskType)
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, taskID string, event []byte) error {
+	return b.rdb.Publish(ctx, constant.TaskProgressChannel+taskID, event)
+}
+
+func (b *RedisBroker) StartWorkflow(ctx context.Context, workflowID string, total int, deps map[string]int, next map[string][]string) error {
+	args := make([]interface{}, 0, 2+2*len(deps)+2*len(next))
+	args = append(args, total, len(deps))
+	for taskID, n := range deps {
+		args = append(args, taskID, n)
+	}
+	for taskID, successors := range next {
+		args = append(args, taskID, strings.Join(successors, ","))
+	}
+
+	return b.rdb.Eval(ctx, startWorkflowScript, workflowKeys(workflowID), args...).Err()
+}
+
+func (b *RedisBroker) CompleteWorkflow(ctx context.Context, workflowID, taskID string) ([]string, bool, error) {
+	res, err := b.rdb.Eval(ctx, completeWorkflowScript, workflowKeys(workflowID), taskID, workflowExpiry.Milliseconds()).StringSlice()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return res[1:], res[0] == "1", nil
+}
+
+func (b *RedisBroker) FailWorkflow(ctx context.Context, workflowID string) ([]string, error) {
+	return b.rdb.Eval(ctx, failWorkflowScript, workflowKeys(workflowID), workflowExpiry.Milliseconds()).StringSlice()
+}
+
+func workflowKeys(workflowID string) []string {
+	key := "workflow:" + workflowID
+	return []string{key, key + ":deps", key + ":next", key + ":done"}
+}
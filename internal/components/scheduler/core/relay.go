@@ -0,0 +1,486 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 清理孤儿任务 返回它的去重 key 锁已经被别的节点拿到时返回 -1
+const dropScript = fenceGuard + `
+local priority = redis.call("HGET", KEYS[2], "priority")
+if priority then
+	redis.call("LREM", "scheduler:queue:" .. priority, 0, ARGV[2])
+end
+local unique = redis.call("HGET", KEYS[2], "unique_key") or ""
+redis.call("ZREM", KEYS[4], ARGV[2])
+redis.call("DEL", KEYS[2], KEYS[3])
+return unique
+`
+
+// Relay outbox 的投递进程 mysql 是任务的唯一来源
+// 1. 还没投递的记录推到 broker
+// 2. 标记已投递但 broker 里没有 meta 的记录 重新投递
+// 3. redis 里有 meta 但 mysql 没有记录的任务 清理掉(内存模式没有这种情况)
+// 4. 子任务都结束了但还没完成的批量任务 补一次完成检查(比如子任务在排队时被取消)
+// 5. 新提交的工作流初始化运行时状态并投递没有依赖的任务 运行中的工作流按成员的记录对账
+type Relay struct {
+	rdb          *infra.Redis
+	db           *infra.DB
+	broker       Broker
+	batch        *BatchTracker
+	workflow     *WorkflowTracker
+	lock         *DistributedLock
+	scanInterval time.Duration
+	batchSize    int
+	grace        time.Duration
+	delayKey     string
+	runningKey   string
+
+	// 两个方向的对账都是分批扫描 记录扫到哪了
+	rowCursor      string
+	keyCursor      uint64
+	batchCursor    string
+	workflowCursor string
+}
+
+func NewRelay(db *infra.DB, rdb *infra.Redis, broker Broker, conf *config.SchedulerConfig) *Relay {
+	return &Relay{
+		rdb:          rdb,
+		db:           db,
+		broker:       broker,
+		batch:        NewBatchTracker(db, broker),
+		workflow:     NewWorkflowTracker(db, broker),
+		lock:         NewDistributedLock(rdb, conf),
+		scanInterval: time.Duration(conf.Outbox.ScanInterval) * time.Millisecond,
+		batchSize:    conf.Outbox.BatchSize,
+		grace:        time.Duration(conf.Outbox.Grace) * time.Millisecond,
+		delayKey:     conf.Dispatcher.Queue,
+		runningKey:   conf.Timeout.RunningKey,
+	}
+}
+
+func (r *Relay) Start() {
+	log.Println("relay is running")
+	ticker := time.NewTicker(r.scanInterval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Scan(context.Background()); err != nil {
+				log.Println("relay err:", err)
+			}
+		}
+	}
+}
+
+func (r *Relay) Scan(ctx context.Context) error {
+	lease, err := r.lock.Lock("scheduler:relay")
+	if err != nil {
+		if errors.Is(err, errors_.ErrKeyExists) {
+			return nil
+		}
+		return err
+	}
+	defer lease.Unlock()
+
+	ctx = lease.Context()
+
+	if err := r.publish(ctx); err != nil {
+		return err
+	}
+
+	if err := r.publishWorkflows(ctx); err != nil {
+		return err
+	}
+
+	if err := r.reconcileRows(ctx); err != nil {
+		return err
+	}
+
+	if err := r.reconcileBatches(ctx); err != nil {
+		return err
+	}
+
+	if err := r.reconcileWorkflows(ctx); err != nil {
+		return err
+	}
+
+	if r.rdb == nil {
+		return nil
+	}
+
+	return r.reconcileKeys(ctx, lease.Fence())
+}
+
+// publish 投递事务提交后还没进 redis 的任务 工作流的成员要等依赖满足 由 publishWorkflows 和 reconcileWorkflows 投递
+func (r *Relay) publish(ctx context.Context) error {
+	var tasks []storage.Task
+	if err := r.db.Where("enqueued = ? and status = ? and workflow_id = '' and type <> ? and created_at < ?",
+		false, constant.TaskPending, constant.TaskWorkflow, time.Now().Add(-r.grace)).
+		Order("created_at").
+		Limit(r.batchSize).
+		Find(&tasks).Error; err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		if err := r.publishTask(ctx, &tasks[i]); err != nil {
+			return err
+		}
+		log.Printf("relay published task %s\n", tasks[i].ID)
+	}
+
+	return nil
+}
+
+// publishTask meta 已经存在说明之前投递成功了 只是没来得及标记
+func (r *Relay) publishTask(ctx context.Context, task *storage.Task) error {
+	meta, err := r.broker.Meta(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(meta) == 0 {
+		message, err := messageFromRecord(task)
+		if err != nil {
+			return err
+		}
+
+		if err := r.broker.Enqueue(ctx, message); err != nil {
+			return err
+		}
+	}
+
+	return r.db.Model(&storage.Task{}).Where("id = ?", task.ID).Update("enqueued", true).Error
+}
+
+// messageFromRecord 用任务记录重建投递用的消息
+func messageFromRecord(task *storage.Task) (*infra_.TaskMessage, error) {
+	message := &infra_.TaskMessage{
+		TaskID:     task.ID,
+		Type:       task.Type,
+		BizID:      task.BizID,
+		Priority:   task.Priority,
+		RetryCount: task.RetryCount,
+		Timeout:    task.Timeout,
+		UniqueKey:  task.UniqueKey,
+		WorkflowID: task.WorkflowID,
+		BatchID:    task.BatchID,
+	}
+	if message.Priority == "" {
+		message.Priority = "default"
+	}
+	if err := json.Unmarshal([]byte(task.Payload), &message.Payload); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// reconcileRows 待执行的记录在 broker 里已经没有了(比如 redis 丢数据) 重置为未投递 下一轮重新投递
+// 工作流的成员重置后由 reconcileWorkflows 投递 工作流和批量任务本身不需要投递
+func (r *Relay) reconcileRows(ctx context.Context) error {
+	var tasks []storage.Task
+	if err := r.db.Select("id").
+		Where("id > ? and enqueued = ? and status = ? and type not in ? and updated_at < ?",
+			r.rowCursor, true, constant.TaskPending, []string{constant.TaskWorkflow, constant.TaskBatch}, time.Now().Add(-r.grace)).
+		Order("id").
+		Limit(r.batchSize).
+		Find(&tasks).Error; err != nil {
+		return err
+	}
+
+	// 扫到头了 下一轮从头开始
+	if len(tasks) < r.batchSize {
+		r.rowCursor = ""
+	} else {
+		r.rowCursor = tasks[len(tasks)-1].ID
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	lost := make([]string, 0)
+	for _, task := range tasks {
+		meta, err := r.broker.Meta(ctx, task.ID)
+		if err != nil {
+			return err
+		}
+		if len(meta) == 0 {
+			lost = append(lost, task.ID)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+
+	// worker 和死信消费者都是先落库再删 meta 这里再按状态和更新时间过滤一次 查询之后刚结束的记录不会被重置
+	log.Printf("relay found %d tasks missing in redis\n", len(lost))
+	return r.db.Model(&storage.Task{}).
+		Where("id in ? and status = ? and enqueued = ? and updated_at < ?", lost, constant.TaskPending, true, time.Now().Add(-r.grace)).
+		Update("enqueued", false).Error
+}
+
+// reconcileBatches 待执行的批量任务逐个检查 子任务都结束了就完成它
+func (r *Relay) reconcileBatches(ctx context.Context) error {
+	var batches []string
+	if err := r.db.Model(&storage.Task{}).
+		Where("id > ? and type = ? and status = ? and updated_at < ?",
+			r.batchCursor, constant.TaskBatch, constant.TaskPending, time.Now().Add(-r.grace)).
+		Order("id").
+		Limit(r.batchSize).
+		Pluck("id", &batches).Error; err != nil {
+		return err
+	}
+
+	if len(batches) < r.batchSize {
+		r.batchCursor = ""
+	} else {
+		r.batchCursor = batches[len(batches)-1]
+	}
+
+	for _, batchID := range batches {
+		if err := r.batch.Check(ctx, batchID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishWorkflows 新提交的工作流 在 broker 里记录依赖关系后投递没有依赖的任务
+// StartWorkflow 是幂等的 中途失败下一轮重来 已经投递的任务 publishTask 不会重复投递
+func (r *Relay) publishWorkflows(ctx context.Context) error {
+	var workflows []storage.Task
+	if err := r.db.Where("type = ? and enqueued = ? and status = ? and created_at < ?",
+		constant.TaskWorkflow, false, constant.TaskPending, time.Now().Add(-r.grace)).
+		Order("created_at").
+		Limit(r.batchSize).
+		Find(&workflows).Error; err != nil {
+		return err
+	}
+
+	for i := range workflows {
+		if err := r.publishWorkflow(ctx, &workflows[i]); err != nil {
+			return err
+		}
+		log.Printf("relay published workflow %s\n", workflows[i].ID)
+	}
+
+	return nil
+}
+
+func (r *Relay) publishWorkflow(ctx context.Context, record *storage.Task) error {
+	var workflow infra_.Workflow
+	if err := json.Unmarshal([]byte(record.Payload), &workflow); err != nil {
+		return err
+	}
+
+	ids := make(map[string]string, len(workflow.Tasks))
+	for _, node := range workflow.Tasks {
+		ids[node.Name] = node.Message.TaskID
+	}
+
+	roots := make([]string, 0)
+	deps := make(map[string]int)
+	next := make(map[string][]string)
+	for _, node := range workflow.Tasks {
+		if len(node.DependsOn) == 0 {
+			roots = append(roots, node.Message.TaskID)
+			continue
+		}
+		deps[node.Message.TaskID] = len(node.DependsOn)
+		for _, dep := range node.DependsOn {
+			next[ids[dep]] = append(next[ids[dep]], node.Message.TaskID)
+		}
+	}
+
+	if err := r.broker.StartWorkflow(ctx, record.ID, len(workflow.Tasks), deps, next); err != nil {
+		return err
+	}
+
+	var tasks []storage.Task
+	if err := r.db.Where("id in ? and status = ? and enqueued = ?", roots, constant.TaskPending, false).Find(&tasks).Error; err != nil {
+		return err
+	}
+	for i := range tasks {
+		if err := r.publishTask(ctx, &tasks[i]); err != nil {
+			return err
+		}
+	}
+
+	return r.db.Model(&storage.Task{}).Where("id = ?", record.ID).Update("enqueued", true).Error
+}
+
+// reconcileWorkflows 运行中的工作流按成员的记录对账 兜底 worker / 死信消费者 / 取消没做完的推进
+// 1. 有成员失败或者被取消 整个工作流失败/取消
+// 2. 成员都成功了 工作流标记成功
+// 3. 依赖都已经成功但还没投递的成员(比如 redis 丢了工作流状态 或者成员被 reconcileRows 重置) 直接投递
+func (r *Relay) reconcileWorkflows(ctx context.Context) error {
+	var workflows []storage.Task
+	if err := r.db.Where("id > ? and type = ? and enqueued = ? and status = ? and updated_at < ?",
+		r.workflowCursor, constant.TaskWorkflow, true, constant.TaskPending, time.Now().Add(-r.grace)).
+		Order("id").
+		Limit(r.batchSize).
+		Find(&workflows).Error; err != nil {
+		return err
+	}
+
+	if len(workflows) < r.batchSize {
+		r.workflowCursor = ""
+	} else {
+		r.workflowCursor = workflows[len(workflows)-1].ID
+	}
+
+	for i := range workflows {
+		if err := r.reconcileWorkflow(ctx, &workflows[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Relay) reconcileWorkflow(ctx context.Context, record *storage.Task) error {
+	var members []storage.Task
+	if err := r.db.Where("workflow_id = ?", record.ID).Find(&members).Error; err != nil {
+		return err
+	}
+
+	byID := make(map[string]*storage.Task, len(members))
+	succeeded := 0
+	for i := range members {
+		member := &members[i]
+		byID[member.ID] = member
+
+		switch member.Status {
+		case constant.TaskSuccess:
+			succeeded++
+		case constant.TaskFailed:
+			return r.workflow.Fail(ctx, record.ID, member.ID, member.ErrorMsg)
+		case constant.TaskCancelled:
+			return r.workflow.Cancel(ctx, record.ID, member.ID)
+		}
+	}
+
+	if succeeded == len(members) {
+		return r.workflow.Finish(ctx, record.ID)
+	}
+
+	var workflow infra_.Workflow
+	if err := json.Unmarshal([]byte(record.Payload), &workflow); err != nil {
+		return err
+	}
+
+	ids := make(map[string]string, len(workflow.Tasks))
+	for _, node := range workflow.Tasks {
+		ids[node.Name] = node.Message.TaskID
+	}
+
+	// 依赖刚成功的成员 worker 正在投递 等过了 grace 再补
+	deadline := time.Now().Add(-r.grace)
+	for _, node := range workflow.Tasks {
+		member, ok := byID[node.Message.TaskID]
+		if !ok || member.Status != constant.TaskPending || member.Enqueued {
+			continue
+		}
+
+		ready := true
+		for _, dep := range node.DependsOn {
+			task := byID[ids[dep]]
+			if task == nil || task.Status != constant.TaskSuccess || task.UpdatedAt.After(deadline) {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		if err := r.publishTask(ctx, member); err != nil {
+			return err
+		}
+		log.Printf("relay published workflow %s task %s\n", record.ID, member.ID)
+	}
+
+	return nil
+}
+
+// reconcileKeys redis 里有但 mysql 里没有记录的任务 永远不会被记录结果 直接清理
+// 删除任务数据时比较 fencing token 锁被别的节点拿到后旧的持有者不会再删
+// mysql 上的写操作都按状态和投递标记做了条件更新 重复执行没有副作用
+func (r *Relay) reconcileKeys(ctx context.Context, fence Fence) error {
+	keys, cursor, err := r.rdb.Scan(ctx, r.keyCursor, "task:meta:*", int64(r.batchSize)).Result()
+	if err != nil {
+		return err
+	}
+	r.keyCursor = cursor
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, "task:meta:"))
+	}
+
+	var existed []string
+	if err := r.db.Model(&storage.Task{}).Where("id in ?", ids).Pluck("id", &existed).Error; err != nil {
+		return err
+	}
+
+	found := make(map[string]struct{}, len(existed))
+	for _, id := range existed {
+		found[id] = struct{}{}
+	}
+
+	for _, taskID := range ids {
+		if _, ok := found[taskID]; ok {
+			continue
+		}
+
+		// 正在执行的交给 worker 和 janitor
+		if _, err := r.rdb.ZScore(ctx, r.runningKey, taskID); err == nil {
+			continue
+		} else if !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		if err := r.drop(ctx, fence, taskID); err != nil {
+			return err
+		}
+		log.Printf("relay dropped orphan task %s\n", taskID)
+	}
+
+	return nil
+}
+
+func (r *Relay) drop(ctx context.Context, fence Fence, taskID string) error {
+	keys := []string{fence.Key, "task:meta:" + taskID, "task:payload:" + taskID, r.delayKey}
+	res, err := r.rdb.Eval(ctx, dropScript, keys, fence.Token, taskID).Result()
+	if err != nil {
+		return err
+	}
+
+	// fenceGuard 失败返回整数 -1 成功返回去重 key
+	switch v := res.(type) {
+	case int64:
+		return errors_.ErrLockLost
+	case string:
+		return releaseUnique(ctx, r.rdb, v, taskID)
+	default:
+		return fmt.Errorf("unexpected drop result %v", res)
+	}
+}
@@ -2,10 +2,8 @@ package core
 
 import (
 	"context"
-	"github.com/go-redis/redis/v8"
 	"log"
 	"math/rand"
-	"stream_hub/internal/infra"
 	"stream_hub/pkg/model/config"
 	infra_ "stream_hub/pkg/model/infra"
 	"time"
@@ -16,12 +14,12 @@ type Retry struct {
 	BaseDelay    time.Duration
 	MaxDelay     time.Duration
 	EnableJitter bool
-	rdb          *infra.Redis
+	broker       Broker
 }
 
-func NewRetry(rdb *infra.Redis, conf *config.SchedulerConfig) *Retry {
+func NewRetry(broker Broker, conf *config.SchedulerConfig) *Retry {
 	return &Retry{
-		rdb:          rdb,
+		broker:       broker,
 		MaxRetry:     conf.Retry.MaxRetries,
 		BaseDelay:    time.Duration(conf.Retry.BaseDelayMs) * time.Millisecond,
 		MaxDelay:     time.Duration(conf.Retry.MaxDelayMs) * time.Millisecond,
@@ -33,20 +31,14 @@ func (r *Retry) retry(task *infra_.TaskMessage, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	pipeline := r.rdb.Pipeline()
-	countCmd := pipeline.HIncrBy(ctx, "task:meta:"+task.TaskID, "retry_count", 1)
-	pipeline.HSet(ctx, "task:meta:"+task.TaskID, "error_msg", err.Error())
-
-	_, err = pipeline.Exec(ctx)
+	count, err := r.broker.Nack(ctx, task.TaskID, err.Error())
 	if err != nil {
 		log.Println("err:", err)
 		return
 	}
 
-	count, _ := countCmd.Result()
-
 	if count >= r.MaxRetry {
-		if err := r.sendToDlq(task.TaskID); err != nil {
+		if err := r.broker.DeadLetter(ctx, task.TaskID); err != nil {
 			log.Println("err:", err)
 			return
 		}
@@ -54,7 +46,40 @@ func (r *Retry) retry(task *infra_.TaskMessage, err error) {
 		return
 	}
 
-	// 指数退避
+	if err := r.broker.Delay(ctx, task.TaskID, time.Now().Add(r.backoff(count))); err != nil {
+		log.Println("err:", err)
+		return
+	}
+	incCounter(tasksRetried, 1, task.Type)
+}
+
+// deadLetter 重试也不会成功的任务(比如没有 handler) 记录原因后直接进入死信队列
+func (r *Retry) deadLetter(task *infra_.TaskMessage, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	if _, err := r.broker.Nack(ctx, task.TaskID, err.Error()); err != nil {
+		log.Println("err:", err)
+		return
+	}
+
+	if err := r.broker.DeadLetter(ctx, task.TaskID); err != nil {
+		log.Println("err:", err)
+	}
+}
+
+// postpone 任务还没准备好(比如等转码完成) 按最大退避时间延后 不计入重试次数
+func (r *Retry) postpone(task *infra_.TaskMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	if err := r.broker.Delay(ctx, task.TaskID, time.Now().Add(r.MaxDelay)); err != nil {
+		log.Println("err:", err)
+	}
+}
+
+// backoff 指数退避
+func (r *Retry) backoff(count int64) time.Duration {
 	delay := time.Duration(1<<count) * r.BaseDelay
 
 	if delay > r.MaxDelay {
@@ -65,16 +90,5 @@ func (r *Retry) retry(task *infra_.TaskMessage, err error) {
 		delay = time.Duration(rand.Int63n(int64(delay)))
 	}
 
-	nextRunTime := time.Now().Add(delay)
-	if err := r.rdb.ZAdd(ctx, "task:delay", &redis.Z{
-		Score:  float64(nextRunTime.Unix()),
-		Member: task.TaskID,
-	}); err != nil {
-		log.Println("err:", err)
-		return
-	}
-}
-
-func (r *Retry) sendToDlq(taskID string) error {
-	return r.rdb.LPush(context.Background(), "scheduler:dlq", taskID)
+	return delay
 }
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"testing"
+	"time"
+)
+
+// testConfig 测试用的配置 只填内存模式下用得到的部分
+func testConfig() *config.SchedulerConfig {
+	return &config.SchedulerConfig{
+		Broker: "memory",
+		Health: config.HealthConfig{
+			Threshold:         2,
+			Duration:          60000,
+			BlacklistDuration: 3600000,
+			Delay:             10000,
+			Probes:            1,
+			SuccessThreshold:  2,
+			ProbeDelay:        5000,
+		},
+		Retry: config.RetryConfig{
+			MaxRetries:  3,
+			BaseDelayMs: 1000,
+			MaxDelayMs:  5000,
+		},
+		Timeout: config.TimeoutConfig{
+			Default: 60000,
+		},
+	}
+}
+
+func enqueueTest(t *testing.T, broker *MemoryBroker, taskID, taskType string) *infra_.TaskMessage {
+	t.Helper()
+
+	task := &infra_.TaskMessage{TaskID: taskID, Type: taskType, Priority: "default"}
+	if err := broker.Enqueue(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+
+	return task
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := NewRetry(NewMemoryBroker(), testConfig())
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range want {
+		if got := r.backoff(int64(i + 1)); got != delay {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+
+	r.EnableJitter = true
+	for count := int64(1); count <= 4; count++ {
+		if got := r.backoff(count); got < 0 || got >= want[count-1] {
+			t.Errorf("jittered backoff(%d) = %v, want [0, %v)", count, got, want[count-1])
+		}
+	}
+}
+
+func TestRetryDelaysUntilMaxRetries(t *testing.T) {
+	broker := NewMemoryBroker()
+	r := NewRetry(broker, testConfig())
+	task := enqueueTest(t, broker, "t1", "email")
+
+	for i := 1; i < 3; i++ {
+		before := time.Now()
+		r.retry(task, errors.New("boom"))
+
+		at, ok := broker.delay[task.TaskID]
+		if !ok {
+			t.Fatalf("retry %d: task is not delayed", i)
+		}
+		// Delay 按秒截断
+		if min := before.Add(r.backoff(int64(i))).Truncate(time.Second); at.Before(min) {
+			t.Errorf("retry %d: delayed to %v, want at least %v", i, at, min)
+		}
+		delete(broker.delay, task.TaskID)
+	}
+
+	meta, _ := broker.Meta(context.Background(), task.TaskID)
+	if meta["retry_count"] != "2" || meta["error_msg"] != "boom" {
+		t.Errorf("meta = %v", meta)
+	}
+
+	// 第三次失败达到上限 进入死信队列 不再延时
+	r.retry(task, errors.New("boom"))
+	if _, ok := broker.delay[task.TaskID]; ok {
+		t.Error("task should not be delayed after max retries")
+	}
+	if items := broker.list("scheduler:dlq").items; len(items) != 1 || items[0] != task.TaskID {
+		t.Errorf("dlq = %v", items)
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	broker := NewMemoryBroker()
+	r := NewRetry(broker, testConfig())
+	task := enqueueTest(t, broker, "t1", "unknown")
+
+	r.deadLetter(task, errors.New("no handler"))
+
+	if items := broker.list("scheduler:dlq").items; len(items) != 1 || items[0] != task.TaskID {
+		t.Errorf("dlq = %v", items)
+	}
+	if meta, _ := broker.Meta(context.Background(), task.TaskID); meta["error_msg"] != "no handler" {
+		t.Errorf("meta = %v", meta)
+	}
+}
+
+func TestRetryPostpone(t *testing.T) {
+	broker := NewMemoryBroker()
+	r := NewRetry(broker, testConfig())
+	task := enqueueTest(t, broker, "t1", "video_audit")
+
+	before := time.Now()
+	r.postpone(task)
+
+	at, ok := broker.delay[task.TaskID]
+	if !ok {
+		t.Fatal("task is not delayed")
+	}
+	if min := before.Add(r.MaxDelay).Truncate(time.Second); at.Before(min) {
+		t.Errorf("delayed to %v, want at least %v", at, min)
+	}
+
+	// 不计入重试次数
+	if meta, _ := broker.Meta(context.Background(), task.TaskID); meta["retry_count"] != "0" {
+		t.Errorf("meta = %v", meta)
+	}
+}
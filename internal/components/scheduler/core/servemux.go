@@ -2,31 +2,56 @@ package core
 
 import (
 	"context"
+	errors_ "stream_hub/pkg/errors"
 	infra_ "stream_hub/pkg/model/infra"
 	"sync"
 )
 
+type HandlerFunc func(context.Context, *infra_.TaskMessage) error
+
+// Middleware 包装 handler 和 gin 的中间件一样 先 Use 的在最外层
+type Middleware func(HandlerFunc) HandlerFunc
+
 type ServeMux struct {
-	mu  sync.RWMutex
-	mux map[string]func(context.Context, *infra_.TaskMessage) error
+	mu          sync.RWMutex
+	mux         map[string]HandlerFunc
+	middlewares []Middleware
 }
 
 func NewServeMux() *ServeMux {
 	return &ServeMux{
-		mux: make(map[string]func(context.Context, *infra_.TaskMessage) error),
+		mux: make(map[string]HandlerFunc),
 	}
 }
 
-func (s *ServeMux) HandleFunc(pattern string, handler func(context.Context, *infra_.TaskMessage) error) {
+func (s *ServeMux) Use(middlewares ...Middleware) {
+	s.mu.Lock()
+	s.middlewares = append(s.middlewares, middlewares...)
+	s.mu.Unlock()
+}
+
+func (s *ServeMux) HandleFunc(pattern string, handler HandlerFunc) {
 	s.mu.Lock()
 	s.mux[pattern] = handler
 
 	s.mu.Unlock()
 }
 
+// Execute 没有注册的类型返回 TaskNoHandler 同样会经过中间件
 func (s *ServeMux) Execute(ctx context.Context, pattern string, task *infra_.TaskMessage) error {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	handler := s.mux[pattern]
+	handler, ok := s.mux[pattern]
+	if !ok {
+		handler = noHandler
+	}
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+	s.mu.RUnlock()
+
 	return handler(ctx, task)
 }
+
+func noHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	return errors_.TaskNoHandler
+}
@@ -15,6 +15,7 @@ type Server struct {
 	mu                sync.Mutex
 	id                string
 	rdb               *infra.Redis
+	broker            Broker
 	workerNum         int
 	workerDeathChan   chan string
 	registerKey       string
@@ -23,12 +24,20 @@ type Server struct {
 	heartbeatTicker   *time.Ticker
 	heartbeatInterval time.Duration
 	heartbeatExpiry   time.Duration
+	control           *Control
+	quit              chan struct{}
 }
 
-func NewServer(db *infra.DB, rdb *infra.Redis, conf *config.SchedulerConfig) *Server {
+// 排空期限过后取消 handler 的 context 再等这么久让它们收尾
+const cancelGrace = time.Second * 5
+
+// NewServer rdb 为空(内存模式)时不注册节点也不发心跳 worker 死掉直接在本地回收任务
+func NewServer(db *infra.DB, rdb *infra.Redis, broker Broker, logger *infra.Logger, conf *config.SchedulerConfig) *Server {
 	server := new(Server)
 	server.id = utils.CreateID()
 	server.rdb = rdb
+	server.broker = broker
+	server.quit = make(chan struct{})
 	server.workerNum = conf.WorkerNum
 	server.workerPool = make(map[string]*Worker)
 	server.heartbeatExpiry = time.Duration(conf.HeartbeatExpiry) * time.Millisecond
@@ -37,9 +46,12 @@ func NewServer(db *infra.DB, rdb *infra.Redis, conf *config.SchedulerConfig) *Se
 	server.deathKey = conf.DeathKey
 	workerDeathChan := make(chan string, 10)
 	server.workerDeathChan = workerDeathChan
+	server.control = NewControl(server.id, broker)
+	breaker := NewCircuitBreaker(broker, logger, server.id, conf)
+	limiter := NewLimiter(broker, conf)
 	for i := 0; i < server.workerNum; i++ {
 		workerID := utils.CreateUUID()
-		worker := NewWorker(workerID, db, rdb, conf, workerDeathChan)
+		worker := NewWorker(workerID, db, broker, conf, breaker, limiter, workerDeathChan, server.control)
 		server.workerPool[workerID] = worker  
 	}
 
@@ -51,17 +63,26 @@ func (s *Server) Start() error {
 		worker.Start()
 	}
 
-	if err := s.RegisterWorker(); err != nil {
-		return err
-	}
-
-	// 得先发送一次心跳 这个bug就是janitor会先扫描到这个node注册了 但是没有心跳
-	s.SendHeartbeat()
+	go s.control.Listen()
 
 	s.heartbeatTicker = time.NewTicker(s.heartbeatInterval)
+	if s.rdb != nil {
+		if err := s.RegisterWorker(); err != nil {
+			return err
+		}
+
+		// 得先发送一次心跳 这个bug就是janitor会先扫描到这个node注册了 但是没有心跳
+		s.SendHeartbeat()
+	} else {
+		s.heartbeatTicker.Stop()
+	}
 
 	for {
 		select {
+		case <-s.quit:
+			s.heartbeatTicker.Stop()
+			return nil
+
 		case <-s.heartbeatTicker.C:
 			go func() {
 				if err := s.SendHeartbeat(); err != nil {
@@ -80,6 +101,69 @@ func (s *Server) Start() error {
 	}
 }
 
+// Shutdown 优雅退出 ctx 的截止时间就是排空期限
+// 1. 停止拉取新任务
+// 2. active 队列里还没开始执行的任务放回优先级队列
+// 3. 等待执行中的任务 超过期限取消它们的 context
+// 4. 从 register_key 注销节点 Start 返回
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Printf("node %s is shutting down\n", s.id)
+
+	s.mu.Lock()
+	workers := make([]*Worker, 0, len(s.workerPool))
+	for _, worker := range s.workerPool {
+		workers = append(workers, worker)
+	}
+	s.mu.Unlock()
+
+	// 先全部通知 再逐个等待
+	for _, worker := range workers {
+		worker.Stop()
+	}
+	for _, worker := range workers {
+		worker.Wait()
+		if err := s.broker.Requeue(context.Background(), worker.id); err != nil {
+			log.Println("requeue err:", err)
+		}
+	}
+
+	if err := s.drain(ctx, workers); err != nil {
+		log.Printf("drain deadline exceeded, cancel running tasks\n")
+		s.control.CancelAll()
+
+		graceCtx, cancel := context.WithTimeout(context.Background(), cancelGrace)
+		defer cancel()
+		if err := s.drain(graceCtx, workers); err != nil {
+			log.Println("some tasks are still running, leave them to janitor")
+		}
+	}
+
+	err := s.Deregister()
+	close(s.quit)
+	log.Printf("node %s is stopped\n", s.id)
+
+	return err
+}
+
+func (s *Server) drain(ctx context.Context, workers []*Worker) error {
+	for _, worker := range workers {
+		if err := worker.Drain(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deregister 删除节点的注册信息和心跳 janitor 不会再把它当作失联节点处理
+func (s *Server) Deregister() error {
+	if s.rdb == nil {
+		return nil
+	}
+
+	return s.rdb.Del(context.Background(), s.registerKey+s.id, "scheduler:heartbeat:"+s.id)
+}
+
 func (s *Server) RegisterWorker() error {
 	s.mu.Lock()
 	workers := make(map[string]string)
@@ -101,6 +185,10 @@ func (s *Server) SendHeartbeat() error {
 }
 
 func (s *Server) SendDeath(workerID string) error {
+	if s.rdb == nil {
+		return s.broker.Requeue(context.Background(), workerID)
+	}
+
 	key := fmt.Sprintf("%s:%s", s.id, workerID)
 	return s.rdb.LPush(context.Background(), s.deathKey, key)
 }
@@ -0,0 +1,15 @@
+package core
+
+import (
+	"context"
+	"stream_hub/internal/infra"
+)
+
+// releaseUnique 释放 TaskSender.SendTask 加的去重锁 值是任务ID 只删除自己的
+func releaseUnique(ctx context.Context, rdb *infra.Redis, uniqueKey, taskID string) error {
+	if uniqueKey == "" {
+		return nil
+	}
+
+	return rdb.Eval(ctx, releaseScript, []string{uniqueKey}, taskID).Err()
+}
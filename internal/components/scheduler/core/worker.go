@@ -2,124 +2,178 @@ package core
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"stream_hub/internal/infra"
 	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/model/config"
 	infra_ "stream_hub/pkg/model/infra"
 	"stream_hub/pkg/model/storage"
+	"sync"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 type Worker struct {
 	id              string
 	picker          *Picker       // 队列获取决策
 	concurrencyChan chan struct{} // 最大并发数
-	activeQueue     string        // 自己的私有队列: schedule:active:worker_{id}
 	db              *infra.DB
-	rdb             *infra.Redis
-	deadLetter      string
+	broker          Broker
 	serveMux        *ServeMux
 	retry           *Retry
-	taskHealth      *TaskHealth
+	breaker         *CircuitBreaker
+	limiter         *Limiter
 	deathChan       chan string
+	control         *Control
+	defaultTimeout  time.Duration
+	timeouts        map[string]time.Duration
+	workflow        *WorkflowTracker
+	batch           *BatchTracker
+	progress        *Progress
+
+	// 优雅退出 quit 关闭后不再拉取新任务
+	quit     chan struct{}
+	stopOnce sync.Once
+	loops    sync.WaitGroup // fetch 和接收循环
+	running  sync.WaitGroup // 执行中的任务
 }
 
-func NewWorker(id string, db *infra.DB, rdb *infra.Redis, conf *config.SchedulerConfig, deathChan chan string) *Worker {
+func NewWorker(id string, db *infra.DB, broker Broker, conf *config.SchedulerConfig, breaker *CircuitBreaker, limiter *Limiter, deathChan chan string, control *Control) *Worker {
 	picker := NewQueuePicker(conf.Queue)
 	concurrencyChan := make(chan struct{}, conf.Concurrency)
+	timeouts := make(map[string]time.Duration, len(conf.Timeout.Types))
+	for taskType, timeout := range conf.Timeout.Types {
+		timeouts[taskType] = time.Duration(timeout) * time.Millisecond
+	}
 	return &Worker{
 		id:              id,
-		rdb:             rdb,
+		broker:          broker,
 		concurrencyChan: concurrencyChan,
 		picker:          picker,
-		activeQueue:     fmt.Sprintf("scheduler:active:worker_%s", id),
-		retry:           NewRetry(rdb, conf),
+		retry:           NewRetry(broker, conf),
 		db:              db,
-		taskHealth:      NewTaskHealth(rdb, conf),
+		breaker:         breaker,
+		limiter:         limiter,
 		deathChan:       deathChan,
+		control:         control,
+		defaultTimeout:  time.Duration(conf.Timeout.Default) * time.Millisecond,
+		timeouts:        timeouts,
+		workflow:        NewWorkflowTracker(db, broker),
+		batch:           NewBatchTracker(db, broker),
+		progress:        NewProgress(db, broker),
+		quit:            make(chan struct{}),
 	}
 }
 
 func (w *Worker) Start() {
+	w.loops.Add(2)
 	go func() {
 		log.Printf("worker %s is running\n", w.id)
 
-		defer w.sendDeathSignal()
+		defer w.loops.Done()
+		defer func() {
+			if !w.stopping() {
+				w.sendDeathSignal()
+			}
+		}()
 
 		go w.fetch()
 
 		for {
-			taskID, err := w.rdb.BRPop(context.Background(), time.Second*5, w.activeQueue)
+			if w.stopping() {
+				return
+			}
+
+			taskID, err := w.broker.Receive(context.Background(), w.id, time.Second*5)
 			if err != nil {
-				if !errors.Is(err, redis.Nil) {
+				if !errors.Is(err, errors_.ErrNoTask) {
 					log.Println("err:", err)
 				}
 				continue
 			}
 
-			log.Printf("worker %s is handling the task %s\n", w.id, taskID[1])
+			log.Printf("worker %s is handling the task %s\n", w.id, taskID)
 
-			ctx := context.Background()
-			pipeline := w.rdb.Pipeline()
-			metaCmd := pipeline.HGetAll(ctx, "task:meta:"+taskID[1])
-			dataCmd := pipeline.Get(ctx, "task:payload:"+taskID[1])
-
-			_, err = pipeline.Exec(ctx)
+			task, err := w.broker.Load(context.Background(), taskID)
 			if err != nil {
 				log.Println("err:", err)
+				<-w.concurrencyChan
 				continue
 			}
 
-			meta, _ := metaCmd.Result()
-			data, _ := dataCmd.Bytes()
-
-			fmt.Println("meta:", meta)
-			fmt.Println("payload:", string(data))
-
-			var payload infra_.TaskPayload
-			if err := json.Unmarshal(data, &payload); err != nil {
-				log.Println("err:", err)
+			if !w.limiter.Acquire(task, time.Now().Add(w.timeout(task))) {
+				<-w.concurrencyChan
 				continue
 			}
 
-			task := new(infra_.TaskMessage)
-			task.TransformByMap(meta)
-			task.Payload = payload
-
-			if w.taskHealth.Check(task) {
-				w.taskHealth.HandleBlackList(task)
+			if !w.breaker.Allow(task) {
+				w.limiter.Release(task)
+				<-w.concurrencyChan
 				continue
 			}
 
-			w.execute(task)
+			w.running.Add(1)
+			go w.execute(task)
 		}
 	}()
 }
 
+// fetch 先占一个并发名额再拉取 active 队列里的任务都有名额 退出时不会卡在名额上
 func (w *Worker) fetch() {
+	defer w.loops.Done()
+
 	for {
-		queue := w.picker.NextQueue()
-		log.Printf("worker %s is fetching, the queue is %s\n", w.id, queue)
-		taskID, err := w.rdb.BRPop(context.Background(), 5*time.Second, queue)
-		if err != nil {
-			if !errors.Is(err, redis.Nil) {
-				log.Println("BRPop err:", err)
-			}
-			continue
+		select {
+		case w.concurrencyChan <- struct{}{}:
+		case <-w.quit:
+			return
 		}
 
-		if err := w.rdb.LPush(context.Background(), w.activeQueue, taskID[1]); err != nil {
-			log.Println("err:", err)
+		queues := w.picker.NextQueues()
+		log.Printf("worker %s is fetching, the queues are %v\n", w.id, queues)
+		if _, err := w.broker.Dequeue(context.Background(), queues, w.id, 5*time.Second); err != nil {
+			if !errors.Is(err, errors_.ErrNoTask) {
+				log.Println("Dequeue err:", err)
+			}
+			<-w.concurrencyChan
 			continue
 		}
+	}
+}
+
+// Stop 停止拉取和接收新任务 执行中的任务不受影响
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() { close(w.quit) })
+}
+
+// Wait 等待拉取和接收循环退出 最多阻塞一个 BRPop 周期
+func (w *Worker) Wait() {
+	w.loops.Wait()
+}
 
-		w.concurrencyChan <- struct{}{}
+// Drain 等待执行中的任务结束 ctx 结束时返回 ctx.Err()
+func (w *Worker) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Worker) stopping() bool {
+	select {
+	case <-w.quit:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -128,32 +182,160 @@ func (w *Worker) RegisterMux(mux *ServeMux) {
 }
 
 func (w *Worker) execute(task *infra_.TaskMessage) {
-	if err := w.serveMux.Execute(context.Background(), task.Type, task); err != nil {
+	defer w.running.Done()
+	defer func() { <-w.concurrencyChan }()
+	defer w.limiter.Release(task)
+	defer w.breaker.Release(task)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := w.markRunning(task); err != nil {
+		// 取走之后还没开始执行就被取消了 meta 已经删掉 直接丢弃
+		if errors.Is(err, errors_.TaskNotFound) {
+			log.Printf("task %s is cancelled before running, drop it\n", task.TaskID)
+			return
+		}
+		log.Println("err:", err)
 		w.retryTask(task, err)
 		return
 	}
 
-	if err := w.rdb.Del(context.Background(), "task:meta:"+task.TaskID, "task:payload:"+task.TaskID); err != nil {
-		log.Println("err:", err)
+	incCounter(tasksStarted, 1, task.Type)
+	start := time.Now()
+
+	w.control.Register(task.TaskID, cancel)
+	err := w.serveMux.Execute(w.progress.withTask(ctx, task.TaskID), task.Type, task)
+	w.control.Unregister(task.TaskID)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	observeDuration(taskDuration, start, task.Type, result)
+
+	// 谁从 running 里删掉了任务谁负责收尾 删不掉说明 janitor 已经判定超时并重新投递了
+	owned, rErr := w.broker.Release(context.Background(), task.TaskID, Fence{})
+	if rErr != nil {
+		log.Println("err:", rErr)
+	}
+	if rErr == nil && !owned {
+		log.Printf("task %s has been reclaimed by janitor, drop the result\n", task.TaskID)
+		return
+	}
+
+	// 节点退出时超过排空期限被取消的任务不算失败 直接放回延时队列
+	if err != nil && ctx.Err() != nil && w.stopping() {
+		log.Printf("task %s is cancelled by shutdown\n", task.TaskID)
+		if err := w.broker.Delay(context.Background(), task.TaskID, time.Now()); err != nil {
+			log.Println("err:", err)
+		}
 		return
 	}
 
-	if err := w.db.Model(&storage.Task{}).Where("id = ?", task.TaskID).Updates(map[string]interface{}{
+	// 被 TaskSender.Cancel 取消 记录已经标记过了 只清理任务数据
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		log.Printf("task %s is cancelled\n", task.TaskID)
+		if err := w.broker.Ack(context.Background(), task); err != nil {
+			log.Println("err:", err)
+		}
+		w.checkBatch(task)
+		return
+	}
+
+	if errors.Is(err, errors_.TaskNoHandler) {
+		log.Printf("no handler for task type %s, send %s to dead letter\n", task.Type, task.TaskID)
+		w.retry.deadLetter(task, err)
+		return
+	}
+
+	// 依赖的数据还没准备好 不是执行失败
+	if errors.Is(err, errors_.TaskNotReady) {
+		log.Printf("task %s is not ready, postpone it: %v\n", task.TaskID, err)
+		w.retry.postpone(task)
+		return
+	}
+
+	if err != nil {
+		w.retryTask(task, err)
+		return
+	}
+
+	w.breaker.Success(task)
+	incCounter(tasksSucceeded, 1, task.Type)
+
+	// 先落库再删 meta 否则 relay 会把这段时间里还是待执行、meta 又没了的记录当成丢失重新投递
+	// 执行期间被取消的任务保持已取消
+	res := w.db.Model(&storage.Task{}).Where("id = ? and status = ?", task.TaskID, constant.TaskPending).Updates(map[string]interface{}{
 		"status":      constant.TaskSuccess,
 		"retry_count": task.RetryCount,
-	}).Error; err != nil {
-		log.Println("db.Updates err:", err)
+		"progress":    100,
+	})
+	if res.Error != nil {
+		log.Println("db.Updates err:", res.Error)
+	}
+
+	if err := w.broker.Ack(context.Background(), task); err != nil {
+		log.Println("err:", err)
 		return
 	}
+	// 落库失败的记录还是待执行 由 relay 重新投递 不推进工作流
+	if res.Error != nil {
+		return
+	}
+	// 没有更新到记录 说明执行期间被取消了 handler 没有响应取消正常返回 结果作废
+	if res.RowsAffected == 0 {
+		log.Printf("task %s is cancelled while running, drop the result\n", task.TaskID)
+		return
+	}
+	w.progress.finish(context.Background(), task.TaskID, constant.TaskSuccess, "")
+
+	// 工作流中的任务 成功后投递后继任务
+	if task.WorkflowID != "" {
+		if err := w.workflow.Complete(context.Background(), task); err != nil {
+			log.Println("workflow err:", err)
+		}
+	}
 
-	<-w.concurrencyChan
+	w.checkBatch(task)
+}
+
+// checkBatch 批量任务的子任务结束后 检查父任务是否完成
+func (w *Worker) checkBatch(task *infra_.TaskMessage) {
+	if task.BatchID == "" {
+		return
+	}
+
+	if err := w.batch.Check(context.Background(), task.BatchID); err != nil {
+		log.Println("batch err:", err)
+	}
+}
+
+// markRunning 把任务放进执行中集合 janitor 会回收超过截止时间的任务
+func (w *Worker) markRunning(task *infra_.TaskMessage) error {
+	deadline := time.Now().Add(w.timeout(task))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return w.broker.MarkRunning(ctx, task.TaskID, w.control.nodeID, w.id, deadline)
+}
+
+func (w *Worker) timeout(task *infra_.TaskMessage) time.Duration {
+	if task.Timeout > 0 {
+		return time.Duration(task.Timeout) * time.Millisecond
+	}
+
+	if timeout, ok := w.timeouts[task.Type]; ok {
+		return timeout
+	}
+
+	return w.defaultTimeout
 }
 
 func (w *Worker) retryTask(task *infra_.TaskMessage, err error) {
 	w.retry.retry(task, err)
-	if err := w.taskHealth.HandleError(task); err != nil {
-		log.Println("task health err:", err)
-	}
+	w.breaker.Failure(task)
 }
 
 func (w *Worker) sendDeathSignal() {
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"stream_hub/internal/infra"
+	infra_ "stream_hub/pkg/model/infra"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// testWorker 内存 broker 加 DryRun 的 db 更新语句不会执行 影响行数总是 0
+// 相当于记录已经被 TaskSender.Cancel 标记成取消
+func testWorker(t *testing.T, broker *MemoryBroker) *Worker {
+	t.Helper()
+
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testConfig()
+	w := NewWorker("w1", &infra.DB{DB: db}, broker, conf, NewCircuitBreaker(broker, nil, "node", conf), NewLimiter(broker, conf), make(chan string, 1), NewControl("node", broker))
+	w.concurrencyChan = make(chan struct{}, 1)
+	w.RegisterMux(NewServeMux())
+
+	return w
+}
+
+func TestWorkerCancelledWhileRunning(t *testing.T) {
+	broker := NewMemoryBroker()
+	w := testWorker(t, broker)
+
+	task := &infra_.TaskMessage{TaskID: "t1", Type: "transcode", Priority: "default", WorkflowID: "wf1"}
+	if err := broker.Enqueue(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.Dequeue(context.Background(), []string{"default"}, w.id, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.StartWorkflow(context.Background(), "wf1", 2, map[string]int{"t2": 1}, map[string][]string{"t1": {"t2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 执行期间被取消 handler 没有理会 ctx 正常返回
+	w.serveMux.HandleFunc("transcode", func(ctx context.Context, task *infra_.TaskMessage) error {
+		w.control.Cancel(task.TaskID)
+		return nil
+	})
+
+	w.concurrencyChan <- struct{}{}
+	w.running.Add(1)
+	w.execute(task)
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if _, ok := broker.metas[task.TaskID]; ok {
+		t.Error("meta should be acked")
+	}
+	if _, ok := broker.running[task.TaskID]; ok {
+		t.Error("task should be released")
+	}
+	// 结果作废 工作流不推进 后继任务不投递
+	workflow, ok := broker.workflows["wf1"]
+	if !ok {
+		t.Fatal("workflow should still be running")
+	}
+	if _, ok := workflow.done[task.TaskID]; ok {
+		t.Error("cancelled task should not complete the workflow")
+	}
+	if workflow.deps["t2"] != 1 {
+		t.Errorf("deps of t2 = %d, want 1", workflow.deps["t2"])
+	}
+	if len(broker.list(queueKey("default")).items) != 0 {
+		t.Error("successor should not be enqueued")
+	}
+}
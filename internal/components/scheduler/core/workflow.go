@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"stream_hub/internal/infra"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+)
+
+// WorkflowTracker 工作流的推进 运行时状态(依赖计数/后继/已完成的成员)保存在 broker 里
+// 后继任务依赖全部满足后按 mysql 里的记录投递
+type WorkflowTracker struct {
+	db     *infra.DB
+	broker Broker
+}
+
+func NewWorkflowTracker(db *infra.DB, broker Broker) *WorkflowTracker {
+	return &WorkflowTracker{
+		db:     db,
+		broker: broker,
+	}
+}
+
+// Complete 任务成功后调用 依赖全部满足的后继任务会被投递到对应的优先级队列
+func (w *WorkflowTracker) Complete(ctx context.Context, task *infra_.TaskMessage) error {
+	ready, finished, err := w.broker.CompleteWorkflow(ctx, task.WorkflowID, task.TaskID)
+	if err != nil {
+		return err
+	}
+
+	if err := w.enqueue(ctx, ready); err != nil {
+		return err
+	}
+
+	if !finished {
+		return nil
+	}
+
+	return w.Finish(ctx, task.WorkflowID)
+}
+
+// Finish 所有成员都成功 工作流标记成功并释放去重 key
+func (w *WorkflowTracker) Finish(ctx context.Context, workflowID string) error {
+	res := w.db.Model(&storage.Task{}).Where("id = ? and status = ?", workflowID, constant.TaskPending).
+		Update("status", constant.TaskSuccess)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	log.Printf("workflow %s finished\n", workflowID)
+	return w.release(ctx, workflowID)
+}
+
+// Fail 成员进入死信队列 整个工作流失败 还没投递的任务不会再执行
+func (w *WorkflowTracker) Fail(ctx context.Context, workflowID, taskID, errMsg string) error {
+	return w.stop(ctx, workflowID, constant.TaskFailed, "workflow failed", fmt.Sprintf("task %s: %s", taskID, errMsg))
+}
+
+// Cancel 成员被取消 整个工作流取消 还没投递的任务不会再执行
+func (w *WorkflowTracker) Cancel(ctx context.Context, workflowID, taskID string) error {
+	return w.stop(ctx, workflowID, constant.TaskCancelled, "workflow cancelled", fmt.Sprintf("task %s: cancelled", taskID))
+}
+
+// stop 结束 broker 里的工作流 等待中的成员记为 reason 工作流记录记为 errMsg
+// 等待中的成员按记录过滤(未投递) broker 里的状态丢了也能结束
+func (w *WorkflowTracker) stop(ctx context.Context, workflowID string, status int8, reason, errMsg string) error {
+	if _, err := w.broker.FailWorkflow(ctx, workflowID); err != nil {
+		return err
+	}
+
+	if err := w.db.Model(&storage.Task{}).
+		Where("workflow_id = ? and status = ? and enqueued = ?", workflowID, constant.TaskPending, false).
+		Updates(map[string]interface{}{
+			"status":    status,
+			"error_msg": reason,
+		}).Error; err != nil {
+		return err
+	}
+
+	res := w.db.Model(&storage.Task{}).Where("id = ? and status = ?", workflowID, constant.TaskPending).Updates(map[string]interface{}{
+		"status":    status,
+		"error_msg": errMsg,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	log.Printf("%s: %s, %s\n", reason, workflowID, errMsg)
+	return w.release(ctx, workflowID)
+}
+
+// release 工作流结束后才允许同一个业务再提交
+func (w *WorkflowTracker) release(ctx context.Context, workflowID string) error {
+	var workflow storage.Task
+	if err := w.db.Select("id", "unique_key").Where("id = ?", workflowID).First(&workflow).Error; err != nil {
+		return err
+	}
+	if workflow.UniqueKey == "" {
+		return nil
+	}
+
+	return w.broker.Ack(ctx, &infra_.TaskMessage{TaskID: workflowID, UniqueKey: workflow.UniqueKey})
+}
+
+// enqueue 按记录投递后继任务 等待期间被取消的不再投递 relay 已经补投的不重复投递
+func (w *WorkflowTracker) enqueue(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var tasks []storage.Task
+	if err := w.db.Where("id in ? and status = ? and enqueued = ?", ids, constant.TaskPending, false).Find(&tasks).Error; err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		message, err := messageFromRecord(&tasks[i])
+		if err != nil {
+			return err
+		}
+		if err := w.broker.Enqueue(ctx, message); err != nil {
+			return err
+		}
+		if err := w.db.Model(&storage.Task{}).Where("id = ?", message.TaskID).Update("enqueued", true).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package task_handler
+
+import (
+	"context"
+	"fmt"
+	"stream_hub/internal/components/audit"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+)
+
+// AuditHandler 转码完成后自动审核 通过/拒绝直接改状态 需要人工的进入审核队列
+// Source 是 media 时 BizID 是文件ID 审核引用这个文件的所有待审核视频(上传工作流)
+// Source 是 video 时 BizID 是视频ID
+func (c *CommonTaskHandler) AuditHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	switch task.Payload.Source {
+	case constant.Media:
+		return c.auditor.AuditMedia(ctx, task.BizID)
+	case constant.Video:
+		return c.auditor.Audit(ctx, task.BizID)
+	default:
+		return fmt.Errorf("unknown audit source %q", task.Payload.Source)
+	}
+}
+
+// Auditor 管理接口的人工审核和审核任务共用这个实例
+func (c *CommonTaskHandler) Auditor() *audit.Auditor {
+	return c.auditor
+}
+
+// RegisterChecker 注册额外的审核检查器 比如接入画面分类的 audit.FrameChecker
+func (c *CommonTaskHandler) RegisterChecker(checker audit.Checker) {
+	c.auditor.Register(checker)
+}
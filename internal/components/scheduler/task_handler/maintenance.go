@@ -0,0 +1,276 @@
+package task_handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 定时任务每批处理的记录数
+const maintenanceBatch = 500
+
+// 分片上传的信息在 redis 里只保留一天 超过这个时间客户端已经无法续传
+const abandonedUploadAge = time.Hour * 24
+
+// reconcileCountScript ARGV 前半是对账前读到的值 后半是 mysql 的计数
+// 计数器的值和对账前一致才覆盖 返回跳过的个数
+const reconcileCountScript = `
+local n = #KEYS
+local skipped = 0
+for i = 1, n do
+	local current = redis.call("GET", KEYS[i]) or ""
+	if current == ARGV[i] then
+		redis.call("SET", KEYS[i], ARGV[n + i])
+	else
+		skipped = skipped + 1
+	end
+end
+return skipped
+`
+
+type countRow struct {
+	ID    string
+	Count int64
+}
+
+// ReconcileCountsHandler 定时对账计数 以 mysql 里的关系为准
+// 用户的关注数/粉丝数/作品数/点赞数写回用户表 视频的点赞数/收藏数重置 redis 里的计数器
+func (c *CommonTaskHandler) ReconcileCountsHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	users, err := c.reconcileUserCounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	videos, err := c.reconcileVideoCounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := core.SetResult(ctx, map[string]int{
+		"users":  users,
+		"videos": videos,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	return nil
+}
+
+func (c *CommonTaskHandler) reconcileUserCounts(ctx context.Context) (int, error) {
+	total := 0
+	var users []storage.User
+	err := c.DB.WithContext(ctx).Select("id").FindInBatches(&users, maintenanceBatch, func(tx *gorm.DB, batch int) error {
+		ids := make([]string, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+
+		follows, err := c.countBy(ctx, &storage.UserFollowModel{}, "user_id", ids)
+		if err != nil {
+			return err
+		}
+		followers, err := c.countBy(ctx, &storage.UserFollowModel{}, "target_user_id", ids)
+		if err != nil {
+			return err
+		}
+		works, err := c.countBy(ctx, &storage.VideoModel{}, "author_id", ids)
+		if err != nil {
+			return err
+		}
+		favorites, err := c.countBy(ctx, &storage.VideoLikeModel{}, "user_id", ids)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := c.DB.WithContext(ctx).Model(&storage.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"follow_count":   follows[id],
+				"follower_count": followers[id],
+				"work_count":     works[id],
+				"favorite_count": favorites[id],
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		total += len(ids)
+		return ctx.Err()
+	}).Error
+
+	return total, err
+}
+
+func (c *CommonTaskHandler) reconcileVideoCounts(ctx context.Context) (int, error) {
+	total := 0
+	var videos []storage.VideoModel
+	err := c.DB.WithContext(ctx).Select("id").FindInBatches(&videos, maintenanceBatch, func(tx *gorm.DB, batch int) error {
+		ids := make([]string, 0, len(videos))
+		for _, video := range videos {
+			ids = append(ids, video.ID)
+		}
+
+		// 点赞/收藏先改 redis 再写 mysql 对账前先记下计数器的值 统计期间变了的说明有新的操作 留给下一轮
+		keys := make([]string, 0, 2*len(ids))
+		for _, id := range ids {
+			keys = append(keys, fmt.Sprintf("video:like:count:%s", id), fmt.Sprintf("video:favorite:count:%s", id))
+		}
+		before, err := c.getCounters(ctx, keys)
+		if err != nil {
+			return err
+		}
+
+		likes, err := c.countBy(ctx, &storage.VideoLikeModel{}, "video_id", ids)
+		if err != nil {
+			return err
+		}
+		favorites, err := c.countBy(ctx, &storage.VideoFavoriteModel{}, "video_id", ids)
+		if err != nil {
+			return err
+		}
+
+		args := make([]interface{}, 0, 2*len(keys))
+		args = append(args, before...)
+		for _, id := range ids {
+			args = append(args, likes[id], favorites[id])
+		}
+		skipped, err := c.Redis.Eval(ctx, reconcileCountScript, keys, args...).Int()
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			log.Printf("%d video counters changed during reconcile, skip them\n", skipped)
+		}
+
+		total += len(ids)
+		return ctx.Err()
+	}).Error
+
+	return total, err
+}
+
+// getCounters 读取计数器的当前值 不存在的记为空字符串
+func (c *CommonTaskHandler) getCounters(ctx context.Context, keys []string) ([]interface{}, error) {
+	pipeline := c.Redis.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipeline.Get(ctx, key))
+	}
+	if _, err := pipeline.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(keys))
+	for _, cmd := range cmds {
+		value, err := cmd.Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// countBy 按 column 分组计数 没有记录的ID不在结果里
+func (c *CommonTaskHandler) countBy(ctx context.Context, model interface{}, column string, ids []string) (map[string]int64, error) {
+	var rows []countRow
+	if err := c.DB.WithContext(ctx).Model(model).
+		Select(column+" as id, count(*) as count").
+		Where(column+" in ?", ids).
+		Group(column).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+
+	return counts, nil
+}
+
+// ESReindexHandler 定时按最新 mapping 重建视频索引 和 es_index -reindex 一样
+// 切换别名后补同步拷贝期间变化的视频 然后删掉旧索引 定时任务没有人确认 不保留旧版本
+func (c *CommonTaskHandler) ESReindexHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	// 往前留一点余量 防止和 mysql 的时间有偏差
+	since := time.Now().Add(-time.Minute)
+	index, old, err := c.ES.Reindex(ctx, storage.VideoIndexMapping)
+	if err != nil {
+		return err
+	}
+	log.Printf("es alias switched from %s to %s\n", old, index)
+
+	// 拷贝期间的写入只进了旧索引 包括软删除的视频
+	synced := 0
+	var videos []storage.VideoModel
+	if err := c.DB.WithContext(ctx).Unscoped().Model(&storage.VideoModel{}).
+		Select("id").
+		Where("updated_at >= ? or deleted_at >= ?", since, since).
+		FindInBatches(&videos, maintenanceBatch, func(tx *gorm.DB, batch int) error {
+			for _, video := range videos {
+				if err := c.SyncVideo(ctx, video.ID); err != nil {
+					return err
+				}
+				synced++
+			}
+			return nil
+		}).Error; err != nil {
+		return err
+	}
+
+	// 以别名命名的老索引在切换别名时已经删掉了
+	if old != index && old != c.ES.Alias() {
+		if err := c.ES.DeleteIndex(old); err != nil {
+			return err
+		}
+	}
+
+	if err := core.SetResult(ctx, map[string]interface{}{
+		"index":  index,
+		"old":    old,
+		"synced": synced,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	return nil
+}
+
+// MultipartCleanupHandler 清理放弃了的分片上传 已经上传的分片一直占着 minio 的空间
+// 只清理发起超过一天的 redis 里的上传信息已经过期 客户端不可能再续传
+func (c *CommonTaskHandler) MultipartCleanupHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	deadline := time.Now().Add(-abandonedUploadAge)
+	aborted := 0
+	for upload := range c.Minio.Client.ListIncompleteUploads(ctx, constant.VideoBucket, "", true) {
+		if upload.Err != nil {
+			return upload.Err
+		}
+		if upload.Initiated.After(deadline) {
+			continue
+		}
+
+		if err := c.Minio.Core.AbortMultipartUpload(ctx, constant.VideoBucket, upload.Key, upload.UploadID); err != nil {
+			return err
+		}
+		aborted++
+	}
+
+	log.Printf("%d abandoned multipart uploads aborted\n", aborted)
+	if err := core.SetResult(ctx, map[string]int{
+		"aborted": aborted,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	return nil
+}
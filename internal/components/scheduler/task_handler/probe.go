@@ -0,0 +1,248 @@
+package task_handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ffprobe 能读到文件但认不出格式时的输出 出现这些说明文件本身有问题 重试没有意义
+var invalidMediaOutputs = []string{
+	"Invalid data found when processing input",
+	"moov atom not found",
+	"could not find codec parameters",
+}
+
+// ProbeHandler 上传工作流的第一步 探测元数据 不是有效视频的文件标记为失败
+// 后续的转码和封面任务由工作流在探测成功后投递 看到文件失败直接跳过
+func (c *CommonTaskHandler) ProbeHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	var media storage.FileModel
+	if err := c.DB.Where("id = ?", task.BizID).First(&media).Error; err != nil {
+		return err
+	}
+
+	if media.Status == constant.FileStatusFailed {
+		return nil
+	}
+
+	// 重试时已经探测过的不再探测
+	if len(media.Meta) > 0 {
+		return nil
+	}
+
+	presignedURL, err := c.Minio.Client.PresignedGetObject(ctx, constant.VideoBucket, media.FilePath, time.Hour, nil)
+	if err != nil {
+		return fmt.Errorf("failed to generate presigned url: %w", err)
+	}
+
+	meta, err := probeMedia(ctx, presignedURL.String())
+	if errors.Is(err, errors_.MediaInvalid) {
+		return c.rejectMedia(ctx, &media, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.saveMeta(ctx, &media, meta)
+}
+
+// saveMeta 元数据写到文件上 同时更新所有引用这个文件的视频 并同步到 es
+// 之后创建的视频由 CreateVideo 从文件上复制
+func (c *CommonTaskHandler) saveMeta(ctx context.Context, media *storage.FileModel, meta *storage.VideoMeta) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	duration := int64(math.Round(meta.Duration))
+
+	return c.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 先更新文件 和 CreateVideo 里对文件行的加锁互斥 保证新建的视频不会漏掉元数据
+		if err := tx.Model(&storage.FileModel{}).Where("id = ?", media.ID).Updates(map[string]interface{}{
+			"duration": duration,
+			"meta":     raw,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&storage.VideoModel{}).Where("source_object_key = ?", media.FilePath).Updates(map[string]interface{}{
+			"duration":   duration,
+			"video_meta": raw,
+		}).Error; err != nil {
+			return err
+		}
+
+		var videos []string
+		if err := tx.Model(&storage.VideoModel{}).Where("source_object_key = ?", media.FilePath).Pluck("id", &videos).Error; err != nil {
+			return err
+		}
+
+		return c.syncVideosTx(tx, videos)
+	})
+}
+
+// syncVideosTx 在调用方的事务里给每个视频写一条同步 es 的任务
+func (c *CommonTaskHandler) syncVideosTx(tx *gorm.DB, videos []string) error {
+	for _, id := range videos {
+		if err := c.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+			Type:     constant.TaskVideoToES,
+			BizID:    id,
+			Priority: "default",
+			Payload: infra_.TaskPayload{
+				Action: constant.ActionUpdate,
+				Source: constant.Media,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rejectMedia 标记为无效文件 任务本身算成功 避免无意义的重试
+func (c *CommonTaskHandler) rejectMedia(ctx context.Context, media *storage.FileModel, reason string) error {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+
+	if err := core.SetResult(ctx, map[string]string{
+		"rejected": reason,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	return c.DB.WithContext(ctx).Model(&storage.FileModel{}).Where("id = ?", media.ID).Updates(map[string]interface{}{
+		"status": constant.FileStatusFailed,
+		"reason": reason,
+	}).Error
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		Channels     int    `json:"channels"`
+		Tags         struct {
+			Rotate string `json:"rotate"`
+		} `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// probeMedia ffprobe 读取时长/分辨率/编码/码率/帧率/旋转/声道
+// 文件无法解析或者没有视频画面时返回 MediaInvalid 其余错误(网络等)可以重试
+func probeMedia(ctx context.Context, input string) (*storage.VideoMeta, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-of", "json",
+		input,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		for _, s := range invalidMediaOutputs {
+			if strings.Contains(stderr.String(), s) {
+				return nil, fmt.Errorf("%w: %s", errors_.MediaInvalid, s)
+			}
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	var result probeOutput
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	meta := &storage.VideoMeta{
+		Format: result.Format.FormatName,
+	}
+	meta.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	meta.Bitrate, _ = strconv.ParseInt(result.Format.BitRate, 10, 64)
+
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			// mp3 之类的封面图也是 video 流 跳过
+			if meta.VideoCodec != "" || stream.Disposition.AttachedPic == 1 {
+				continue
+			}
+			meta.VideoCodec = stream.CodecName
+			meta.Width, meta.Height = stream.Width, stream.Height
+			meta.FrameRate = parseFrameRate(stream.AvgFrameRate)
+
+			// 老版本 ffprobe 放在 tags.rotate 新版本放在 displaymatrix 里 方向相反
+			if rotate, err := strconv.Atoi(stream.Tags.Rotate); err == nil {
+				meta.Rotation = rotate
+			}
+			for _, side := range stream.SideDataList {
+				if side.Rotation != 0 {
+					meta.Rotation = -int(side.Rotation)
+				}
+			}
+			meta.Rotation = (meta.Rotation%360 + 360) % 360
+		case "audio":
+			if meta.AudioCodec == "" {
+				meta.AudioCodec = stream.CodecName
+				meta.AudioChannels = stream.Channels
+			}
+		}
+	}
+
+	if meta.VideoCodec == "" || meta.Width <= 0 || meta.Height <= 0 {
+		return nil, fmt.Errorf("%w: no video stream", errors_.MediaInvalid)
+	}
+	// 单张图片也能被识别成视频流 但没有时长
+	if meta.Duration <= 0 {
+		return nil, fmt.Errorf("%w: no duration", errors_.MediaInvalid)
+	}
+
+	return meta, nil
+}
+
+// parseFrameRate "30000/1001" -> 29.97
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+
+	return math.Round(n/d*100) / 100
+}
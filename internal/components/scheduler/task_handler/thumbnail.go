@@ -0,0 +1,265 @@
+package task_handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// ThumbnailHandler 截取默认封面 生成进度条预览用的雪碧图和 WebVTT 索引
+// 封面上传到 public-img 雪碧图和 HLS 放在一起 output/{id}/storyboard/
+func (c *CommonTaskHandler) ThumbnailHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	var media storage.FileModel
+	if err := c.DB.Where("id = ?", task.BizID).First(&media).Error; err != nil {
+		return err
+	}
+
+	if media.Status == constant.FileStatusFailed {
+		return nil
+	}
+
+	// 和转码任务并行执行 不能共用一个临时目录
+	localTmpDir := filepath.Join("./tmp", media.ID+"_thumbnail")
+	if err := os.RemoveAll(localTmpDir); err != nil {
+		return fmt.Errorf("failed to clean tmp dir: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(localTmpDir, "storyboard"), 0755); err != nil {
+		return fmt.Errorf("failed to create tmp dir: %w", err)
+	}
+	defer os.RemoveAll(localTmpDir)
+
+	presignedURL, err := c.Minio.Client.PresignedGetObject(ctx, constant.VideoBucket, media.FilePath, time.Hour*2, nil)
+	if err != nil {
+		return fmt.Errorf("failed to generate presigned url: %w", err)
+	}
+	input := presignedURL.String()
+
+	meta := new(storage.VideoMeta)
+	if len(media.Meta) > 0 {
+		if err := json.Unmarshal(media.Meta, meta); err != nil {
+			return err
+		}
+	} else if meta, err = probeMedia(ctx, input); err != nil {
+		return err
+	}
+
+	// 重试时封面已经生成过就不再截取
+	cover := media.CoverUrl
+	if cover == "" {
+		if err := core.ReportProgress(ctx, 10, "cover"); err != nil {
+			log.Println("err:", err)
+		}
+
+		if cover, err = c.extractCover(ctx, input, localTmpDir, media.ID, meta); err != nil {
+			return err
+		}
+
+		if err := c.saveCover(ctx, &media, cover); err != nil {
+			return err
+		}
+	}
+
+	if err := core.ReportProgress(ctx, 30, "storyboard"); err != nil {
+		log.Println("err:", err)
+	}
+
+	storyboard, err := c.generateStoryboard(ctx, input, filepath.Join(localTmpDir, "storyboard"), media.ID, meta)
+	if err != nil {
+		return err
+	}
+
+	if err := core.SetResult(ctx, map[string]string{
+		"cover":      cover,
+		"storyboard": storyboard,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	return nil
+}
+
+// extractCover 跳过片头的黑场 在之后的一段画面里选最有代表性的一帧 返回 /public-img/covers/{id}.jpg
+func (c *CommonTaskHandler) extractCover(ctx context.Context, input, dir, id string, meta *storage.VideoMeta) (string, error) {
+	width := c.thumbnail.CoverWidth
+	if width <= 0 {
+		width = 1280
+	}
+	scale := fmt.Sprintf("scale='min(%d,iw)':-2", width)
+
+	// 片头经常是黑屏或者 logo 从 10% 处开始找 最多 30 秒
+	offset := math.Min(meta.Duration/10, 30)
+	localFile := filepath.Join(dir, "cover.jpg")
+
+	// blackframe 给每帧标上黑色像素占比 去掉 90% 以上是黑色的帧 thumbnail 在剩下的 50 帧里选和平均画面最接近的
+	filter := "blackframe=amount=0:threshold=32," +
+		"metadata=select:key=lavfi.blackframe.pblack:value=90:function=less," +
+		"thumbnail=50," + scale
+	if err := runFFmpeg(ctx, "ffmpeg", "-y",
+		"-ss", fmt.Sprintf("%.3f", offset),
+		"-i", input,
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "2",
+		localFile,
+	); err != nil {
+		return "", fmt.Errorf("ffmpeg cover failed: %w", err)
+	}
+
+	// 后面全是黑屏时没有输出 退回到第一帧
+	if _, err := os.Stat(localFile); err != nil {
+		if err := runFFmpeg(ctx, "ffmpeg", "-y",
+			"-i", input,
+			"-vf", scale,
+			"-frames:v", "1",
+			"-q:v", "2",
+			localFile,
+		); err != nil {
+			return "", fmt.Errorf("ffmpeg cover failed: %w", err)
+		}
+	}
+
+	objectName := fmt.Sprintf("covers/%s.jpg", id)
+	if _, err := c.Minio.Client.FPutObject(ctx, constant.PublicImageBucket, objectName, localFile, minio.PutObjectOptions{
+		ContentType: "image/jpeg",
+	}); err != nil {
+		return "", fmt.Errorf("failed to upload cover: %w", err)
+	}
+
+	return fmt.Sprintf("/%s/%s", constant.PublicImageBucket, objectName), nil
+}
+
+// saveCover 封面写到文件上 引用这个文件且没有指定封面的视频使用它 并同步到 es
+func (c *CommonTaskHandler) saveCover(ctx context.Context, media *storage.FileModel, cover string) error {
+	return c.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 先更新文件 和 CreateVideo 里对文件行的加锁互斥
+		if err := tx.Model(&storage.FileModel{}).Where("id = ?", media.ID).Update("cover_url", cover).Error; err != nil {
+			return err
+		}
+
+		var videos []string
+		if err := tx.Model(&storage.VideoModel{}).Where("source_object_key = ? and cover_url = ''", media.FilePath).
+			Pluck("id", &videos).Error; err != nil {
+			return err
+		}
+		if len(videos) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&storage.VideoModel{}).Where("id in ?", videos).Update("cover_url", cover).Error; err != nil {
+			return err
+		}
+
+		return c.syncVideosTx(tx, videos)
+	})
+}
+
+// generateStoryboard 每隔 interval 秒截一张缩略图 拼成 columns x rows 的雪碧图
+// WebVTT 里每段时间对应雪碧图里的一块 播放器拖动进度条时显示 返回 vtt 的路径
+func (c *CommonTaskHandler) generateStoryboard(ctx context.Context, input, dir, id string, meta *storage.VideoMeta) (string, error) {
+	conf := c.thumbnail
+	if conf.Interval <= 0 {
+		conf.Interval = 5
+	}
+	if conf.MaxFrames <= 0 {
+		conf.MaxFrames = 400
+	}
+	if conf.Width <= 0 {
+		conf.Width = 160
+	}
+	if conf.Columns <= 0 {
+		conf.Columns = 10
+	}
+	if conf.Rows <= 0 {
+		conf.Rows = 10
+	}
+
+	// 视频太长时加大间隔 控制雪碧图数量
+	interval := math.Max(float64(conf.Interval), math.Ceil(meta.Duration/float64(conf.MaxFrames)))
+	frames := int(math.Ceil(meta.Duration / interval))
+	width := conf.Width &^ 1
+	// 探测不到分辨率时按 16:9 算 避免除零
+	height := width * 9 / 16 &^ 1
+	if displayWidth, displayHeight := meta.DisplaySize(); displayWidth > 0 && displayHeight > 0 {
+		height = int(math.Round(float64(width)*float64(displayHeight)/float64(displayWidth)/2)) * 2
+	}
+
+	// 要解码所有帧 只解码关键帧时 fps 按关键帧补帧 画面和 vtt 里的时间对不上
+	if err := runFFmpeg(ctx, "ffmpeg", "-y",
+		"-i", input,
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d", interval, width, height, conf.Columns, conf.Rows),
+		"-an",
+		"-vsync", "vfr",
+		"-q:v", "5",
+		filepath.Join(dir, "sprite%03d.jpg"),
+	); err != nil {
+		return "", fmt.Errorf("ffmpeg storyboard failed: %w", err)
+	}
+
+	if err := core.ReportProgress(ctx, 70, "uploading"); err != nil {
+		log.Println("err:", err)
+	}
+
+	// 按实际写出的雪碧图数量截断 最后一张可能没有铺满
+	perSprite := conf.Columns * conf.Rows
+	sprites, err := filepath.Glob(filepath.Join(dir, "sprite*.jpg"))
+	if err != nil {
+		return "", err
+	}
+	if len(sprites) == 0 {
+		return "", fmt.Errorf("ffmpeg storyboard wrote no sprite")
+	}
+	frames = min(frames, len(sprites)*perSprite)
+
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for i := 0; i < frames; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, meta.Duration)
+		pos := i % perSprite
+		fmt.Fprintf(&buf, "\n%s --> %s\nsprite%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTime(start), vttTime(end), i/perSprite+1,
+			pos%conf.Columns*width, pos/conf.Columns*height, width, height)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "storyboard.vtt"), buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write storyboard: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for i, file := range files {
+		targetKey := fmt.Sprintf("output/%s/storyboard/%s", id, file.Name())
+		if _, err := c.Minio.Client.FPutObject(ctx, constant.VideoBucket, targetKey, filepath.Join(dir, file.Name()), minio.PutObjectOptions{
+			ContentType: c.getContentType(file.Name()),
+		}); err != nil {
+			return "", fmt.Errorf("failed to upload %s: %w", file.Name(), err)
+		}
+
+		// 截图占 30-70 上传占 70-95
+		if err := core.ReportProgress(ctx, 70+25*(i+1)/len(files), "uploading"); err != nil {
+			log.Println("err:", err)
+		}
+	}
+
+	return fmt.Sprintf("output/%s/storyboard/storyboard.vtt", id), nil
+}
+
+// vttTime 秒 -> 00:01:05.500
+func vttTime(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
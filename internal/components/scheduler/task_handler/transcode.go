@@ -1 +1,348 @@
 package task_handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"stream_hub/internal/components/scheduler/core"
+	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
+	"stream_hub/pkg/model/config"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// 没有配置 transcode.ladder 时使用
+var defaultLadder = []config.Rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 128},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 96},
+	{Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 64},
+}
+
+// rendition 按原视频算出实际输出尺寸的档位
+type rendition struct {
+	config.Rendition
+	Width int
+}
+
+func (c *CommonTaskHandler) TranscodeHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	var media storage.FileModel
+	if err := c.DB.Where("id = ?", task.BizID).First(&media).Error; err != nil {
+		return err
+	}
+
+	if media.Status == constant.FileStatusTranscodeFinished || media.Status == constant.FileStatusFailed {
+		return nil
+	}
+
+	localTmpDir := filepath.Join("./tmp", media.ID) // 本地临时存放切片的目录
+
+	// 重试时先清掉上次残留的切片 处理完后自动清理
+	if err := os.RemoveAll(localTmpDir); err != nil {
+		return fmt.Errorf("failed to clean tmp dir: %w", err)
+	}
+	if err := os.MkdirAll(localTmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create tmp dir: %w", err)
+	}
+	defer os.RemoveAll(localTmpDir)
+
+	// 生成 MinIO 临时下载链接 (让 FFmpeg 能够读取私有桶文件)
+	expiry := time.Hour * 2
+	presignedURL, err := c.Minio.Client.PresignedGetObject(ctx, constant.VideoBucket, media.FilePath, expiry, nil)
+	if err != nil {
+		return fmt.Errorf("failed to generate presigned url: %w", err)
+	}
+
+	// 正常流程里 probe 任务已经探测过 直接投递的转码任务这里补探测
+	meta := new(storage.VideoMeta)
+	if len(media.Meta) > 0 {
+		if err := json.Unmarshal(media.Meta, meta); err != nil {
+			return err
+		}
+	} else if meta, err = probeMedia(ctx, presignedURL.String()); err != nil {
+		// 和 probe 任务一样 无效文件直接标记 不再重试
+		if errors.Is(err, errors_.MediaInvalid) {
+			return c.rejectMedia(ctx, &media, err.Error())
+		}
+		return err
+	}
+
+	width, height := meta.DisplaySize()
+	hasAudio := meta.AudioCodec != ""
+	renditions := buildLadder(c.ladder(), width, height)
+	for _, r := range renditions {
+		if err := os.MkdirAll(filepath.Join(localTmpDir, r.Name), 0755); err != nil {
+			return fmt.Errorf("failed to create tmp dir: %w", err)
+		}
+	}
+
+	if err := core.ReportProgress(ctx, 10, "transcoding"); err != nil {
+		log.Println("err:", err)
+	}
+
+	// 所有档位一次解码 分别缩放编码 比每个档位跑一次 ffmpeg 省一半以上的 CPU
+	args := c.transcodeArgs(presignedURL.String(), localTmpDir, renditions, hasAudio)
+	if err := runFFmpeg(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("ffmpeg transcode failed: %w", err)
+	}
+
+	if err := writeMasterPlaylist(filepath.Join(localTmpDir, "index.m3u8"), renditions, hasAudio); err != nil {
+		return err
+	}
+
+	if err := core.ReportProgress(ctx, 70, "uploading"); err != nil {
+		log.Println("err:", err)
+	}
+
+	// 批量上传转码后的文件到 MinIO 保留子目录 比如：output/video_123/720p/seg001.ts
+	var files []string
+	if err := filepath.WalkDir(localTmpDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to list transcoded files: %w", err)
+	}
+
+	for i, localFile := range files {
+		rel, err := filepath.Rel(localTmpDir, localFile)
+		if err != nil {
+			return err
+		}
+		targetKey := fmt.Sprintf("output/%s/%s", media.ID, filepath.ToSlash(rel))
+
+		_, err = c.Minio.Client.FPutObject(ctx, constant.VideoBucket, targetKey, localFile, minio.PutObjectOptions{
+			ContentType: c.getContentType(localFile), // 根据后缀设置类型
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", rel, err)
+		}
+
+		// 转码占 10-70 上传占 70-95
+		if err := core.ReportProgress(ctx, 70+25*(i+1)/len(files), "uploading"); err != nil {
+			log.Println("err:", err)
+		}
+	}
+
+	names := make([]string, 0, len(renditions))
+	for _, r := range renditions {
+		names = append(names, r.Name)
+	}
+	if err := core.SetResult(ctx, map[string]interface{}{
+		"playlist":   fmt.Sprintf("output/%s/index.m3u8", media.ID),
+		"renditions": names,
+	}); err != nil {
+		log.Println("err:", err)
+	}
+
+	// 标记转码完成 同一个事务里给引用这个文件的待审核视频投递审核任务
+	// 先更新文件 和 CreateVideo 里对文件行的加锁互斥 之后创建的视频由 CreateVideo 投递
+	// 上传工作流里的转码由后继的审核任务统一审核 不再单独投递
+	return c.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&storage.FileModel{}).Where("id = ?", media.ID).Update("status", constant.FileStatusTranscodeFinished).Error; err != nil {
+			return err
+		}
+		if task.WorkflowID != "" {
+			return nil
+		}
+
+		var videos []string
+		if err := tx.Model(&storage.VideoModel{}).
+			Where("source_object_key = ? and status = ?", media.FilePath, constant.VideoChecking).
+			Pluck("id", &videos).Error; err != nil {
+			return err
+		}
+
+		for _, id := range videos {
+			if err := c.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+				Type:     constant.TaskVideoAudit,
+				BizID:    id,
+				Priority: "default",
+				Payload: infra_.TaskPayload{
+					Source: constant.Video,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (c *CommonTaskHandler) ladder() []config.Rendition {
+	if len(c.transcode.Ladder) == 0 {
+		return defaultLadder
+	}
+	return c.transcode.Ladder
+}
+
+// buildLadder 从高到低排列 跳过高于原视频的档位 不放大
+// 原视频比最低档还小时只输出一个最低档 分辨率用原视频的
+func buildLadder(ladder []config.Rendition, width, height int) []rendition {
+	sorted := make([]config.Rendition, len(ladder))
+	copy(sorted, ladder)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Height > sorted[j].Height
+	})
+
+	var renditions []rendition
+	for _, r := range sorted {
+		if r.Height <= height {
+			renditions = append(renditions, rendition{r, scaleWidth(width, height, r.Height)})
+		}
+	}
+
+	if len(renditions) == 0 && len(sorted) > 0 {
+		lowest := sorted[len(sorted)-1]
+		lowest.Height = height &^ 1 // libx264 要求宽高是偶数
+		renditions = append(renditions, rendition{lowest, width &^ 1})
+	}
+
+	return renditions
+}
+
+// scaleWidth 按比例算宽 取偶数
+func scaleWidth(width, height, target int) int {
+	return int(math.Round(float64(width)*float64(target)/float64(height)/2)) * 2
+}
+
+func (c *CommonTaskHandler) transcodeArgs(input, dir string, renditions []rendition, hasAudio bool) []string {
+	segmentTime := c.transcode.SegmentTime
+	if segmentTime <= 0 {
+		segmentTime = 6
+	}
+	preset := c.transcode.Preset
+	if preset == "" {
+		preset = "veryfast"
+	}
+
+	// [0:v]split=2[s0][s1];[s0]scale=1920:1080[v0];[s1]scale=1280:720[v1]
+	split := fmt.Sprintf("[0:v]split=%d", len(renditions))
+	var scales []string
+	for i, r := range renditions {
+		split += fmt.Sprintf("[s%d]", i)
+		scales = append(scales, fmt.Sprintf("[s%d]scale=%d:%d[v%d]", i, r.Width, r.Height, i))
+	}
+
+	args := []string{
+		"-i", input, // 输入：MinIO 临时链接
+		"-filter_complex", strings.Join(append([]string{split}, scales...), ";"),
+	}
+
+	var streamMap []string
+	for i, r := range renditions {
+		n := strconv.Itoa(i)
+		args = append(args,
+			"-map", "[v"+n+"]",
+			"-c:v:"+n, "libx264",
+			"-b:v:"+n, fmt.Sprintf("%dk", r.VideoBitrate),
+			"-maxrate:v:"+n, fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			"-bufsize:v:"+n, fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		)
+		stream := "v:" + n
+		if hasAudio {
+			args = append(args,
+				"-map", "0:a:0",
+				"-c:a:"+n, "aac",
+				"-b:a:"+n, fmt.Sprintf("%dk", r.AudioBitrate),
+			)
+			stream += ",a:" + n
+		}
+		streamMap = append(streamMap, stream+",name:"+r.Name)
+	}
+
+	args = append(args,
+		"-preset", preset,
+		"-ac", "2",
+		// 固定间隔强制关键帧 关闭场景切换插入关键帧 保证各档位切片边界一致
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentTime),
+		"-sc_threshold", "0",
+		"-f", "hls", // 输出格式为 HLS
+		"-hls_time", strconv.Itoa(segmentTime),
+		"-hls_playlist_type", "vod", // 索引保留所有切片
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "%v", "seg%03d.ts"),
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(dir, "%v", "index.m3u8"),
+	)
+
+	return args
+}
+
+// writeMasterPlaylist 主播放列表 从高到低列出各档位 播放器按带宽自动选择
+func writeMasterPlaylist(path string, renditions []rendition, hasAudio bool) error {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, r := range renditions {
+		// BANDWIDTH 是峰值码率 用 maxrate 计算
+		bandwidth := r.VideoBitrate * 107 / 100
+		average := r.VideoBitrate
+		if hasAudio {
+			bandwidth += r.AudioBitrate
+			average += r.AudioBitrate
+		}
+		fmt.Fprintf(&buf, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d\n",
+			bandwidth*1000, average*1000, r.Width, r.Height)
+		fmt.Fprintf(&buf, "%s/index.m3u8\n", r.Name)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write master playlist: %w", err)
+	}
+	return nil
+}
+
+// runFFmpeg 失败时带上 stderr 的最后一行 方便在死信里看原因
+func runFFmpeg(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+func (c *CommonTaskHandler) getContentType(fileName string) string {
+	switch filepath.Ext(fileName) {
+	case ".m3u8":
+		return "application/x-mpegURL"
+	case ".ts":
+		return "video/MP2T"
+	case ".jpg":
+		return "image/jpeg"
+	case ".vtt":
+		return "text/vtt"
+	default:
+		return "application/octet-stream"
+	}
+}
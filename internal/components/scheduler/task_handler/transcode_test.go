@@ -0,0 +1,155 @@
+package task_handler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"stream_hub/pkg/model/config"
+	"strings"
+	"testing"
+)
+
+// 没有 ffmpeg 的环境跳过 输入用 lavfi 生成 不依赖样例文件
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+
+	for _, name := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not found", name)
+		}
+	}
+}
+
+// generateInput 生成 width x height 的测试视频 3s withAudio 时带一路正弦波音频
+func generateInput(t *testing.T, dir string, width, height int, withAudio bool) string {
+	t.Helper()
+
+	input := filepath.Join(dir, "input.mp4")
+	args := []string{"-y", "-f", "lavfi", "-i", fmt.Sprintf("testsrc=size=%dx%d:rate=25:duration=3", width, height)}
+	if withAudio {
+		args = append(args, "-f", "lavfi", "-i", "sine=frequency=440:duration=3", "-c:a", "aac")
+	}
+	args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-shortest", input)
+
+	if err := runFFmpeg(context.Background(), "ffmpeg", args...); err != nil {
+		t.Fatal(err)
+	}
+
+	return input
+}
+
+func TestTranscodeHLS(t *testing.T) {
+	requireFFmpeg(t)
+
+	cases := []struct {
+		name      string
+		width     int
+		height    int
+		withAudio bool
+		want      []string // 期望的档位 从高到低
+	}{
+		{"720p with audio", 1280, 720, true, []string{"720p", "480p", "360p"}},
+		{"small without audio", 320, 240, false, []string{"360p"}},
+	}
+
+	c := &CommonTaskHandler{transcode: config.TranscodeConfig{SegmentTime: 1, Preset: "ultrafast"}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			input := generateInput(t, tmp, tc.width, tc.height, tc.withAudio)
+
+			meta, err := probeMedia(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Width != tc.width || meta.Height != tc.height || meta.Duration <= 0 {
+				t.Fatalf("meta = %+v", meta)
+			}
+			if hasAudio := meta.AudioCodec != ""; hasAudio != tc.withAudio {
+				t.Fatalf("audio codec = %q", meta.AudioCodec)
+			}
+
+			renditions := buildLadder(c.ladder(), meta.Width, meta.Height)
+			if len(renditions) != len(tc.want) {
+				t.Fatalf("renditions = %+v, want %v", renditions, tc.want)
+			}
+
+			// 和上传到 minio 的 key 一致 output/{id}/{name}/
+			dir := filepath.Join(tmp, "output", "v1")
+			for i, r := range renditions {
+				if r.Name != tc.want[i] {
+					t.Fatalf("rendition %d = %s, want %s", i, r.Name, tc.want[i])
+				}
+				if err := os.MkdirAll(filepath.Join(dir, r.Name), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			hasAudio := meta.AudioCodec != ""
+			if err := runFFmpeg(context.Background(), "ffmpeg", c.transcodeArgs(input, dir, renditions, hasAudio)...); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeMasterPlaylist(filepath.Join(dir, "index.m3u8"), renditions, hasAudio); err != nil {
+				t.Fatal(err)
+			}
+
+			// 每个档位一个完整的点播列表 切片和列表在同一个目录
+			for _, r := range renditions {
+				data, err := os.ReadFile(filepath.Join(dir, r.Name, "index.m3u8"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				playlist := string(data)
+				if !strings.Contains(playlist, "#EXT-X-PLAYLIST-TYPE:VOD") || !strings.Contains(playlist, "#EXT-X-ENDLIST") {
+					t.Errorf("%s playlist:\n%s", r.Name, playlist)
+				}
+				if !strings.Contains(playlist, "seg000.ts") {
+					t.Errorf("%s playlist has no segment:\n%s", r.Name, playlist)
+				}
+				// 实际输出的分辨率和主播放列表里写的一致
+				variant, err := probeMedia(context.Background(), filepath.Join(dir, r.Name, "index.m3u8"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if variant.Width != r.Width || variant.Height != r.Height {
+					t.Errorf("%s is %dx%d, want %dx%d", r.Name, variant.Width, variant.Height, r.Width, r.Height)
+				}
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if lines[0] != "#EXTM3U" {
+				t.Fatalf("master playlist:\n%s", data)
+			}
+
+			var streams []string
+			for i, line := range lines {
+				if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(lines) {
+					streams = append(streams, line, lines[i+1])
+				}
+			}
+			if len(streams) != 2*len(renditions) {
+				t.Fatalf("master playlist:\n%s", data)
+			}
+			for i, r := range renditions {
+				bandwidth := r.VideoBitrate * 107 / 100
+				if hasAudio {
+					bandwidth += r.AudioBitrate
+				}
+				want := fmt.Sprintf("BANDWIDTH=%d,", bandwidth*1000)
+				resolution := fmt.Sprintf("RESOLUTION=%dx%d", r.Width, r.Height)
+				if info := streams[2*i]; !strings.Contains(info, want) || !strings.Contains(info, resolution) {
+					t.Errorf("stream %s = %q, want %s %s", r.Name, info, want, resolution)
+				}
+				if uri := streams[2*i+1]; uri != r.Name+"/index.m3u8" {
+					t.Errorf("stream %s uri = %q", r.Name, uri)
+				}
+			}
+		})
+	}
+}
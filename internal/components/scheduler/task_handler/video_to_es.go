@@ -0,0 +1,33 @@
+package task_handler
+
+import (
+	"context"
+	"errors"
+	"stream_hub/pkg/constant"
+	infra_ "stream_hub/pkg/model/infra"
+	"stream_hub/pkg/model/storage"
+
+	"gorm.io/gorm"
+)
+
+// VideoToESHandler 创建/更新时按 mysql 里的视频重写文档 删除时删文档
+func (c *CommonTaskHandler) VideoToESHandler(ctx context.Context, task *infra_.TaskMessage) error {
+	if task.Payload.Action == constant.ActionDelete {
+		return c.ES.Delete(task.BizID)
+	}
+
+	return c.SyncVideo(ctx, task.BizID)
+}
+
+// SyncVideo 以 mysql 为准 视频已经被删了就删文档 任务乱序执行结果也一样
+func (c *CommonTaskHandler) SyncVideo(ctx context.Context, videoID string) error {
+	var video storage.VideoModel
+	if err := c.DB.WithContext(ctx).Where("id = ?", videoID).First(&video).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.ES.Delete(videoID)
+		}
+		return err
+	}
+
+	return c.ES.Index(video.ID, storage.NewVideoDoc(&video))
+}
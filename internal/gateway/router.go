@@ -6,8 +6,10 @@ import (
 	f "github.com/swaggo/files"
 	ginswagger "github.com/swaggo/gin-swagger"
 	_ "stream_hub/docs_api"
+	metric_sdk "stream_hub/internal/components/metric/client"
 	"stream_hub/internal/infra"
 	"stream_hub/internal/security"
+	"stream_hub/pkg/constant"
 	"stream_hub/pkg/model/config"
 )
 
@@ -22,19 +24,23 @@ import (
 // @description Bearer token
 
 type GatewayRouter struct {
-	router     *gin.Engine
-	gateway    *Gateway
-	middleware *Middleware
-	port       int
+	router      *gin.Engine
+	gateway     *Gateway
+	middleware  *Middleware
+	metrics     *metric_sdk.Metrics
+	metricsConf config.MetricsConfig
+	port        int
 }
 
 func NewGatewayRouter(base *infra.Base, auth *security.Auth, ratelimiter *infra.Ratelimter, commonConf *config.CommonConfig, conf *config.GatewayConfig) *GatewayRouter {
 	srv := NewService(commonConf)
 
 	router := &GatewayRouter{
-		port:       conf.Port,
-		gateway:    NewGateway(base, srv, conf),
-		middleware: NewMiddleware(base, ratelimiter, auth),
+		port:        conf.Port,
+		gateway:     NewGateway(base, srv, conf),
+		middleware:  NewMiddleware(base, ratelimiter, auth),
+		metrics:     metric_sdk.NewMetrics(),
+		metricsConf: conf.Metrics,
 	}
 
 	router.init()
@@ -44,6 +50,8 @@ func NewGatewayRouter(base *infra.Base, auth *security.Auth, ratelimiter *infra.
 
 func (r *GatewayRouter) init() {
 	r.router = gin.Default()
+	r.router.Use(metric_sdk.NewHTTPMetrics(r.metrics, constant.Gateway).Middleware())
+
 	api := r.router.Group("/api")
 	api.Use(r.middleware.Cors(), r.middleware.Ratelimit(), r.middleware.LogToStorage())
 	{
@@ -89,5 +97,14 @@ func (r *GatewayRouter) init() {
 }
 
 func (r *GatewayRouter) Run() error {
+	// /metrics 只在内部端口上暴露
+	if r.metricsConf.Enabled {
+		go func() {
+			if err := r.metrics.Serve(r.metricsConf.Port); err != nil {
+				fmt.Println("metrics err:", err)
+			}
+		}()
+	}
+
 	return r.router.Run(fmt.Sprintf(":%d", r.port))
 }
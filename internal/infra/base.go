@@ -16,7 +16,8 @@ type Base struct {
 	TaskSender *TaskSender
 }
 
-func NewBase(conf *config.CommonConfig) (*Base, error) {
+// NewBase schedulerConf 给 TaskSender 使用 任务相关的 key 和 scheduler 保持一致
+func NewBase(conf *config.CommonConfig, schedulerConf *config.SchedulerConfig) (*Base, error) {
 	clickhouse, err := NewClickhouse(conf)
 	if err != nil {
 		return nil, err
@@ -48,7 +49,7 @@ func NewBase(conf *config.CommonConfig) (*Base, error) {
 		return nil, err
 	}
 
-	taskSender, err := NewTaskSender(conf)
+	taskSender, err := NewTaskSender(conf, schedulerConf)
 	if err != nil {
 		return nil, err
 	}
@@ -55,3 +55,15 @@ func (es *Elasticsearch) Update(id string, query map[string]interface{}) error {
 
 	return err
 }
+
+// Index 写入整个文档 已存在则覆盖
+func (es *Elasticsearch) Index(id string, doc interface{}) error {
+	_, err := es.client.Index(es.index).Id(id).Document(doc).Do(es.ctx)
+	return err
+}
+
+// Delete 文档不存在不算错误
+func (es *Elasticsearch) Delete(id string) error {
+	_, err := es.client.Delete(es.index, id).Do(es.ctx)
+	return err
+}
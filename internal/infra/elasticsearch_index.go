@@ -0,0 +1,141 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 索引的生命周期 es.index 是别名 读写都走别名
+// 真正的索引按版本命名 {alias}_{创建时间} 改 mapping 时建新版本 数据拷过去后原子地切换别名
+
+// Bootstrap 别名和索引都不存在时建第一个版本并挂上别名 返回是否新建
+// 以别名为名字的老索引保持不动 需要执行一次 Reindex 迁移到版本索引
+func (es *Elasticsearch) Bootstrap(mapping string) (bool, error) {
+	current, err := es.CurrentIndex()
+	if err != nil {
+		return false, err
+	}
+	if current != "" {
+		return false, nil
+	}
+
+	index := es.newIndexName()
+	if err := es.createIndex(es.ctx, index, mapping); err != nil {
+		return false, err
+	}
+
+	if _, err := es.client.Indices.PutAlias(index, es.index).Do(es.ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Alias 读写使用的别名
+func (es *Elasticsearch) Alias() string {
+	return es.index
+}
+
+// CurrentIndex 别名指向的索引 老数据是直接以别名命名的索引时返回它本身 都不存在返回空
+func (es *Elasticsearch) CurrentIndex() (string, error) {
+	return es.currentIndex(es.ctx)
+}
+
+func (es *Elasticsearch) currentIndex(ctx context.Context) (string, error) {
+	isAlias, err := es.client.Indices.ExistsAlias(es.index).IsSuccess(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if isAlias {
+		resp, err := es.client.Indices.GetAlias().Name(es.index).Do(ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(resp) != 1 {
+			return "", fmt.Errorf("alias %s points to %d indices", es.index, len(resp))
+		}
+		for index := range resp {
+			return index, nil
+		}
+	}
+
+	exists, err := es.client.Indices.Exists(es.index).IsSuccess(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return es.index, nil
+	}
+
+	return "", nil
+}
+
+// Reindex 按 mapping 建新版本 把当前索引的数据拷过去 再在一个请求里把别名切到新索引 返回新旧索引名
+// 旧索引保留 确认没问题后用 DeleteIndex 删除
+// 拷贝期间的写入仍然进入旧索引 调用方需要在切换后把这段时间变化的数据重新同步一次
+// 旧索引是以别名命名的老索引时(old == Alias())切换的同时已经删掉了
+func (es *Elasticsearch) Reindex(ctx context.Context, mapping string) (string, string, error) {
+	old, err := es.currentIndex(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if old == "" {
+		return "", "", fmt.Errorf("index %s not found, bootstrap it first", es.index)
+	}
+
+	index := es.newIndexName()
+	if err := es.createIndex(ctx, index, mapping); err != nil {
+		return "", "", err
+	}
+
+	body := fmt.Sprintf(`{"source":{"index":%q},"dest":{"index":%q}}`, old, index)
+	resp, err := es.client.Reindex().Raw(strings.NewReader(body)).WaitForCompletion(true).Do(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Failures) > 0 {
+		return "", "", fmt.Errorf("reindex %s to %s: %d failures", old, index, len(resp.Failures))
+	}
+
+	if _, err := es.client.Indices.Refresh().Index(index).Do(ctx); err != nil {
+		return "", "", err
+	}
+
+	// 老索引占着别名的名字 只能在同一个请求里删掉 否则别名加不上
+	remove := fmt.Sprintf(`{"remove":{"index":%q,"alias":%q}}`, old, es.index)
+	if old == es.index {
+		remove = fmt.Sprintf(`{"remove_index":{"index":%q}}`, old)
+	}
+	actions := fmt.Sprintf(`{"actions":[{"add":{"index":%q,"alias":%q}},%s]}`, index, es.index, remove)
+	if _, err := es.client.Indices.UpdateAliases().Raw(strings.NewReader(actions)).Do(ctx); err != nil {
+		return "", "", err
+	}
+
+	return index, old, nil
+}
+
+// DeleteIndex 删除不再被别名指向的旧版本
+func (es *Elasticsearch) DeleteIndex(index string) error {
+	current, err := es.CurrentIndex()
+	if err != nil {
+		return err
+	}
+	if index == current || index == es.index {
+		return fmt.Errorf("index %s is still behind alias %s", index, es.index)
+	}
+
+	_, err = es.client.Indices.Delete(index).Do(es.ctx)
+	return err
+}
+
+func (es *Elasticsearch) createIndex(ctx context.Context, index, mapping string) error {
+	_, err := es.client.Indices.Create(index).Raw(strings.NewReader(mapping)).Do(ctx)
+	return err
+}
+
+func (es *Elasticsearch) newIndexName() string {
+	return fmt.Sprintf("%s_%s", es.index, time.Now().Format("20060102150405"))
+}
@@ -2,12 +2,14 @@ package infra
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/IBM/sarama"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"log"
 	"stream_hub/pkg/constant"
 	"stream_hub/pkg/model/config"
+	"stream_hub/pkg/model/storage"
 	"stream_hub/pkg/mq"
 	"time"
 )
@@ -135,6 +137,24 @@ func (l *Logger) Error(message string, ip, uid, traceID, method, path, module st
 	l.logger.Error(message, l.Field(ip, uid, traceID, method, path, module, status, latency)...)
 }
 
+// System 系统日志(节点状态/熔断等) 量很小 不走批量直接发送到 SystemLogTopic
+func (l *Logger) System(entry *storage.SystemLogEntry) {
+	if entry.EventTime == 0 {
+		entry.EventTime = float64(time.Now().UnixMilli())
+	}
+
+	data, err := json.Marshal([]*storage.SystemLogEntry{entry})
+	if err != nil {
+		log.Println("err:", err)
+		return
+	}
+
+	l.producer.Input() <- &sarama.ProducerMessage{
+		Topic: constant.SystemLogTopic,
+		Value: sarama.ByteEncoder(data),
+	}
+}
+
 func (l *Logger) Field(ip, uid, traceID, method, path, module string, status int16, latency int64) []zap.Field {
 	return []zap.Field{
 		zap.Int64("event_time", time.Now().UnixMilli()), // 建议用毫秒，CK 存储更精准
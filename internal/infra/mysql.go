@@ -24,6 +24,7 @@ func NewMysql(conf *config.CommonConfig) (*DB, error) {
 		&storage.Task{},
 		&storage.FileModel{},
 		&storage.VideoModel{},
+		&storage.VideoAuditModel{},
 		&storage.VideoLikeModel{},
 		&storage.VideoFavoriteModel{},
 		&storage.VideoCommentModel{},
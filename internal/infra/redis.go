@@ -146,4 +146,31 @@ func (r *Redis) ScriptLoad(ctx context.Context, script string) (string, error) {
 
 func (r *Redis) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (bool, error) {
 	return r.Client.EvalSha(ctx, sha1, keys, args...).Bool()
-}
\ No newline at end of file
+}
+func (r *Redis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return r.Client.Eval(ctx, script, keys, args...)
+}
+
+func (r *Redis) LLen(ctx context.Context, key string) (int64, error) {
+	return r.Client.LLen(ctx, key).Result()
+}
+
+func (r *Redis) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.Client.LRange(ctx, key, start, stop).Result()
+}
+
+func (r *Redis) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	return r.Client.LRem(ctx, key, count, value).Result()
+}
+
+func (r *Redis) TxPipeline() redis.Pipeliner {
+	return r.Client.TxPipeline()
+}
+
+func (r *Redis) Publish(ctx context.Context, channel string, message interface{}) error {
+	return r.Client.Publish(ctx, channel, message).Err()
+}
+
+func (r *Redis) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	return r.Client.Subscribe(ctx, channels...)
+}
@@ -3,19 +3,67 @@ package infra
 import (
 	"context"
 	"encoding/json"
+	errors_ "errors"
 	"fmt"
+	"log"
 	"stream_hub/pkg/constant"
+	"stream_hub/pkg/errors"
 	"stream_hub/pkg/model/config"
 	"stream_hub/pkg/model/infra"
 	"stream_hub/pkg/model/storage"
+	"stream_hub/pkg/utils"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
 )
 
+// 取消任务 执行中的通知所在节点(meta 里的 node_id) 返回 1 否则从队列里移除并清理数据 返回 0
+// 已经被 worker 取走还没开始执行的任务 meta 删掉之后 worker 读不到数据会直接丢弃
+const cancelScript = `
+local meta = redis.call("HMGET", KEYS[1], "priority", "node_id", "unique_key")
+redis.call("ZREM", KEYS[3], ARGV[1])
+if redis.call("ZSCORE", KEYS[4], ARGV[1]) then
+	if meta[2] then
+		redis.call("LPUSH", ARGV[2] .. meta[2], ARGV[1])
+	end
+	return 1
+end
+local priority = meta[1]
+if not priority or priority == "" then
+	priority = "default"
+end
+redis.call("LREM", "scheduler:queue:" .. priority, 0, ARGV[1])
+redis.call("DEL", KEYS[1], KEYS[2])
+if meta[3] and redis.call("GET", meta[3]) == ARGV[1] then
+	redis.call("DEL", meta[3])
+end
+return 0
+`
+
+// 去重 key 的值是持有它的任务ID 只有值对得上才删除
+const releaseUniqueScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// 工作流结束后 redis 里的状态保留的时间 和 scheduler 保持一致
+const workflowExpiry = time.Hour * 24
+
 type TaskSender struct {
 	rdb *Redis
 	db  *DB
+	// 和 RedisBroker 使用同一份配置 取消任务时要操作调度器的延迟队列、执行中集合和控制队列
+	delayKey   string
+	runningKey string
+	controlKey string
+	// 内存模式的队列在 scheduler 进程里 这里只写任务记录 由 relay 投递 不访问 redis
+	memory bool
 }
 
-func NewTaskSender(conf *config.CommonConfig) (*TaskSender, error) {
+func NewTaskSender(conf *config.CommonConfig, schedulerConf *config.SchedulerConfig) (*TaskSender, error) {
 	db, err := NewMysql(conf)
 	if err != nil {
 		return nil, err
@@ -24,38 +72,466 @@ func NewTaskSender(conf *config.CommonConfig) (*TaskSender, error) {
 	taskSender := new(TaskSender)
 	taskSender.rdb = NewRedis(conf)
 	taskSender.db = db
+	taskSender.delayKey = schedulerConf.Dispatcher.Queue
+	taskSender.runningKey = schedulerConf.Timeout.RunningKey
+	taskSender.controlKey = schedulerConf.Timeout.ControlKey
+	taskSender.memory = schedulerConf.Broker == "memory"
 
 	return taskSender, nil
 }
 
-func (t *TaskSender) SendTask(message infra.TaskMessage) error {
+type sendOptions struct {
+	uniqueKey string
+	uniqueTTL time.Duration
+	coalesce  bool
+}
+
+type SendOption func(*sendOptions)
+
+// WithUnique 同一个 Type+BizID 的任务在 ttl 内只允许存在一个(排队或执行中)
+func WithUnique(ttl time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.uniqueTTL = ttl
+	}
+}
+
+// WithUniqueKey 自定义去重 key 需要和 WithUnique 一起使用
+func WithUniqueKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.uniqueKey = key
+	}
+}
+
+// WithCoalesce 重复的任务直接合并到已有任务 不返回错误
+func WithCoalesce() SendOption {
+	return func(o *sendOptions) {
+		o.coalesce = true
+	}
+}
+
+// SendTask 先落库再投递到 redis 返回 nil 表示任务已经落库 一定会被执行
+// 投递 redis 失败不返回错误(只打日志): 记录还是未投递 relay 扫描到后会补投
+// 如果返回错误 调用方重试会再落一条记录 产生重复任务
+func (t *TaskSender) SendTask(message infra.TaskMessage, opts ...SendOption) error {
+	options := new(sendOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	payload, err := json.Marshal(&message.Payload)
 	if err != nil {
 		return err
 	}
 
-	task := storage.Task{
-		Type:   message.Type,
-		BizID:  message.BizID,
-		Status: constant.TaskPending,
-		Payload: string(payload),
+	message.TaskID = utils.CreateID()
+
+	if options.uniqueTTL > 0 {
+		key := options.uniqueKey
+		if key == "" {
+			key = fmt.Sprintf("%s:%s", message.Type, message.BizID)
+		}
+		message.UniqueKey = "task:unique:" + key
+
+		ok, err := t.acquireUnique(message.UniqueKey, message.TaskID, options.uniqueTTL)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			if options.coalesce {
+				return nil
+			}
+			return errors.TaskDuplicated
+		}
+	}
+
+	task := newTask(&message, payload)
+	if err := t.db.Create(task).Error; err != nil {
+		if message.UniqueKey != "" && !t.memory {
+			t.rdb.Del(context.Background(), message.UniqueKey)
+		}
+		return err
+	}
+
+	if t.memory {
+		return nil
+	}
+
+	if err := t.enqueue(context.Background(), &message, payload); err != nil {
+		log.Printf("task %s is saved but enqueue failed, relay will publish it: %v\n", message.TaskID, err)
+		return nil
+	}
+
+	// 标记失败也没关系 relay 发现 meta 已经存在只会补标记
+	t.db.Model(&storage.Task{}).Where("id = ?", message.TaskID).Update("enqueued", true)
+
+	return nil
+}
+
+// acquireUnique 内存模式没有 redis 按还没结束的任务记录判断 不严格 只用于单机开发
+func (t *TaskSender) acquireUnique(key, taskID string, ttl time.Duration) (bool, error) {
+	if !t.memory {
+		return t.rdb.SetNX(context.Background(), key, taskID, ttl)
+	}
+
+	var count int64
+	if err := t.db.Model(&storage.Task{}).Where("unique_key = ? and status = ?", key, constant.TaskPending).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count == 0, nil
+}
+
+// SendTaskTx outbox 模式 只在调用方的事务里写任务记录 事务提交后由 relay 投递到 redis
+// 业务数据和任务要么一起落库 要么都不落库
+func (t *TaskSender) SendTaskTx(tx *gorm.DB, message infra.TaskMessage) error {
+	payload, err := json.Marshal(&message.Payload)
+	if err != nil {
+		return err
 	}
 
-	t.db.Create(&task)
-	message.TaskID = task.ID
-	
-	queue := fmt.Sprintf("scheduler:queue:%s", message.Priority)
-	meta := message.StructToMap()
+	message.TaskID = utils.CreateID()
+
+	return tx.Create(newTask(&message, payload)).Error
+}
+
+func (t *TaskSender) enqueue(ctx context.Context, message *infra.TaskMessage, payload []byte) error {
+	pipeline := t.rdb.TxPipeline()
+	pushTask(ctx, pipeline, message, payload)
+
+	_, err := pipeline.Exec(ctx)
+	return err
+}
+
+func pushTask(ctx context.Context, pipeline redis.Pipeliner, message *infra.TaskMessage, payload []byte) {
+	message.EnqueuedAt = time.Now().UnixMilli()
+
+	pipeline.HSet(ctx, "task:meta:"+message.TaskID, message.StructToMap())
+	pipeline.Set(ctx, "task:payload:"+message.TaskID, payload, -1)
+	pipeline.LPush(ctx, fmt.Sprintf("scheduler:queue:%s", message.Priority), message.TaskID)
+}
 
-	pipeline := t.rdb.Pipeline()
-	pipeline.LPush(context.Background(), queue, message.TaskID)
-	pipeline.HSet(context.Background(), "task:meta:"+message.TaskID, meta)
-	pipeline.Set(context.Background(), "task:payload:"+message.TaskID, payload, -1)
+// Cancel 取消还没结束的任务 记录标记为已取消 已经结束的任务返回 TaskFinished
+// 排队中(优先级队列/延时队列)的任务直接移除 执行中的任务通过节点的控制通道取消 handler 的 context
+// 工作流的成员被取消时整个工作流一起取消 取消工作流会取消它所有还没结束的成员
+func (t *TaskSender) Cancel(ctx context.Context, taskID string) error {
+	res := t.db.WithContext(ctx).Model(&storage.Task{}).
+		Where("id = ? and status = ?", taskID, constant.TaskPending).
+		Updates(map[string]interface{}{
+			"status":    constant.TaskCancelled,
+			"error_msg": "cancelled",
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		if _, err := t.GetTask(ctx, taskID); err != nil {
+			return err
+		}
+		return errors.TaskFinished
+	}
 
-	_, err = pipeline.Exec(context.Background())
+	task, err := t.GetTask(ctx, taskID)
 	if err != nil {
 		return err
 	}
+	if task.Type == constant.TaskWorkflow {
+		return t.cancelWorkflow(ctx, task)
+	}
+
+	// 内存模式拿不到 scheduler 进程里的队列 只标记记录 任务执行完也不会覆盖已取消的状态
+	if !t.memory {
+		keys := []string{"task:meta:" + taskID, "task:payload:" + taskID, t.delayKey, t.runningKey}
+		if err := t.rdb.Eval(ctx, cancelScript, keys, taskID, t.controlKey).Err(); err != nil {
+			return err
+		}
+
+		data, _ := json.Marshal(&infra.TaskProgress{
+			TaskID:   taskID,
+			Status:   constant.TaskCancelled,
+			ErrorMsg: "cancelled",
+		})
+		t.rdb.Publish(ctx, constant.TaskProgressChannel+taskID, data)
+	}
+
+	if task.WorkflowID == "" {
+		return nil
+	}
+	// 工作流已经结束或者正在被取消
+	if err := t.Cancel(ctx, task.WorkflowID); err != nil && !errors_.Is(err, errors.TaskFinished) {
+		return err
+	}
+
+	return nil
+}
+
+// cancelWorkflow 结束 redis 里的工作流状态 后继不会再被投递 然后逐个取消还没结束的成员
+func (t *TaskSender) cancelWorkflow(ctx context.Context, workflow *storage.Task) error {
+	if !t.memory {
+		key := "workflow:" + workflow.ID
+		pipeline := t.rdb.TxPipeline()
+		pipeline.HSet(ctx, key, "status", "cancelled")
+		pipeline.Del(ctx, key+":deps")
+		for _, k := range []string{key, key + ":next", key + ":done"} {
+			pipeline.Expire(ctx, k, workflowExpiry)
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return err
+		}
+
+		if workflow.UniqueKey != "" {
+			if err := t.rdb.Eval(ctx, releaseUniqueScript, []string{workflow.UniqueKey}, workflow.ID).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var ids []string
+	if err := t.db.WithContext(ctx).Model(&storage.Task{}).
+		Where("workflow_id = ? and status = ?", workflow.ID, constant.TaskPending).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := t.Cancel(ctx, id); err != nil && !errors_.Is(err, errors.TaskFinished) {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// CancelByBizID 取消业务ID下所有还没结束的任务 types 为空时不限类型 返回取消的数量
+func (t *TaskSender) CancelByBizID(ctx context.Context, bizID string, types ...string) (int, error) {
+	var ids []string
+	db := t.db.WithContext(ctx).Model(&storage.Task{}).Where("biz_id = ? and status = ?", bizID, constant.TaskPending)
+	if len(types) > 0 {
+		db = db.Where("type in ?", types)
+	}
+	if err := db.Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, id := range ids {
+		if err := t.Cancel(ctx, id); err != nil {
+			// 查询之后刚好执行完了
+			if errors_.Is(err, errors.TaskFinished) {
+				continue
+			}
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// GetTask 任务记录 进度和结果都在里面
+func (t *TaskSender) GetTask(ctx context.Context, taskID string) (*storage.Task, error) {
+	var task storage.Task
+	if err := t.db.WithContext(ctx).Where("id = ?", taskID).First(&task).Error; err != nil {
+		if errors_.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.TaskNotFound
+		}
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+// ListTasks 同一个业务ID下的任务 最新的在前
+func (t *TaskSender) ListTasks(ctx context.Context, bizID string, limit int) ([]storage.Task, error) {
+	tasks := make([]storage.Task, 0)
+	if err := t.db.WithContext(ctx).Where("biz_id = ?", bizID).
+		Order("created_at desc").
+		Limit(limit).
+		Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// SubscribeProgress 订阅任务的进度事件 调用方负责 Close
+func (t *TaskSender) SubscribeProgress(ctx context.Context, taskID string) *redis.PubSub {
+	return t.rdb.Subscribe(ctx, constant.TaskProgressChannel+taskID)
+}
+
+func newTask(message *infra.TaskMessage, payload []byte) *storage.Task {
+	return &storage.Task{
+		BaseModel:  storage.BaseModel{ID: message.TaskID},
+		Type:       message.Type,
+		BizID:      message.BizID,
+		Priority:   message.Priority,
+		Status:     constant.TaskPending,
+		Payload:    string(payload),
+		Timeout:    message.Timeout,
+		UniqueKey:  message.UniqueKey,
+		WorkflowID: message.WorkflowID,
+		BatchID:    message.BatchID,
+	}
+}
+
+// SendWorkflow 工作流和所有成员在一个事务里落库 都是未投递状态
+// relay 初始化工作流的运行时状态并投递没有依赖的任务 其余任务等依赖全部成功后由 worker 投递
+// opts 作用在工作流本身 比如 WithUnique 防止同一个业务重复提交 合并到已有工作流时返回空ID
+// 返回工作流记录(type 为 workflow)的ID
+func (t *TaskSender) SendWorkflow(workflow *infra.Workflow, opts ...SendOption) (string, error) {
+	if err := workflow.Validate(); err != nil {
+		return "", err
+	}
+
+	options := new(sendOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	record := &storage.Task{
+		BaseModel: storage.BaseModel{ID: utils.CreateID()},
+		Type:      constant.TaskWorkflow,
+		BizID:     workflow.BizID,
+		Status:    constant.TaskPending,
+	}
+
+	// 成员的ID提前生成并写进定义 relay 按定义还原依赖关系 不修改调用方的 workflow
+	definition := &infra.Workflow{BizID: workflow.BizID, Tasks: make([]infra.WorkflowTask, 0, len(workflow.Tasks))}
+	tasks := make([]*storage.Task, 0, len(workflow.Tasks))
+	for _, node := range workflow.Tasks {
+		node.Message.TaskID = utils.CreateID()
+		node.Message.WorkflowID = record.ID
+		if node.Message.Priority == "" {
+			node.Message.Priority = "default"
+		}
+
+		payload, err := json.Marshal(&node.Message.Payload)
+		if err != nil {
+			return "", err
+		}
+
+		definition.Tasks = append(definition.Tasks, node)
+		tasks = append(tasks, newTask(&node.Message, payload))
+	}
+
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return "", err
+	}
+	record.Payload = string(data)
+
+	if options.uniqueTTL > 0 {
+		key := options.uniqueKey
+		if key == "" {
+			key = fmt.Sprintf("%s:%s", constant.TaskWorkflow, workflow.BizID)
+		}
+		record.UniqueKey = "task:unique:" + key
+
+		ok, err := t.acquireUnique(record.UniqueKey, record.ID, options.uniqueTTL)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			if options.coalesce {
+				return "", nil
+			}
+			return "", errors.TaskDuplicated
+		}
+	}
+
+	if err := t.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(tasks, batchChunk).Error
+	}); err != nil {
+		if record.UniqueKey != "" && !t.memory {
+			t.rdb.Del(context.Background(), record.UniqueKey)
+		}
+		return "", err
+	}
+
+	return record.ID, nil
+}
+
+// 批量任务每次写库和投递的子任务数
+const batchChunk = 500
+
+// SendBatch 父任务和所有子任务在一个事务里落库 然后分批投递子任务 投递失败的由 relay 补投
+// 子任务全部结束后由 scheduler 完成父任务并投递回调 返回父任务ID
+func (t *TaskSender) SendBatch(batch *infra.Batch) (string, error) {
+	if err := batch.Validate(); err != nil {
+		return "", err
+	}
+
+	// 子任务在各自的记录里 父任务只保存回调
+	definition, err := json.Marshal(&infra.Batch{BizID: batch.BizID, Callback: batch.Callback})
+	if err != nil {
+		return "", err
+	}
+
+	record := storage.Task{
+		BaseModel: storage.BaseModel{ID: utils.CreateID()},
+		Type:      constant.TaskBatch,
+		BizID:     batch.BizID,
+		Status:    constant.TaskPending,
+		Payload:   string(definition),
+		Enqueued:  true,
+	}
+
+	messages := make([]infra.TaskMessage, 0, len(batch.Tasks))
+	payloads := make([][]byte, 0, len(batch.Tasks))
+	tasks := make([]*storage.Task, 0, len(batch.Tasks))
+	for _, message := range batch.Tasks {
+		message.TaskID = utils.CreateID()
+		message.BatchID = record.ID
+		if message.Priority == "" {
+			message.Priority = "default"
+		}
+
+		payload, err := json.Marshal(&message.Payload)
+		if err != nil {
+			return "", err
+		}
+
+		messages = append(messages, message)
+		payloads = append(payloads, payload)
+		tasks = append(tasks, newTask(&message, payload))
+	}
+
+	if err := t.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(tasks, batchChunk).Error
+	}); err != nil {
+		return "", err
+	}
+
+	if t.memory {
+		return record.ID, nil
+	}
+
+	// 记录已经落库 和 SendTask 一样投递失败不返回错误
+	ctx := context.Background()
+	for start := 0; start < len(messages); start += batchChunk {
+		end := start + batchChunk
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		pipeline := t.rdb.TxPipeline()
+		ids := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			pushTask(ctx, pipeline, &messages[i], payloads[i])
+			ids = append(ids, messages[i].TaskID)
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return record.ID, nil
+		}
+
+		t.db.Model(&storage.Task{}).Where("id in ?", ids).Update("enqueued", true)
+	}
+
+	return record.ID, nil
+}
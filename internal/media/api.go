@@ -8,11 +8,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
 	"gorm.io/gorm"
+	"io"
 	"path"
 	"sort"
 	"strconv"
 	"stream_hub/internal/infra"
 	"stream_hub/pkg/constant"
+	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/model/api"
 	"stream_hub/pkg/model/config"
 	infra_ "stream_hub/pkg/model/infra"
@@ -276,23 +278,214 @@ func (m *MediaApi) CompleteUpload(ctx *gin.Context) {
 	var video storage.FileModel
 	m.DB.Where("file_hash = ?", req.FileHash).First(&video)
 
-	// 发送转码任务
-	if err := m.TaskSender.SendTask(infra_.TaskMessage{
-		Type:    constant.TaskVideoTranscode,
-		BizID:   video.ID,
-		Priority: "critical",
-		RetryCount: 0,
-		Payload: infra_.TaskPayload{
-			Operator: "",
-			Source: constant.Media,
-			Data: nil,
-		},
-	}); err != nil {
+	// 探测 -> 转码/封面(并行) -> 审核 作为一个工作流提交 任何一步进入死信整个流程失败
+	// 重复提交时合并到已有的工作流
+	payload := infra_.TaskPayload{
+		Operator: ctx.GetString("user_id"),
+		Source:   constant.Media,
+	}
+	workflow := infra_.NewWorkflow(video.ID).
+		Add("probe", infra_.TaskMessage{Type: constant.TaskVideoProbe, BizID: video.ID, Priority: "critical", Payload: payload}).
+		Add("transcode", infra_.TaskMessage{Type: constant.TaskVideoTranscode, BizID: video.ID, Priority: "critical", Payload: payload}, "probe").
+		Add("thumbnail", infra_.TaskMessage{Type: constant.TaskVideoThumbnail, BizID: video.ID, Priority: "critical", Payload: payload}, "probe").
+		Add("audit", infra_.TaskMessage{Type: constant.TaskVideoAudit, BizID: video.ID, Priority: "default", Payload: payload}, "transcode")
+	if _, err := m.TaskSender.SendWorkflow(workflow, infra.WithUnique(time.Hour), infra.WithUniqueKey("upload:"+video.ID), infra.WithCoalesce()); err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, api.CompleteUploadResp{FileID: video.ID}, "finish uploading successfully")
+}
+
+func (m *MediaApi) GetTask(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	task, err := m.getOwnTask(context.Background(), ctx.GetString("user_id"), req.TaskID)
+	if err != nil {
+		if errors.Is(err, errors_.TaskNotFound) {
+			utils.BadRequest(ctx, "task not found")
+			return
+		}
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	utils.StatusOK(ctx, task, "get task successfully")
+}
+
+func (m *MediaApi) ListTasks(ctx *gin.Context) {
+	var req api.ListTasksReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 100 {
+		req.Limit = 20
+	}
+
+	uid := ctx.GetString("user_id")
+	owned, err := m.ownsBiz(context.Background(), uid, req.BizID)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	tasks, err := m.TaskSender.ListTasks(context.Background(), req.BizID, req.Limit)
+	if err != nil {
+		utils.InternalServerError(ctx)
+		return
+	}
+
+	// 业务对象不是自己的 只能看到自己发起的任务
+	if !owned {
+		own := make([]storage.Task, 0, len(tasks))
+		for i := range tasks {
+			if operator(&tasks[i]) == uid {
+				own = append(own, tasks[i])
+			}
+		}
+		tasks = own
+	}
+
+	utils.StatusOK(ctx, tasks, "list tasks successfully")
+}
+
+// TaskEvents SSE 推送任务进度 任务结束(成功/失败)后关闭连接
+// 进度来自 redis 频道 另外定时读一次任务记录 防止丢消息或者 scheduler 跑在内存模式
+func (m *MediaApi) TaskEvents(ctx *gin.Context) {
+	var req api.TaskIDReq
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+
+	reqCtx := ctx.Request.Context()
+	sub := m.TaskSender.SubscribeProgress(reqCtx, req.TaskID)
+	defer sub.Close()
+
+	// 先订阅再读快照 两者之间的事件不会丢
+	task, err := m.getOwnTask(reqCtx, ctx.GetString("user_id"), req.TaskID)
+	if err != nil {
+		if errors.Is(err, errors_.TaskNotFound) {
+			utils.BadRequest(ctx, "task not found")
+			return
+		}
 		utils.InternalServerError(ctx)
 		return
 	}
 
-	utils.StatusOK(ctx, api.CompleteUploadResp{}, "finish uploading successfully")
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	ctx.SSEvent("progress", taskProgress(task))
+	ctx.Writer.Flush()
+	if task.Status != constant.TaskPending {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	messages := sub.Channel()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-reqCtx.Done():
+			return false
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+
+			var event infra_.TaskProgress
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				return true
+			}
+			if event.Status == constant.TaskPending {
+				ctx.SSEvent("progress", event)
+				return true
+			}
+		case <-ticker.C:
+		}
+
+		// 定时检查或者收到了结束事件 以任务记录为准 结束时带上结果
+		task, err := m.TaskSender.GetTask(reqCtx, req.TaskID)
+		if err != nil {
+			return !errors.Is(err, errors_.TaskNotFound)
+		}
+		ctx.SSEvent("progress", taskProgress(task))
+
+		return task.Status == constant.TaskPending
+	})
+}
+
+// getOwnTask 只能查看自己的任务 不是自己的和不存在一样返回 TaskNotFound
+// 自己发起的(payload 里的 operator) 或者业务对象是自己的 视频看作者 文件看有没有自己的视频引用它
+func (m *MediaApi) getOwnTask(ctx context.Context, uid, taskID string) (*storage.Task, error) {
+	task, err := m.TaskSender.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid != "" && operator(task) == uid {
+		return task, nil
+	}
+
+	owned, err := m.ownsBiz(ctx, uid, task.BizID)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, errors_.TaskNotFound
+	}
+
+	return task, nil
+}
+
+func (m *MediaApi) ownsBiz(ctx context.Context, uid, bizID string) (bool, error) {
+	if uid == "" {
+		return false, nil
+	}
+
+	var count int64
+	if err := m.DB.WithContext(ctx).Model(&storage.VideoModel{}).
+		Where("author_id = ?", uid).
+		Where("id = ? or source_object_key in (?)", bizID,
+			m.DB.Model(&storage.FileModel{}).Select("file_path").Where("id = ?", bizID)).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func operator(task *storage.Task) string {
+	var payload infra_.TaskPayload
+	if err := json.Unmarshal([]byte(task.Payload), &payload); err != nil {
+		return ""
+	}
+
+	return payload.Operator
+}
+
+func taskProgress(task *storage.Task) *infra_.TaskProgress {
+	event := &infra_.TaskProgress{
+		TaskID:   task.ID,
+		Status:   task.Status,
+		Progress: task.Progress,
+		Msg:      task.ProgressMsg,
+		ErrorMsg: task.ErrorMsg,
+	}
+	if task.Result != "" {
+		event.Result = json.RawMessage(task.Result)
+	}
+
+	return event
 }
 
 func (m *MediaApi) GenerateObjectName(fileName string) string {
@@ -3,22 +3,28 @@ package media
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	metric_sdk "stream_hub/internal/components/metric/client"
 	"stream_hub/internal/infra"
 	"stream_hub/internal/security"
+	"stream_hub/pkg/constant"
 	"stream_hub/pkg/model/config"
 )
 
 type MediaRouter struct {
-	router     *gin.Engine
-	media      *MediaApi
-	middleware *Middleware
-	port       int
+	router      *gin.Engine
+	media       *MediaApi
+	middleware  *Middleware
+	metrics     *metric_sdk.Metrics
+	metricsConf config.MetricsConfig
+	port        int
 }
 
 func NewMediaRouter(base *infra.Base, conf *config.MediaConfig, auth *security.Auth) *MediaRouter {
 	r := new(MediaRouter)
 	r.media = NewMediaApi(base, conf)
 	r.middleware = NewMiddleware(base, auth)
+	r.metrics = metric_sdk.NewMetrics()
+	r.metricsConf = conf.Metrics
 	r.port = conf.Port
 	r.init()
 
@@ -27,6 +33,8 @@ func NewMediaRouter(base *infra.Base, conf *config.MediaConfig, auth *security.A
 
 func (r *MediaRouter) init() {
 	r.router = gin.Default()
+	r.router.Use(metric_sdk.NewHTTPMetrics(r.metrics, constant.Media).Middleware())
+
 	r.router.Use(r.middleware.Cors(), r.middleware.LogToStorage())
 	media := r.router.Group("/media").Use(r.middleware.Auth())
 	{
@@ -34,9 +42,21 @@ func (r *MediaRouter) init() {
 		media.POST("init_upload", r.media.InitUpload)
 		media.POST("upload_chunk", r.media.UploadChunk)
 		media.POST("complete_upload", r.media.CompleteUpload)
+		media.GET("task/:task_id", r.media.GetTask)
+		media.GET("task/:task_id/events", r.media.TaskEvents)
+		media.GET("tasks", r.media.ListTasks)
 	}
 }
 
 func (r *MediaRouter) Run() error {
+	// /metrics 只在内部端口上暴露
+	if r.metricsConf.Enabled {
+		go func() {
+			if err := r.metrics.Serve(r.metricsConf.Port); err != nil {
+				fmt.Println("metrics err:", err)
+			}
+		}()
+	}
+
 	return r.router.Run(fmt.Sprintf(":%d", r.port))
 }
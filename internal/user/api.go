@@ -180,7 +180,11 @@ func (u *UserApi) SendVerificationCode(ctx *gin.Context) {
 			Source: constant.User,
 			Data: nil,
 		},
-	}); err != nil {
+	}, infra.WithUnique(time.Minute)); err != nil {
+		if errors.Is(err, errors_.TaskDuplicated) {
+			utils.BadRequest(ctx, "verification code is sending, please try again later")
+			return
+		}
 		utils.BadRequest(ctx, "send task failed")
 		return
 	}
@@ -3,23 +3,29 @@ package user
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	metric_sdk "stream_hub/internal/components/metric/client"
 	"stream_hub/internal/infra"
 	"stream_hub/internal/security"
+	"stream_hub/pkg/constant"
 	"stream_hub/pkg/model/config"
 )
 
 type UserRouter struct {
-	router     *gin.Engine
-	user       *UserApi
-	middleware *Middleware
-	port       int
+	router      *gin.Engine
+	user        *UserApi
+	middleware  *Middleware
+	metrics     *metric_sdk.Metrics
+	metricsConf config.MetricsConfig
+	port        int
 }
 
 func NewUserRouter(base *infra.Base, auth *security.Auth, conf *config.UserConfig) *UserRouter {
 	router := &UserRouter{
-		port:       conf.Port,
-		user:       NewUserApi(base, auth),
-		middleware: NewMiddleware(base, auth),
+		port:        conf.Port,
+		user:        NewUserApi(base, auth),
+		middleware:  NewMiddleware(base, auth),
+		metrics:     metric_sdk.NewMetrics(),
+		metricsConf: conf.Metrics,
 	}
 
 	router.init()
@@ -29,6 +35,8 @@ func NewUserRouter(base *infra.Base, auth *security.Auth, conf *config.UserConfi
 
 func (r *UserRouter) init() {
 	r.router = gin.Default()
+	r.router.Use(metric_sdk.NewHTTPMetrics(r.metrics, constant.User).Middleware())
+
 	r.router.Use(r.middleware.Cors(), r.middleware.LogToStorage())
 
 	user := r.router.Group("/user")
@@ -51,5 +59,14 @@ func (r *UserRouter) init() {
 }
 
 func (r *UserRouter) Run() error {
+	// /metrics 只在内部端口上暴露
+	if r.metricsConf.Enabled {
+		go func() {
+			if err := r.metrics.Serve(r.metricsConf.Port); err != nil {
+				fmt.Println("metrics err:", err)
+			}
+		}()
+	}
+
 	return r.router.Run(fmt.Sprintf(":%d", r.port))
 }
@@ -3,10 +3,14 @@ package video
 import (
 	"context"
 	"errors"
+	"log"
+	errors_ "stream_hub/pkg/errors"
 	"stream_hub/pkg/utils"
 	"time"
 
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"stream_hub/internal/infra"
 	"stream_hub/internal/proto/video"
@@ -36,7 +40,56 @@ func (v *Video) CreateVideo(ctx context.Context, req *video.CreateVideoRequest,
 		CoverUrl:        req.CoverUrl,
 	}
 
-	if err := v.DB.Create(&model).Error; err != nil {
+	// 视频和同步 es 的任务在同一个事务里落库 由 scheduler 的 relay 投递
+	if err := v.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁住文件行 和 probe/thumbnail 任务回写互斥 已经有的元数据和封面直接复制 否则由任务回填
+		var media storage.FileModel
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("file_path = ?", req.SourceObjectKey).First(&media).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if media.Status == constant.FileStatusFailed {
+			return errors_.MediaInvalid
+		}
+		if len(media.Meta) > 0 {
+			model.Duration = media.Duration
+			model.VideoMeta = media.Meta
+		}
+		if model.CoverUrl == "" {
+			model.CoverUrl = media.CoverUrl
+		}
+
+		if err := tx.Create(&model).Error; err != nil {
+			return err
+		}
+
+		// 文件已经转码完成(秒传或者复用)直接送审 否则由转码任务完成后投递
+		if media.Status == constant.FileStatusTranscodeFinished {
+			if err := v.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+				Type:     constant.TaskVideoAudit,
+				BizID:    model.ID,
+				Priority: "default",
+				Payload: infra_.TaskPayload{
+					Source: constant.Video,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		return v.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+			Type:    constant.TaskVideoToES,
+			BizID:   model.ID,
+			Priority: "critical",
+			RetryCount: 0,
+			Payload: infra_.TaskPayload{
+				Operator: "",
+				Action: constant.ActionCreate,
+				Source: constant.Video,
+				Data: nil,
+			},
+		})
+	}); err != nil {
 		return err
 	}
 
@@ -53,18 +106,7 @@ func (v *Video) CreateVideo(ctx context.Context, req *video.CreateVideoRequest,
 		Timestamp:    time.Now().Unix(),
 	})
 
-	return v.TaskSender.SendTask(infra_.TaskMessage{
-		Type:    constant.TaskVideoToES,
-		BizID:   model.ID,
-		Priority: "critical",
-		RetryCount: 0,
-		Payload: infra_.TaskPayload{
-			Operator: "",
-			Action: constant.ActionCreate,
-			Source: constant.Video,
-			Data: nil,
-		},
-	})
+	return nil
 }
 
 func (v *Video) GetVideo(ctx context.Context, req *video.GetVideoRequest, resp *video.GetVideoResponse) error {
@@ -105,14 +147,61 @@ func (v *Video) UpdateVideo(ctx context.Context, req *video.UpdateVideoRequest,
 
 	uid := ctx.Value("user_id").(string)
 
-	if err := v.DB.Model(&storage.VideoModel{}).
-		Where("id = ? and author_id = ?", req.VideoId, uid).
-		Updates(map[string]interface{}{
+	if err := v.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁住视频行 和审核结论的回写互斥
+		var record storage.VideoModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? and author_id = ?", req.VideoId, uid).First(&record).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
 			"title":       req.Title,
 			"description": req.Description,
-			"cover_url":   req.CoverUrl,
 			"is_public":   req.IsPublic,
-		}).Error; err != nil {
+		}
+		// 没有传封面时保留原来的(可能是缩略图任务回填的)
+		if req.CoverUrl != "" {
+			updates["cover_url"] = req.CoverUrl
+		}
+
+		// 标题或简介变了要重新审核 封禁的视频由人工处理 不重新审核
+		reaudit := (record.Title != req.Title || record.Description != req.Description) &&
+			record.Status != constant.VideoBanned
+		if reaudit {
+			updates["status"] = constant.VideoChecking
+		}
+
+		if err := tx.Model(&storage.VideoModel{}).Where("id = ?", record.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if reaudit {
+			if err := v.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+				Type:     constant.TaskVideoAudit,
+				BizID:    record.ID,
+				Priority: "default",
+				Payload: infra_.TaskPayload{
+					Source: constant.Video,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		return v.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+			Type:    constant.TaskVideoToES,
+			BizID:   req.VideoId,
+			Priority: "critical",
+			RetryCount: 0,
+			Payload: infra_.TaskPayload{
+				Operator: "",
+				Action: constant.ActionUpdate,
+				Source: constant.Video,
+				Data: nil,
+			},
+		})
+	}); err != nil {
 		return err
 	}
 
@@ -123,47 +212,80 @@ func (v *Video) UpdateVideo(ctx context.Context, req *video.UpdateVideoRequest,
 
 	v.fillAuthorVideoInfo(resp, &model)
 
-	return v.TaskSender.SendTask(infra_.TaskMessage{
-		Type:    constant.TaskVideoToES,
-		BizID:   model.ID,
-		Priority: "critical",
-		RetryCount: 0,
-		Payload: infra_.TaskPayload{
-			Operator: "",
-			Action: constant.ActionUpdate,
-			Source: constant.Video,
-			Data: nil,
-		},
-	})
+	return nil
 }
 
 func (v *Video) DeleteVideo(ctx context.Context, req *video.DeleteVideoRequest, resp *video.DeleteVideoResponse) error {
 
 	uid := ctx.Value("user_id").(string)
 
-	if err := v.DB.
-		Where("id = ? and author_id = ?", req.VideoId, uid).
-		Delete(&storage.VideoModel{}).Error; err != nil {
+	// 还没执行完的索引任务和转码任务 事务提交后取消 否则索引任务会把文档重新写回 ES
+	var pending []string
+	if err := v.DB.Transaction(func(tx *gorm.DB) error {
+		var record storage.VideoModel
+		if err := tx.Where("id = ? and author_id = ?", req.VideoId, uid).First(&record).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := tx.Delete(&record).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&storage.Task{}).
+			Where("biz_id = ? and type in ? and status = ?", req.VideoId,
+				[]string{constant.TaskVideoToES, constant.TaskVideoAudit}, constant.TaskPending).
+			Pluck("id", &pending).Error; err != nil {
+			return err
+		}
+
+		// 同一个文件可能被多个视频引用 没有别的视频在用才取消转码
+		var refs int64
+		if err := tx.Model(&storage.VideoModel{}).Where("source_object_key = ?", record.SourceObjectKey).Count(&refs).Error; err != nil {
+			return err
+		}
+		if refs == 0 {
+			var transcodes []string
+			if err := tx.Model(&storage.Task{}).
+				Where("biz_id in (?) and type in ? and status = ?",
+					tx.Model(&storage.FileModel{}).Select("id").Where("file_path = ?", record.SourceObjectKey),
+					[]string{constant.TaskVideoProbe, constant.TaskVideoTranscode, constant.TaskVideoThumbnail}, constant.TaskPending).
+				Pluck("id", &transcodes).Error; err != nil {
+				return err
+			}
+			pending = append(pending, transcodes...)
+		}
+
+		return v.TaskSender.SendTaskTx(tx, infra_.TaskMessage{
+			Type:    constant.TaskVideoToES,
+			BizID:   req.VideoId,
+			Priority: "critical",
+			RetryCount: 0,
+			Payload: infra_.TaskPayload{
+				Operator: "",
+				Action: constant.ActionDelete,
+				Source: constant.Video,
+				Data: nil,
+			},
+		})
+	}); err != nil {
 		return err
 	}
 
+	for _, taskID := range pending {
+		if err := v.TaskSender.Cancel(ctx, taskID); err != nil && !errors.Is(err, errors_.TaskFinished) {
+			log.Println("err:", err)
+		}
+	}
+
 	v.Redis.Del(ctx)
 
 	resp.Success = true
 	resp.Message = "ok"
 
-	return v.TaskSender.SendTask(infra_.TaskMessage{
-		Type:    constant.TaskVideoToES,
-		BizID:   req.VideoId,
-		Priority: "critical",
-		RetryCount: 0,
-		Payload: infra_.TaskPayload{
-			Operator: "",
-			Action: constant.ActionDelete,
-			Source: constant.Video,
-			Data: nil,
-		},
-	})
+	return nil
 }
 
 func (v *Video) ListUserPublishedVideos(ctx context.Context, req *video.ListUserPublishedVideosRequest, resp *video.ListUserPublishedVideosResponse) error {
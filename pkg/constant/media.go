@@ -4,6 +4,7 @@ const (
 	FileStatusUploading = iota
 	FileStatusUploadFinished
 	FileStatusTranscodeFinished
+	FileStatusFailed // 不是有效的视频 不再转码
 )
 
 const (
@@ -12,3 +13,14 @@ const (
 	VideoRejected
 	VideoBanned
 )
+
+// 审核结论 review 表示转人工 不改变视频状态
+const (
+	AuditApprove = "approve"
+	AuditReject  = "reject"
+	AuditReview  = "review"
+	AuditBan     = "ban"
+)
+
+// AuditSystem 自动审核的 reviewer_id
+const AuditSystem = "system"
@@ -1,24 +1,51 @@
 package constant
 
 const (
-	TaskPending int8 = 0 // 待执行
-	TaskSuccess int8 = 1 // 成功
-	TaskFailed  int8 = 2 // 失败
+	TaskPending   int8 = 0 // 待执行
+	TaskSuccess   int8 = 1 // 成功
+	TaskFailed    int8 = 2 // 失败
+	TaskCancelled int8 = 3 // 已取消
 )
 
 const (
 	TaskSendEmailCode = "send_email_code"
 
+	TaskVideoProbe     = "video_probe"
 	TaskVideoTranscode = "video_transcode"
+	TaskVideoThumbnail = "video_thumbnail"
 	TaskVideoAudit     = "video_audit"
 
 	TaskSendNotify = "send_notify"
 
 	TaskVideoToES = "video_to_es"
+
+	// 定时任务
+	TaskReconcileCounts  = "reconcile_counts"
+	TaskESReindex        = "es_reindex"
+	TaskMultipartCleanup = "multipart_cleanup"
+
+	TaskWorkflow = "workflow"
+	TaskBatch    = "batch"
+)
+
+const (
+	CatchupSkip = "skip" // 错过太久的触发直接丢弃
+	CatchupOnce = "once" // 错过多次只补一次
+	CatchupAll  = "all"  // 每次都补
+)
+
+// TaskProgressChannel 任务进度的 redis 频道前缀 后面接任务ID
+const TaskProgressChannel = "task:progress:"
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常放行
+	BreakerOpen     = "open"      // 全部拒绝
+	BreakerHalfOpen = "half_open" // 放行少量探测任务
 )
 
 const (
 	ActionCreate = "action_create"
 	ActionUpdate = "action_update"
 	ActionDelete = "action_delete"
-)
\ No newline at end of file
+)
@@ -0,0 +1,5 @@
+package errors
+
+import "errors"
+
+var MediaInvalid = errors.New("media is not a valid video")
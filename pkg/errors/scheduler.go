@@ -7,4 +7,7 @@ var (
 	ErrInvalidValue = errors.New("redis: invalid value type")
 
 	ErrWaitTimeout = errors.New("wait time out")
+	ErrLockLost    = errors.New("redis: lock lost")
+
+	ErrNoTask = errors.New("broker: no task")
 )
\ No newline at end of file
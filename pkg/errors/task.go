@@ -3,3 +3,11 @@ package errors
 import errors_ "errors"
 
 var MaxRetryCount = errors_.New("max retry count")
+var TaskTimeout = errors_.New("task execution timeout")
+var TaskNotFound = errors_.New("task not found")
+var TaskFinished = errors_.New("task has finished")
+var TaskDuplicated = errors_.New("task duplicated")
+var TaskPanic = errors_.New("task handler panic")
+var TaskNoHandler = errors_.New("no handler registered")
+var TaskNotReady = errors_.New("task is not ready")
+var TaskInWorkflow = errors_.New("task belongs to a workflow")
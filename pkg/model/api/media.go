@@ -27,4 +27,10 @@ type CompleteUploadReq struct {
 
 type CompleteUploadResp struct {
 	VideoURL string `json:"video_url"`
+	FileID   string `json:"file_id"` // 转码任务的 biz_id 用来查询转码进度
+}
+
+type ListTasksReq struct {
+	BizID string `form:"biz_id" binding:"required"`
+	Limit int    `form:"limit"`
 }
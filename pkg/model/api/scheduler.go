@@ -0,0 +1,95 @@
+package api
+
+import "stream_hub/pkg/model/storage"
+
+// QueueStatsResp 各个队列的积压情况
+type QueueStatsResp struct {
+	Queues     map[string]int64     `json:"queues"`      // scheduler:queue:{priority}
+	Delay      int64                `json:"delay"`       // task:delay
+	Running    int64                `json:"running"`     // scheduler:running
+	DeadLetter int64                `json:"dead_letter"` // scheduler:dlq 中还未被消费的
+	Active     map[string]int64     `json:"active"`      // scheduler:active:worker_{id}
+	Wait       map[string]QueueWait `json:"wait"`        // 各个优先级的等待时间
+}
+
+// QueueWait count / avg 是累计出队的任务 oldest 是队列里等得最久的任务已经等了多久
+type QueueWait struct {
+	Count    int64 `json:"count"`
+	AvgMs    int64 `json:"avg_ms"`
+	OldestMs int64 `json:"oldest_ms"`
+}
+
+// TaskDetailResp 任务详情 meta 和 payload 来自 redis，record 来自 mysql
+type TaskDetailResp struct {
+	Meta    map[string]string `json:"meta"`
+	Payload string            `json:"payload"`
+	Record  *storage.Task     `json:"record"`
+}
+
+type ListDeadLetterReq struct {
+	Page int `form:"page"`
+	Size int `form:"size"`
+}
+
+type ListDeadLetterResp struct {
+	Pending      []string       `json:"pending"`       // 还在 scheduler:dlq 里等待落库的任务ID 和 tasks 用同一个分页
+	PendingTotal int64          `json:"pending_total"` // scheduler:dlq 的长度
+	Total        int64          `json:"total"`
+	Tasks        []storage.Task `json:"tasks"`
+}
+
+// DeadLetterFilter 批量重投死信的筛选条件 type 精确匹配 error_msg 包含即可 都为空时匹配全部
+type DeadLetterFilter struct {
+	Type     string `json:"type"`
+	ErrorMsg string `json:"error_msg"`
+	Limit    int    `json:"limit"` // 0 表示默认 1000
+}
+
+type RequeueDeadLettersResp struct {
+	Requeued []string `json:"requeued"`
+}
+
+type TaskIDReq struct {
+	TaskID string `uri:"task_id" binding:"required"`
+}
+
+type TaskTypeReq struct {
+	Type string `uri:"type" binding:"required"`
+}
+
+type NodeInfo struct {
+	NodeID  string           `json:"node_id"`
+	Alive   bool             `json:"alive"`
+	Workers map[string]int64 `json:"workers"` // worker_id -> active 队列长度
+}
+
+type WorkflowIDReq struct {
+	WorkflowID string `uri:"workflow_id" binding:"required"`
+}
+
+// WorkflowDetailResp 工作流记录以及它的所有成员任务
+type WorkflowDetailResp struct {
+	Workflow storage.Task   `json:"workflow"`
+	Tasks    []storage.Task `json:"tasks"`
+}
+
+type VideoIDReq struct {
+	VideoID string `uri:"video_id" binding:"required"`
+}
+
+type ListReviewsReq struct {
+	Page int `form:"page"`
+	Size int `form:"size"`
+}
+
+// ListReviewsResp 等待人工审核的视频 先进先出
+type ListReviewsResp struct {
+	Total  int64                `json:"total"`
+	Videos []storage.VideoModel `json:"videos"`
+}
+
+// AuditDecisionReq 人工审核结论 封禁可以作用于已经通过的视频
+type AuditDecisionReq struct {
+	Decision string `json:"decision" binding:"required,oneof=approve reject ban"`
+	Reason   string `json:"reason" binding:"required"`
+}
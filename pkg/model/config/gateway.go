@@ -1,9 +1,10 @@
 package config
 
 type GatewayConfig struct {
-	Name    string  `mapstructure:"name"`
-	Port    int     `mapstructure:"port"`
-	Service Service `mapstructure:"service"`
+	Name    string        `mapstructure:"name"`
+	Port    int           `mapstructure:"port"`
+	Service Service       `mapstructure:"service"`
+	Metrics MetricsConfig `mapstructure:"metrics"`
 }
 
 type Service struct {
@@ -1,6 +1,7 @@
 package config
 
 type MediaConfig struct {
-	Port      int `mapstructure:"port"`
-	ChunkSize int `mapstructure:"chunk_size"`
+	Port      int           `mapstructure:"port"`
+	ChunkSize int           `mapstructure:"chunk_size"`
+	Metrics   MetricsConfig `mapstructure:"metrics"`
 }
@@ -1,10 +1,12 @@
 package config
 
 type SchedulerConfig struct {
+	Broker            string           `mapstructure:"broker"`
 	WorkerNum         int              `mapstructure:"worker_num"`
 	HeartbeatExpiry   int              `mapstructure:"heartbeat_expiry"`
 	HeartbeatInterval int              `mapstructure:"heartbeat_interval"`
 	Concurrency       int              `mapstructure:"concurrency"`
+	DrainTimeout      int              `mapstructure:"drain_timeout"`
 	RegisterKey       string           `mapstructure:"register_key"`
 	DeathKey          string           `mapstructure:"death_key"`
 	Queue             map[string]int   `mapstructure:"queue"`
@@ -14,6 +16,16 @@ type SchedulerConfig struct {
 	Dispatcher        Dispatcher       `mapstructure:"dispatcher"`
 	Retry             RetryConfig      `mapstructure:"retry"`
 	DeadLetter        DeadLetterConfig `mapstructure:"dead_letter"`
+	Timeout           TimeoutConfig    `mapstructure:"timeout"`
+	Admin             AdminConfig      `mapstructure:"admin"`
+	Metrics           MetricsConfig    `mapstructure:"metrics"`
+	Cron              CronConfig       `mapstructure:"cron"`
+	Outbox            OutboxConfig     `mapstructure:"outbox"`
+	Limit             LimitConfig      `mapstructure:"limit"`
+	Aging             AgingConfig      `mapstructure:"aging"`
+	Transcode         TranscodeConfig  `mapstructure:"transcode"`
+	Thumbnail         ThumbnailConfig  `mapstructure:"thumbnail"`
+	Audit             AuditConfig      `mapstructure:"audit"`
 }
 
 type HealthConfig struct {
@@ -21,12 +33,14 @@ type HealthConfig struct {
 	Duration          int `mapstructure:"duration"`
 	BlacklistDuration int `mapstructure:"blacklist_duration"`
 	Delay             int `mapstructure:"delay"`
+	Probes            int `mapstructure:"probes"`
+	SuccessThreshold  int `mapstructure:"success_threshold"`
+	ProbeDelay        int `mapstructure:"probe_delay"`
 }
 
 type Lock struct {
-	DetectInterval int `mapstructure:"detect_interval"`
-	WaitDeadline   int `mapstructure:"wait_deadline"`
-	LockTimeout    int `mapstructure:"lock_timeout"`
+	RenewInterval int `mapstructure:"renew_interval"`
+	LockTimeout   int `mapstructure:"lock_timeout"`
 }
 
 type Dispatcher struct {
@@ -50,3 +64,96 @@ type DeadLetterConfig struct {
 	Enabled  bool   `mapstructure:"enabled"`
 	QueueKey string `mapstructure:"queue_key"`
 }
+
+type TimeoutConfig struct {
+	RunningKey string         `mapstructure:"running_key"`
+	ControlKey string         `mapstructure:"control_key"`
+	Default    int            `mapstructure:"default"`
+	Types      map[string]int `mapstructure:"types"`
+}
+
+type AdminConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Port    int  `mapstructure:"port"`
+}
+
+type MetricsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Port    int  `mapstructure:"port"`
+}
+
+type CronConfig struct {
+	Enabled          bool      `mapstructure:"enabled"`
+	LastKey          string    `mapstructure:"last_key"`
+	ScanInterval     int       `mapstructure:"scan_interval"`
+	Catchup          string    `mapstructure:"catchup"`
+	MaxCatchup       int       `mapstructure:"max_catchup"`
+	MisfireThreshold int       `mapstructure:"misfire_threshold"`
+	Jobs             []CronJob `mapstructure:"jobs"`
+}
+
+type CronJob struct {
+	Name     string `mapstructure:"name"`
+	Spec     string `mapstructure:"spec"`
+	Type     string `mapstructure:"type"`
+	BizID    string `mapstructure:"biz_id"`
+	Priority string `mapstructure:"priority"`
+	Catchup  string `mapstructure:"catchup"`
+}
+
+type OutboxConfig struct {
+	ScanInterval int `mapstructure:"scan_interval"`
+	BatchSize    int `mapstructure:"batch_size"`
+	Grace        int `mapstructure:"grace"`
+}
+
+type LimitConfig struct {
+	Delay int                  `mapstructure:"delay"`
+	Types map[string]TypeLimit `mapstructure:"types"`
+}
+
+// TypeLimit 0 表示不限制
+type TypeLimit struct {
+	Node    int     `mapstructure:"node"`    // 单节点同时执行
+	Cluster int     `mapstructure:"cluster"` // 集群同时执行
+	Rate    float64 `mapstructure:"rate"`    // 每秒令牌数
+	Burst   int     `mapstructure:"burst"`   // 令牌桶容量
+}
+
+type AgingConfig struct {
+	BatchSize int            `mapstructure:"batch_size"`
+	MaxWait   map[string]int `mapstructure:"max_wait"` // 优先级 -> 等待超过多久(ms)提升一级
+}
+
+// TranscodeConfig 转码成多码率 HLS 高于原视频分辨率的档位会跳过
+type TranscodeConfig struct {
+	SegmentTime int         `mapstructure:"segment_time"` // 切片时长(秒) 各档位在同样的时间点切 播放器切换码率时能对齐
+	Preset      string      `mapstructure:"preset"`       // x264 preset
+	Ladder      []Rendition `mapstructure:"ladder"`
+}
+
+type Rendition struct {
+	Name         string `mapstructure:"name"`          // 输出子目录
+	Height       int    `mapstructure:"height"`        // 宽按原视频比例计算
+	VideoBitrate int    `mapstructure:"video_bitrate"` // kbps
+	AudioBitrate int    `mapstructure:"audio_bitrate"` // kbps
+}
+
+// ThumbnailConfig 自动封面和进度条预览的雪碧图
+type ThumbnailConfig struct {
+	CoverWidth int `mapstructure:"cover_width"` // 封面最大宽度 原视频更小时不放大
+	Interval   int `mapstructure:"interval"`    // 每隔多少秒截一张预览图
+	MaxFrames  int `mapstructure:"max_frames"`  // 预览图总数上限 视频太长时加大间隔
+	Width      int `mapstructure:"width"`       // 预览图宽度 高按比例
+	Columns    int `mapstructure:"columns"`     // 每张雪碧图的列数
+	Rows       int `mapstructure:"rows"`        // 每张雪碧图的行数
+}
+
+// AuditConfig 视频自动审核 命中 block_words 直接拒绝 命中 review_words 转人工
+type AuditConfig struct {
+	BlockWords  []string `mapstructure:"block_words"`
+	ReviewWords []string `mapstructure:"review_words"`
+	Frames      int      `mapstructure:"frames"`       // 画面审核均匀截取的帧数
+	ReviewScore float64  `mapstructure:"review_score"` // 画面分类得分超过它转人工
+	RejectScore float64  `mapstructure:"reject_score"` // 超过它直接拒绝
+}
@@ -1,5 +1,6 @@
 package config
 
 type UserConfig struct {
-	Port int `yaml:"port"`
+	Port    int           `yaml:"port"`
+	Metrics MetricsConfig `yaml:"metrics"`
 }
@@ -0,0 +1,57 @@
+package infra
+
+import "fmt"
+
+// Batch 一个父任务扇出多个互不依赖的子任务 子任务全部结束(成功/失败/取消)后父任务完成
+// Callback 不为空时在父任务完成后投递 它的 Payload.Data 会被替换成 BatchResult
+type Batch struct {
+	BizID    string        `json:"biz_id"`
+	Tasks    []TaskMessage `json:"tasks,omitempty"`
+	Callback *TaskMessage  `json:"callback,omitempty"`
+}
+
+// BatchResult 父任务的执行结果 写在父任务记录的 result 里
+type BatchResult struct {
+	BatchID   string `json:"batch_id"`
+	Total     int64  `json:"total"`
+	Success   int64  `json:"success"`
+	Failed    int64  `json:"failed"`
+	Cancelled int64  `json:"cancelled"`
+}
+
+func NewBatch(bizID string) *Batch {
+	return &Batch{BizID: bizID}
+}
+
+// Add 添加子任务
+func (b *Batch) Add(messages ...TaskMessage) *Batch {
+	b.Tasks = append(b.Tasks, messages...)
+	return b
+}
+
+// OnComplete 父任务完成后投递的回调任务 不管子任务是否有失败都会投递
+func (b *Batch) OnComplete(callback TaskMessage) *Batch {
+	b.Callback = &callback
+	return b
+}
+
+func (b *Batch) Validate() error {
+	if len(b.Tasks) == 0 {
+		return fmt.Errorf("batch has no task")
+	}
+
+	for _, task := range b.Tasks {
+		if task.Type == "" {
+			return fmt.Errorf("batch task has no type")
+		}
+		if task.WorkflowID != "" {
+			return fmt.Errorf("batch task can not belong to workflow %s", task.WorkflowID)
+		}
+	}
+
+	if b.Callback != nil && b.Callback.Type == "" {
+		return fmt.Errorf("batch callback has no type")
+	}
+
+	return nil
+}
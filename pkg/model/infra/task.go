@@ -13,6 +13,21 @@ type TaskMessage struct {
 	Priority   string      `json:"priority"`
 	Payload    TaskPayload `json:"payload"`
 	RetryCount int         `json:"retry_count"`
+	Timeout    int64       `json:"timeout"` // 执行超时(ms)，0 表示使用 scheduler.yaml 中按类型配置的默认值
+	WorkflowID string      `json:"workflow_id"`
+	BatchID    string      `json:"batch_id"`    // 所属批量任务(父任务ID)
+	UniqueKey  string      `json:"unique_key"` // 去重锁的 key 任务结束或进入死信后释放
+	EnqueuedAt int64       `json:"enqueued_at"` // 进入优先级队列的时间(ms) 用来计算等待时间和老化
+}
+
+// TaskProgress 任务进度事件 scheduler 发布到 task:progress:{id} 频道 SSE 推给客户端
+type TaskProgress struct {
+	TaskID   string          `json:"task_id"`
+	Status   int8            `json:"status"` // 同 storage.Task.Status
+	Progress int             `json:"progress"`
+	Msg      string          `json:"msg"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	ErrorMsg string          `json:"error_msg,omitempty"`
 }
 
 type TaskPayload struct {
@@ -31,12 +46,31 @@ func (t *TaskMessage) TransformByMap(data map[string]string) error {
 	t.Type = data["type"]
 	t.BizID = data["biz_id"]
 	t.Priority = data["priority"]
+	t.WorkflowID = data["workflow_id"]
+	t.BatchID = data["batch_id"]
+	t.UniqueKey = data["unique_key"]
 	retryCount, err := strconv.Atoi(data["retry_count"])
 	if err != nil {
 		return errors.New("invalid retry_count: " + err.Error())
 	}
 	t.RetryCount = retryCount
 
+	if data["enqueued_at"] != "" {
+		enqueuedAt, err := strconv.ParseInt(data["enqueued_at"], 10, 64)
+		if err != nil {
+			return errors.New("invalid enqueued_at: " + err.Error())
+		}
+		t.EnqueuedAt = enqueuedAt
+	}
+
+	if data["timeout"] != "" {
+		timeout, err := strconv.ParseInt(data["timeout"], 10, 64)
+		if err != nil {
+			return errors.New("invalid timeout: " + err.Error())
+		}
+		t.Timeout = timeout
+	}
+
 	return nil
 }
 
@@ -47,5 +81,10 @@ func (t *TaskMessage) StructToMap() map[string]interface{} {
         "biz_id":      t.BizID,
         "priority":    t.Priority,
         "retry_count": t.RetryCount,
+        "timeout":     t.Timeout,
+        "workflow_id": t.WorkflowID,
+        "batch_id":    t.BatchID,
+        "unique_key":  t.UniqueKey,
+        "enqueued_at": t.EnqueuedAt,
     }
 }
@@ -0,0 +1,115 @@
+package infra
+
+import "fmt"
+
+// Workflow 由多个任务组成的有向无环图 一个任务只有在它依赖的任务全部成功后才会被投递
+type Workflow struct {
+	BizID string         `json:"biz_id"`
+	Tasks []WorkflowTask `json:"tasks"`
+}
+
+type WorkflowTask struct {
+	Name      string      `json:"name"`
+	Message   TaskMessage `json:"message"`
+	DependsOn []string    `json:"depends_on"`
+}
+
+func NewWorkflow(bizID string) *Workflow {
+	return &Workflow{BizID: bizID}
+}
+
+// Add 添加一个任务 dependsOn 为它依赖的任务名
+func (w *Workflow) Add(name string, message TaskMessage, dependsOn ...string) *Workflow {
+	w.Tasks = append(w.Tasks, WorkflowTask{
+		Name:      name,
+		Message:   message,
+		DependsOn: dependsOn,
+	})
+
+	return w
+}
+
+// NewChain 按顺序依次执行 前一个成功后才投递后一个
+func NewChain(bizID string, messages ...TaskMessage) *Workflow {
+	w := NewWorkflow(bizID)
+	prev := ""
+	for i, message := range messages {
+		name := fmt.Sprintf("%d_%s", i, message.Type)
+		if prev == "" {
+			w.Add(name, message)
+		} else {
+			w.Add(name, message, prev)
+		}
+		prev = name
+	}
+
+	return w
+}
+
+// NewGroup messages 并行执行 全部成功后投递 callback
+func NewGroup(bizID string, callback *TaskMessage, messages ...TaskMessage) *Workflow {
+	w := NewWorkflow(bizID)
+	names := make([]string, 0, len(messages))
+	for i, message := range messages {
+		name := fmt.Sprintf("%d_%s", i, message.Type)
+		w.Add(name, message)
+		names = append(names, name)
+	}
+
+	if callback != nil {
+		w.Add("callback_"+callback.Type, *callback, names...)
+	}
+
+	return w
+}
+
+// Validate 名字不能重复 依赖必须存在 不能有环
+func (w *Workflow) Validate() error {
+	if len(w.Tasks) == 0 {
+		return fmt.Errorf("workflow has no task")
+	}
+
+	indegree := make(map[string]int, len(w.Tasks))
+	for _, task := range w.Tasks {
+		if _, ok := indegree[task.Name]; ok {
+			return fmt.Errorf("duplicate workflow task %s", task.Name)
+		}
+		indegree[task.Name] = len(task.DependsOn)
+	}
+
+	successors := make(map[string][]string, len(w.Tasks))
+	for _, task := range w.Tasks {
+		for _, dep := range task.DependsOn {
+			if _, ok := indegree[dep]; !ok {
+				return fmt.Errorf("workflow task %s depends on unknown task %s", task.Name, dep)
+			}
+			successors[dep] = append(successors[dep], task.Name)
+		}
+	}
+
+	queue := make([]string, 0)
+	for name, n := range indegree {
+		if n == 0 {
+			queue = append(queue, name)
+		}
+	}
+
+	visited := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, next := range successors[name] {
+			indegree[next]--
+			if indegree[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	if visited != len(w.Tasks) {
+		return fmt.Errorf("workflow has a cycle")
+	}
+
+	return nil
+}
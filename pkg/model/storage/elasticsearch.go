@@ -0,0 +1,64 @@
+package storage
+
+import "time"
+
+// VideoDoc video 索引里的文档 由 video_to_es 任务按 mysql 里的 VideoModel 同步
+type VideoDoc struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	AuthorID    string    `json:"author_id"`
+	CoverUrl    string    `json:"cover_url"`
+	Status      int       `json:"status"`
+	IsPublic    int       `json:"is_public"`
+	Duration    int64     `json:"duration"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func NewVideoDoc(video *VideoModel) *VideoDoc {
+	return &VideoDoc{
+		ID:          video.ID,
+		Title:       video.Title,
+		Description: video.Description,
+		AuthorID:    video.AuthorID,
+		CoverUrl:    video.CoverUrl,
+		Status:      video.Status,
+		IsPublic:    video.IsPublic,
+		Duration:    video.Duration,
+		CreatedAt:   video.CreatedAt,
+		UpdatedAt:   video.UpdatedAt,
+	}
+}
+
+// VideoIndexMapping video 索引的 settings 和 mapping
+// 标题和简介用内置的 standard 分词加 cjk_bigram 中日韩文字按相邻两个字切词 不依赖 ik 等插件
+// 改了 mapping 需要执行 es_index -reindex 建新版本的索引再切别名
+const VideoIndexMapping = `{
+  "settings": {
+    "analysis": {
+      "analyzer": {
+        "video_text": {
+          "type": "custom",
+          "tokenizer": "standard",
+          "filter": ["cjk_width", "lowercase", "cjk_bigram"]
+        }
+      }
+    }
+  },
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "id":          {"type": "keyword"},
+      "title":       {"type": "text", "analyzer": "video_text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+      "description": {"type": "text", "analyzer": "video_text"},
+      "author_id":   {"type": "keyword"},
+      "cover_url":   {"type": "keyword", "index": false},
+      "status":      {"type": "integer"},
+      "is_public":   {"type": "integer"},
+      "duration":    {"type": "long"},
+      "created_at":  {"type": "date"},
+      "updated_at":  {"type": "date"}
+    }
+  }
+}`
@@ -15,7 +15,10 @@ type BaseModel struct {
 }
 
 func (b *BaseModel) BeforeCreate(tx *gorm.DB) error {
-	b.ID = utils.CreateID() // 统一调用你的工具类
+	// 调用方可以提前生成 ID (比如任务去重时需要先拿到 ID)
+	if b.ID == "" {
+		b.ID = utils.CreateID() // 统一调用你的工具类
+	}
 	return nil
 }
 
@@ -54,9 +57,12 @@ type Task struct {
 	BizID string `gorm:"type:varchar(128);index" json:"biz_id"`
 	// 示例：user_id / video_id / order_id
 
+	// 队列优先级 critical / default / low
+	Priority string `gorm:"type:varchar(16);default:'default'" json:"priority"`
+
 	// 任务状态
 	Status int8 `gorm:"not null;index" json:"status"`
-	// 0-待执行 1-成功 2-失败
+	// 0-待执行 1-成功 2-失败 3-已取消
 
 	// 执行次数
 	RetryCount int `gorm:"not null;default:0" json:"retry_count"`
@@ -67,6 +73,28 @@ type Task struct {
 	// 任务负载（JSON）
 	Payload string `gorm:"type:text" json:"payload"`
 
+	// 所属工作流 工作流本身也是一条 type 为 workflow 的记录
+	WorkflowID string `gorm:"type:varchar(64);index" json:"workflow_id"`
+
+	// 所属批量任务 批量任务本身也是一条 type 为 batch 的记录
+	BatchID string `gorm:"type:varchar(64);index" json:"batch_id"`
+
+	// 执行超时(ms) 0 表示按类型的默认值
+	Timeout int64 `gorm:"not null;default:0" json:"timeout"`
+
+	// 去重锁的 key
+	UniqueKey string `gorm:"type:varchar(255)" json:"unique_key"`
+
+	// 是否已经投递到 redis 没投递的由 relay 补投
+	Enqueued bool `gorm:"not null;default:false;index" json:"enqueued"`
+
+	// 执行进度 0-100 以及 handler 给出的说明
+	Progress    int    `gorm:"not null;default:0" json:"progress"`
+	ProgressMsg string `gorm:"type:varchar(255)" json:"progress_msg"`
+
+	// 执行结果（JSON） handler 通过 SetResult 写入
+	Result string `gorm:"type:text" json:"result"`
+
 	// 下次执行时间（支持延迟任务）
 	NextRunAt int64 `gorm:"index" json:"next_run_at"`
 
@@ -79,11 +107,38 @@ type Task struct {
 // 只要文件内容一致（Hash相同），该表就只有一条记录
 type FileModel struct {
 	BaseModel
-	FileHash string `gorm:"type:varchar(64);uniqueIndex;not null;comment:文件唯一哈希(MD5或SHA256)"`
-	FilePath string `gorm:"type:varchar(255);not null;comment:MinIO中的存储路径"`
-	Size     int64  `gorm:"comment:文件大小(字节)"`
-	FileType string `gorm:"type:varchar(20);comment:文件后缀名(如.mp4)"`
-	Status   int    `gorm:"default:0;comment:文件状态: 1-上传中, 2-已落地, 3-已转码"`
+	FileHash string          `gorm:"type:varchar(64);uniqueIndex;not null;comment:文件唯一哈希(MD5或SHA256)"`
+	FilePath string          `gorm:"type:varchar(255);not null;comment:MinIO中的存储路径"`
+	Size     int64           `gorm:"comment:文件大小(字节)"`
+	FileType string          `gorm:"type:varchar(20);comment:文件后缀名(如.mp4)"`
+	Status   int             `gorm:"default:0;comment:文件状态: 0-上传中, 1-已落地, 2-已转码, 3-无效文件"`
+	Duration int64           `gorm:"comment:视频时长(秒)"`
+	Meta     json.RawMessage `gorm:"type:json;comment:ffprobe 探测到的元数据 未探测时为空"`
+	Reason   string          `gorm:"type:varchar(255);comment:无效文件的原因"`
+	CoverUrl string          `gorm:"type:varchar(255);comment:自动截取的封面 创建视频时没有指定封面则使用它"`
+}
+
+// VideoMeta ffprobe 探测结果 存在 FileModel.Meta 和引用它的 VideoModel.VideoMeta 里
+// Width/Height 是编码尺寸 带旋转的视频显示时宽高互换
+type VideoMeta struct {
+	Duration      float64 `json:"duration"` // 秒
+	Format        string  `json:"format"`
+	Bitrate       int64   `json:"bitrate"` // bps
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	VideoCodec    string  `json:"video_codec"`
+	FrameRate     float64 `json:"frame_rate"`
+	Rotation      int     `json:"rotation"` // 0 / 90 / 180 / 270
+	AudioCodec    string  `json:"audio_codec,omitempty"`
+	AudioChannels int     `json:"audio_channels,omitempty"`
+}
+
+// DisplaySize 旋转之后的宽高 ffmpeg 转码时默认按旋转后的画面输出
+func (m *VideoMeta) DisplaySize() (int, int) {
+	if m.Rotation == 90 || m.Rotation == 270 {
+		return m.Height, m.Width
+	}
+	return m.Width, m.Height
 }
 
 // TableName 指定表名
@@ -100,7 +155,7 @@ type VideoModel struct {
 	AuthorID        string          `gorm:"index;comment:上传者用户ID"`
 	SourceObjectKey string          `gorm:"type:varchar(64);index;comment:原视频文件引用"`
 	CoverUrl        string          `gorm:"type:varchar(255);comment:封面图地址"`
-	Status          int             `gorm:"default:0;comment:0-待审核 1-审核通过 2-审核未通过"`
+	Status          int             `gorm:"default:0;comment:0-待审核 1-审核通过 2-审核未通过 3-封禁"`
 	IsPublic        int             `gorm:"default:0;comment:0-私密 1-开放"`
 	Duration        int64           `gorm:"comment:视频时长(秒)"`
 	VideoMeta       json.RawMessage `gorm:"type:json;not null;comment:视频原始元数据"`
@@ -121,6 +176,22 @@ func (VideoModel) TableName() string {
 	return "user_videos"
 }
 
+// VideoAuditModel 审核记录 自动审核和人工审核的每一次结论都会记录
+type VideoAuditModel struct {
+	BaseModel
+	VideoID    string          `gorm:"type:varchar(32);index;comment:视频ID" json:"video_id"`
+	Decision   string          `gorm:"type:varchar(16);index;comment:approve/reject/review/ban" json:"decision"`
+	FromStatus int             `gorm:"comment:审核前的视频状态" json:"from_status"`
+	ToStatus   int             `gorm:"comment:审核后的视频状态" json:"to_status"`
+	ReviewerID string          `gorm:"type:varchar(32);index;comment:审核人 自动审核为 system" json:"reviewer_id"`
+	Reason     string          `gorm:"type:varchar(255);comment:原因" json:"reason"`
+	Details    json.RawMessage `gorm:"type:json;comment:各个检查器的结果" json:"details"`
+}
+
+func (VideoAuditModel) TableName() string {
+	return "video_audits"
+}
+
 type VideoLikeModel struct {
 	BaseModel
 	UserID  string `gorm:"type:varchar(32);index:idx_user_video,unique;comment:点赞用户ID"`
@@ -19,7 +19,11 @@ const (
 	MessageInvalidPublicKey           = "Invalid PublicKey Please use valid PublicKey."
 )
 
+// StatusKey 响应的 HTTP 状态码都是 200 真实的状态码放在 body 里 同时记到 ctx 上给指标等中间件使用
+const StatusKey = "response_status"
+
 func BadRequest(ctx *gin.Context, message string) {
+	ctx.Set(StatusKey, http.StatusBadRequest)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusBadRequest,
 		"message": message,
@@ -30,6 +34,7 @@ func BadRequest(ctx *gin.Context, message string) {
 }
 
 func InternalServerError(ctx *gin.Context) {
+	ctx.Set(StatusKey, http.StatusInternalServerError)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusInternalServerError,
 		"data":    nil,
@@ -40,6 +45,7 @@ func InternalServerError(ctx *gin.Context) {
 }
 
 func StatusOK(ctx *gin.Context, data interface{}, message string) {
+	ctx.Set(StatusKey, http.StatusOK)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusOK,
 		"data":    data,
@@ -50,6 +56,7 @@ func StatusOK(ctx *gin.Context, data interface{}, message string) {
 }
 
 func UnAuthorizationRequest(ctx *gin.Context, message string) {
+	ctx.Set(StatusKey, http.StatusUnauthorized)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  http.StatusUnauthorized,
 		"data":    nil,
@@ -58,3 +65,14 @@ func UnAuthorizationRequest(ctx *gin.Context, message string) {
 
 	ctx.Abort()
 }
+
+func Forbidden(ctx *gin.Context, message string) {
+	ctx.Set(StatusKey, http.StatusForbidden)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusForbidden,
+		"data":    nil,
+		"message": message,
+	})
+
+	ctx.Abort()
+}